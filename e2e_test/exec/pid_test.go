@@ -0,0 +1,79 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	osexec "os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/exec/process"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+)
+
+// TestMain lets the test binary act as the pid:// helper, which is started by
+// re-executing the running binary.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == process.HelperCommand {
+		if err := process.RunHelper(os.Args[2:]); err != nil {
+			os.Stderr.WriteString(err.Error() + "\n")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// TestExecPid debugs a process running in its own PID and network namespaces
+// without any container runtime. It needs root and an OCI layout holding the
+// debugger image, e.g:
+//
+//	skopeo copy docker://busybox:musl oci:/tmp/oci:busybox:musl
+//	CONXEC_E2E_OCI_LAYOUT=/tmp/oci CONXEC_E2E_DEBUGGER_IMAGE=busybox:musl go test ./e2e_test/...
+func TestExecPid(t *testing.T) {
+	layout := os.Getenv("CONXEC_E2E_OCI_LAYOUT")
+	if layout == "" || os.Geteuid() != 0 {
+		t.Skip("CONXEC_E2E_OCI_LAYOUT is not set or not running as root")
+	}
+
+	target := osexec.Command("sleep", "300")
+	target.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWPID | syscall.CLONE_NEWNET}
+	if err := target.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer target.Process.Kill()
+
+	opts, err := exec.New([]exec.Option{
+		exec.WithTarget(strconv.Itoa(target.Process.Pid)),
+		exec.WithCommand([]string{"sh", "-c", "readlink /proc/self/ns/pid; cat /proc/net/dev"}),
+		exec.WithDebuggerImage(os.Getenv("CONXEC_E2E_DEBUGGER_IMAGE")),
+		exec.WithUser("root:0::root:0"),
+		exec.WithRuntime(layout),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), &out, io.Discard)
+	client, err := process.NewClient(context.Background(), opts, cliStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.RunDebugger(context.Background(), client, opts, cliStream); err != nil {
+		t.Fatalf("RunDebugger() error = %v", err)
+	}
+	pidns, err := os.Readlink("/proc/" + strconv.Itoa(target.Process.Pid) + "/ns/pid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the network namespace of the target only has a loopback interface
+	if !strings.HasPrefix(out.String(), pidns) || !strings.Contains(out.String(), "lo:") || strings.Contains(out.String(), "eth0") {
+		t.Errorf("output = %q, want the view of the target namespaces", out.String())
+	}
+}
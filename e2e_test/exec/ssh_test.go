@@ -0,0 +1,50 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/exec/docker"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+)
+
+// TestExecOverSSH runs a command in a container of a remote Docker host
+// reached over ssh. It needs an sshd with docker on the remote side, e.g:
+//
+//	CONXEC_E2E_SSH_HOST=ssh://root@127.0.0.1:2222 CONXEC_E2E_SSH_TARGET=nginx go test ./e2e_test/...
+func TestExecOverSSH(t *testing.T) {
+	host := os.Getenv("CONXEC_E2E_SSH_HOST")
+	target := os.Getenv("CONXEC_E2E_SSH_TARGET")
+	if host == "" || target == "" {
+		t.Skip("CONXEC_E2E_SSH_HOST and CONXEC_E2E_SSH_TARGET are not set")
+	}
+
+	opts, err := exec.New([]exec.Option{
+		exec.WithTarget(target),
+		exec.WithCommand([]string{"echo", "hello-over-ssh"}),
+		exec.WithDebuggerImage(os.Getenv("CONXEC_E2E_DEBUGGER_IMAGE")),
+		exec.WithUser("root:0::root:0"),
+		exec.WithRuntime(host),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), &out, io.Discard)
+	client, err := docker.NewClient(context.Background(), opts, cliStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.RunDebugger(context.Background(), client, opts, cliStream); err != nil {
+		t.Fatalf("RunDebugger() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "hello-over-ssh") {
+		t.Errorf("output = %q, want the command output", out.String())
+	}
+}
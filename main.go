@@ -1,9 +1,12 @@
 package main
 
 import (
+	"errors"
 	"log"
+	"os"
 
 	"github.com/debasishbsws/conxec/pkg/cmd"
+	"github.com/debasishbsws/conxec/pkg/iocli"
 )
 
 var (
@@ -13,6 +16,11 @@ var (
 
 func main() {
 	if err := cmd.New(version, commit).Execute(); err != nil {
+		// the exit status of the debugged command
+		var statusErr iocli.StatusError
+		if errors.As(err, &statusErr) {
+			os.Exit(statusErr.Code())
+		}
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,211 @@
+// Package audit writes a JSONL record of the debugger sessions: who opened
+// which session in which target, and how it ended.
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+)
+
+const (
+	// queueSize is the number of events waiting for slow sinks, the next
+	// ones are dropped
+	queueSize = 64
+	// closeTimeout is how long the pending events are written for once the
+	// session ended
+	closeTimeout = 5 * time.Second
+)
+
+// Event types.
+const (
+	EventStart  = "start"
+	EventEnd    = "end"
+	EventDetach = "detach"
+	EventStop   = "stop"
+)
+
+// Event is a line of the audit log. The local user and host are set by the
+// Logger.
+type Event struct {
+	Time                time.Time `json:"time"`
+	Event               string    `json:"event"`
+	Session             string    `json:"session"`
+	Debugger            string    `json:"debugger"`
+	User                string    `json:"user"`
+	UID                 string    `json:"uid"`
+	SudoUser            string    `json:"sudoUser,omitempty"`
+	Host                string    `json:"host"`
+	Target              string    `json:"target"`
+	TargetID            string    `json:"targetID"`
+	TargetName          string    `json:"targetName,omitempty"`
+	TargetImage         string    `json:"targetImage,omitempty"`
+	DebuggerImage       string    `json:"debuggerImage"`
+	DebuggerImageDigest string    `json:"debuggerImageDigest,omitempty"`
+	Command             []string  `json:"command"`
+	Packages            []string  `json:"packages,omitempty"`
+	MountDir            string    `json:"mountDir,omitempty"`
+	Privileged          bool      `json:"privileged"`
+	Detached            bool      `json:"detached,omitempty"`
+	ExitCode            *int      `json:"exitCode,omitempty"`
+	Error               string    `json:"error,omitempty"`
+}
+
+// Config selects the sinks of the audit log, as in the config file.
+type Config struct {
+	// File is the path of the JSONL file the events are appended to
+	File string `json:"file,omitempty"`
+	// Syslog sends the events to the local syslog, with the auth facility
+	Syslog bool `json:"syslog,omitempty"`
+	// Webhook is the URL the events are POSTed to, one JSON object each
+	Webhook string `json:"webhook,omitempty"`
+}
+
+// sink is a destination of the audit log.
+type sink interface {
+	write(line []byte) error
+	close() error
+}
+
+// Logger writes the events to the sinks in the background, so a slow or
+// broken sink never blocks the session: the events which don't fit the queue
+// are dropped and the errors are returned by Close. The methods of a nil
+// Logger do nothing.
+type Logger struct {
+	sinks    []sink
+	events   chan Event
+	done     chan struct{}
+	user     *user.User
+	sudoUser string
+	host     string
+
+	mu      sync.Mutex
+	dropped int
+	errs    []error
+}
+
+// New returns the logger of the sinks of the config, nil when there is none.
+func New(cfg Config) (*Logger, error) {
+	var sinks []sink
+	if cfg.File != "" {
+		s, err := newFileSink(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.Syslog {
+		s, err := newSyslogSink()
+		if err != nil {
+			closeSinks(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.Webhook != "" {
+		s, err := newWebhookSink(cfg.Webhook)
+		if err != nil {
+			closeSinks(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	return newLogger(sinks)
+}
+
+func newLogger(sinks []sink) (*Logger, error) {
+	u, err := user.Current()
+	if err != nil {
+		closeSinks(sinks)
+		return nil, fmt.Errorf("failed to look up the local user: %w", err)
+	}
+	host, _ := os.Hostname()
+	l := &Logger{
+		sinks:    sinks,
+		events:   make(chan Event, queueSize),
+		done:     make(chan struct{}),
+		user:     u,
+		sudoUser: os.Getenv("SUDO_USER"),
+		host:     host,
+	}
+	go l.run()
+	return l, nil
+}
+
+// Log queues the event, it is dropped when the queue is full.
+func (l *Logger) Log(e Event) {
+	if l == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	e.User, e.UID, e.SudoUser, e.Host = l.user.Username, l.user.Uid, l.sudoUser, l.host
+	select {
+	case l.events <- e:
+	default:
+		l.mu.Lock()
+		l.dropped++
+		l.mu.Unlock()
+	}
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+	for e := range l.events {
+		line, err := json.Marshal(e)
+		if err != nil {
+			l.fail(err)
+			continue
+		}
+		for _, s := range l.sinks {
+			if err := s.write(line); err != nil {
+				l.fail(err)
+			}
+		}
+	}
+}
+
+func (l *Logger) fail(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errs = append(l.errs, err)
+}
+
+// Close writes the pending events, for closeTimeout at most, and closes the
+// sinks. It returns the errors of the sinks and the number of dropped events.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	close(l.events)
+	select {
+	case <-l.done:
+		closeSinks(l.sinks)
+	case <-time.After(closeTimeout):
+		// the sinks are still written, the process exits anyway
+		l.fail(fmt.Errorf("%d events were not written in %s", len(l.events), closeTimeout))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	errs := l.errs
+	if l.dropped > 0 {
+		errs = append(errs, fmt.Errorf("%d events were dropped, the sinks were too slow", l.dropped))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+func closeSinks(sinks []sink) {
+	for _, s := range sinks {
+		s.close()
+	}
+}
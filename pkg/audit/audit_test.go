@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoggerSinks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	posted := make(chan Event, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("invalid webhook body: %v", err)
+		}
+		posted <- e
+	}))
+	defer server.Close()
+
+	l, err := New(Config{File: path, Webhook: server.URL})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	code := 3
+	l.Log(Event{Event: EventStart, Session: "abc", Command: []string{"sh"}})
+	l.Log(Event{Event: EventEnd, Session: "abc", Command: []string{"sh"}, ExitCode: &code})
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, e)
+	}
+	if len(events) != 2 || events[0].Event != EventStart || events[1].ExitCode == nil || *events[1].ExitCode != 3 {
+		t.Fatalf("events = %+v, want the start and the end with exit code 3", events)
+	}
+	if events[0].User == "" || events[0].UID == "" || events[0].Time.IsZero() {
+		t.Errorf("event = %+v, want the local user and the time", events[0])
+	}
+	for _, want := range []string{EventStart, EventEnd} {
+		if e := <-posted; e.Event != want {
+			t.Errorf("webhook event = %q, want %q", e.Event, want)
+		}
+	}
+
+	if l, err := New(Config{}); l != nil || err != nil {
+		t.Errorf("New() without sinks = %v, %v, want no logger", l, err)
+	}
+	if _, err := New(Config{Webhook: "localhost:8080"}); err == nil {
+		t.Errorf("New() with a webhook without scheme succeeded")
+	}
+}
+
+// blockingSink blocks until released, as a stuck webhook.
+type blockingSink struct {
+	release chan struct{}
+}
+
+func (s *blockingSink) write(line []byte) error {
+	<-s.release
+	return nil
+}
+
+func (s *blockingSink) close() error { return nil }
+
+func TestLoggerNeverBlocks(t *testing.T) {
+	blocking := &blockingSink{release: make(chan struct{})}
+	l, err := newLogger([]sink{blocking})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < 2*queueSize; i++ {
+		l.Log(Event{Event: EventStart})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Log() blocked for %s", elapsed)
+	}
+	close(blocking.release)
+	err = l.Close()
+	if err == nil || !strings.Contains(err.Error(), "events were dropped") {
+		t.Errorf("Close() error = %v, want the dropped events", err)
+	}
+}
@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// webhookTimeout bounds each POST, a stuck webhook only delays the next events.
+const webhookTimeout = 5 * time.Second
+
+// fileSink appends the events to a file, which is only readable by its owner.
+type fileSink struct {
+	f *os.File
+}
+
+func newFileSink(path string) (*fileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &fileSink{f: f}, nil
+}
+
+func (s *fileSink) write(line []byte) error {
+	// a single write, so concurrent conxec don't mix their lines
+	if _, err := s.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("audit log file: %w", err)
+	}
+	return nil
+}
+
+func (s *fileSink) close() error {
+	return s.f.Close()
+}
+
+// webhookSink POSTs each event as a JSON object.
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookSink(rawURL string) (*webhookSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid audit webhook %q: want an http:// or https:// URL", rawURL)
+	}
+	return &webhookSink{url: rawURL, client: &http.Client{Timeout: webhookTimeout}}, nil
+}
+
+func (s *webhookSink) write(line []byte) error {
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(line))
+	if err != nil {
+		return fmt.Errorf("audit webhook: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("audit webhook: %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *webhookSink) close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build windows || plan9
+
+package audit
+
+import "fmt"
+
+type syslogSink struct{}
+
+func newSyslogSink() (*syslogSink, error) {
+	return nil, fmt.Errorf("syslog is not available on this platform, log the audit events to a file or a webhook")
+}
+
+func (s *syslogSink) write(line []byte) error { return nil }
+
+func (s *syslogSink) close() error { return nil }
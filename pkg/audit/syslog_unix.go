@@ -0,0 +1,32 @@
+//go:build !windows && !plan9
+
+package audit
+
+import (
+	"fmt"
+	"log/syslog"
+)
+
+// syslogSink sends the events to the local syslog daemon.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func newSyslogSink() (*syslogSink, error) {
+	w, err := syslog.New(syslog.LOG_AUTH|syslog.LOG_INFO, "conxec")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) write(line []byte) error {
+	if err := s.w.Info(string(line)); err != nil {
+		return fmt.Errorf("audit syslog: %w", err)
+	}
+	return nil
+}
+
+func (s *syslogSink) close() error {
+	return s.w.Close()
+}
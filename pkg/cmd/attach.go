@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/debasishbsws/conxec/pkg/audit"
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+	"github.com/spf13/cobra"
+)
+
+func AttachCmd() *cobra.Command {
+	var runtime string
+	var runtimeType string
+	var dockerContext string
+	var detachKeys string
+	var readOnly bool
+	var controlKeys string
+
+	cmd := &cobra.Command{
+		Use:   "attach [schema://]<session>",
+		Short: `Reattach to a debugger session started with "conxec exec -d"`,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			auditLog, err := audit.New(cfg.Audit)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := auditLog.Close(); err != nil {
+					fmt.Fprintln(cmd.ErrOrStderr(), err)
+				}
+			}()
+			execOpts, err := exec.New([]exec.Option{
+				exec.WithTarget(args[0]),
+				exec.WithRuntime(runtime),
+				exec.WithRuntimeType(runtimeType),
+				exec.WithDockerContext(dockerContext),
+				exec.WithDetachKeys(cfg.detachKeys(detachKeys)),
+				exec.WithReadOnly(readOnly),
+				exec.WithControlKeys(controlKeys),
+				exec.WithAudit(auditLog),
+			})
+			if err != nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+
+			clistream := iocli.NewCliStream(os.Stdin, os.Stdout, os.Stderr)
+			client, err := resolveClient(cmd.Context(), execOpts, clistream)
+			if err != nil {
+				return err
+			}
+			return exec.AttachSession(cmd.Context(), client, execOpts, clistream)
+		},
+	}
+
+	cmd.Flags().StringVar(&runtime, "runtime", "", `Runtime address ("/var/run/docker.sock" | "/run/podman/podman.sock")`)
+	cmd.Flags().StringVar(&runtimeType, "runtime-type", "", `type of runtime of sessions without schema ("docker" | "podman"), detected when not set`)
+	cmd.Flags().StringVar(&dockerContext, "context", "", "docker CLI context to use for docker:// sessions")
+	cmd.Flags().StringVar(&detachKeys, "detach-keys", "", detachKeysUsage)
+	cmd.Flags().BoolVar(&readOnly, "read-only", false, "Watch the session without sending input nor resizing its terminal, alongside its user")
+	cmd.Flags().StringVar(&controlKeys, "control-keys", "", `Key sequence taking control of the session attached with --read-only (e.g. "ctrl-p,ctrl-t"), it can't be taken when not set`)
+	return cmd
+}
@@ -11,9 +11,17 @@ func New(version, commit string) *cobra.Command {
 		Use:     "conxec",
 		Version: fmt.Sprintf("%s (commit: %s)", version, commit),
 		Short:   "conxec is a CLI tool for debuging running container.",
+		// errors are printed by main, which exits with the status of the
+		// debugged command
+		SilenceErrors: true,
 	}
 
 	rootCmd.AddCommand(ExecCmd())
+	rootCmd.AddCommand(AttachCmd())
+	rootCmd.AddCommand(SessionsCmd())
+	rootCmd.AddCommand(GcCmd())
+	rootCmd.AddCommand(ReplayCmd())
+	rootCmd.AddCommand(helperCmd())
 
 	return rootCmd
 }
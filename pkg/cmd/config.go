@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/debasishbsws/conxec/pkg/audit"
+)
+
+// configEnv overrides the path of the config file.
+const configEnv = "CONXEC_CONFIG"
+
+// config holds the defaults of the flags, the flags given on the command line
+// take precedence within the limits of the policy.
+type config struct {
+	// DetachKeys is the default of --detach-keys, as in the config.json of
+	// docker
+	DetachKeys string `json:"detachKeys,omitempty"`
+	// Timeout and IdleTimeout are the defaults and the maximums of --timeout
+	// and --idle-timeout, the policy of the debugger sessions
+	Timeout     duration `json:"timeout,omitempty"`
+	IdleTimeout duration `json:"idleTimeout,omitempty"`
+	// Audit selects where the audit events of the sessions are written
+	Audit audit.Config `json:"audit"`
+}
+
+// duration is a time.Duration written as in the flags, e.g. "30m".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("durations are strings such as \"30m\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// configPath returns $CONXEC_CONFIG or conxec/config.json in the user config
+// directory (~/.config/conxec/config.json on linux).
+func configPath() (string, error) {
+	if path := os.Getenv(configEnv); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the config directory: %w", err)
+	}
+	return filepath.Join(dir, "conxec", "config.json"), nil
+}
+
+// loadConfig reads the config file, a missing file is an empty config.
+func loadConfig() (*config, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	cfg := &config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// detachKeys returns the detach keys of the flag, or the default of the
+// config file when the flag is not set.
+func (c *config) detachKeys(flag string) string {
+	if flag != "" {
+		return flag
+	}
+	return c.DetachKeys
+}
+
+// timeout returns the timeout of the flag, the one of the config file is its
+// default and its maximum.
+func (c *config) timeout(flag time.Duration) time.Duration {
+	return policyLimit(flag, time.Duration(c.Timeout))
+}
+
+// idleTimeout returns the idle timeout of the flag, the one of the config
+// file is its default and its maximum.
+func (c *config) idleTimeout(flag time.Duration) time.Duration {
+	return policyLimit(flag, time.Duration(c.IdleTimeout))
+}
+
+// policyLimit returns the shortest of the limit of the flag and the one of
+// the policy, 0 being no limit.
+func policyLimit(flag, policy time.Duration) time.Duration {
+	if policy > 0 && (flag == 0 || flag > policy) {
+		return policy
+	}
+	return flag
+}
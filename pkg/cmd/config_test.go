@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	t.Setenv(configEnv, path)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig() of a missing file error = %v", err)
+	}
+	if got := cfg.detachKeys(""); got != "" {
+		t.Errorf("detachKeys() = %q without config, want the default of exec", got)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"detachKeys": "ctrl-x,x"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = loadConfig(); err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if got := cfg.detachKeys(""); got != "ctrl-x,x" {
+		t.Errorf("detachKeys() = %q, want the one of the config file", got)
+	}
+	if got := cfg.detachKeys("ctrl-a"); got != "ctrl-a" {
+		t.Errorf("detachKeys() = %q, want the one of the flag", got)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"detachKeys": `), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig(); err == nil {
+		t.Errorf("loadConfig() of an invalid file succeeded")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"timeout": "30m", "idleTimeout": "10m"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = loadConfig(); err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if got := cfg.timeout(0); got != 30*time.Minute {
+		t.Errorf("timeout() = %s, want the one of the config file", got)
+	}
+	if got := cfg.timeout(10 * time.Minute); got != 10*time.Minute {
+		t.Errorf("timeout() = %s, want the one of the flag", got)
+	}
+	// the config file is the policy, the flags can't go over it
+	if got := cfg.timeout(time.Hour); got != 30*time.Minute {
+		t.Errorf("timeout() = %s, want the maximum of the config file", got)
+	}
+	if got := cfg.idleTimeout(0); got != 10*time.Minute {
+		t.Errorf("idleTimeout() = %s, want the one of the config file", got)
+	}
+	if got := cfg.idleTimeout(time.Hour); got != 10*time.Minute {
+		t.Errorf("idleTimeout() = %s, want the maximum of the config file", got)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"timeout": 1800}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig(); err == nil {
+		t.Errorf("loadConfig() of a timeout without unit succeeded")
+	}
+}
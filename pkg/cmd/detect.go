@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+)
+
+const probeTimeout = 5 * time.Second
+
+// probe is a runtime the target is looked up in when it has no schema.
+type probe struct {
+	schema  string
+	address string // runtime address, empty for the docker CLI context
+}
+
+func (p probe) String() string {
+	name := strings.TrimSuffix(p.schema, "://")
+	if p.address == "" {
+		return name + " (docker context)"
+	}
+	return fmt.Sprintf("%s (%s)", name, p.address)
+}
+
+// defaultProbes returns the runtimes listening on the well-known sockets of
+// the host, or the ones which can be behind the --runtime address.
+func defaultProbes(execOpts *exec.ExecOptions) []probe {
+	if execOpts.Runtime != "" {
+		return runtimeProbes(execOpts.Runtime)
+	}
+
+	probes := []probe{}
+	if execOpts.DockerContext != "" || os.Getenv("DOCKER_HOST") != "" || os.Getenv("DOCKER_CONTEXT") != "" || exists("/var/run/docker.sock") {
+		probes = append(probes, probe{schema: schemaDocker})
+	}
+	sockets := []probe{
+		{schema: schemaPodman, address: "/run/podman/podman.sock"},
+		{schema: schemaContainerd, address: "/run/containerd/containerd.sock"},
+		{schema: schemaCRI, address: "/var/run/crio/crio.sock"},
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		sockets = append(sockets, probe{schema: schemaPodman, address: filepath.Join(dir, "podman", "podman.sock")})
+	}
+	for _, p := range sockets {
+		if exists(p.address) {
+			probes = append(probes, p)
+		}
+	}
+	return probes
+}
+
+// runtimeProbes guesses the runtime behind the --runtime address from the
+// name of the socket (docker.sock, podman.sock, containerd.sock, crio.sock),
+// all of them are tried for other sockets.
+func runtimeProbes(address string) []probe {
+	path := strings.TrimPrefix(address, "unix://")
+	if !strings.HasPrefix(path, "/") {
+		// tcp:// and ssh:// hosts
+		return []probe{{schema: schemaDocker, address: address}}
+	}
+	probes := []probe{
+		{schema: schemaDocker, address: "unix://" + path},
+		{schema: schemaPodman, address: path},
+		{schema: schemaContainerd, address: path},
+		{schema: schemaCRI, address: path},
+	}
+	name := filepath.Base(path)
+	for _, p := range probes {
+		if strings.Contains(name, strings.TrimSuffix(p.schema, "://")) {
+			return []probe{p}
+		}
+	}
+	return probes
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// detectRuntime looks the target up in all the runtimes at once and sets the
+// schema and the address of the only one knowing it.
+func detectRuntime(ctx context.Context, execOpts *exec.ExecOptions, probes []probe, clistream *iocli.CliStream) error {
+	if len(probes) == 0 {
+		return fmt.Errorf("no container runtime found on this host, use a schema (e.g: containerd://%s) or --runtime", execOpts.Target)
+	}
+
+	errs := make([]error, len(probes))
+	var wg sync.WaitGroup
+	for i, p := range probes {
+		wg.Add(1)
+		go func(i int, p probe) {
+			defer wg.Done()
+			errs[i] = p.lookup(ctx, execOpts)
+		}(i, p)
+	}
+	wg.Wait()
+
+	found := []probe{}
+	for i, p := range probes {
+		if errs[i] == nil {
+			found = append(found, p)
+		}
+	}
+	switch len(found) {
+	case 0:
+		var msg strings.Builder
+		fmt.Fprintf(&msg, "target %q not found in any runtime, use a schema (e.g: containerd://%s) or --runtime-type:", execOpts.Target, execOpts.Target)
+		for i, p := range probes {
+			fmt.Fprintf(&msg, "\n  %s: %v", p, errs[i])
+		}
+		return fmt.Errorf("%s", msg.String())
+	case 1:
+		execOpts.Schema = found[0].schema
+		execOpts.Runtime = found[0].address
+		clistream.PrintAux("Found target %q in %s\n", execOpts.Target, found[0])
+		return nil
+	default:
+		names := []string{}
+		for _, p := range found {
+			names = append(names, p.String())
+		}
+		return fmt.Errorf("target %q is known by several runtimes: %s. Use a schema (e.g: %s%s) or --runtime-type to pick one",
+			execOpts.Target, strings.Join(names, ", "), found[0].schema, execOpts.Target)
+	}
+}
+
+// lookup reports whether the runtime knows the target, with a client of its
+// own as backends may rewrite the options.
+func (p probe) lookup(ctx context.Context, execOpts *exec.ExecOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	opts := *execOpts
+	opts.Schema = p.schema
+	opts.Runtime = p.address
+	quiet := iocli.NewCliStream(io.NopCloser(strings.NewReader("")), io.Discard, io.Discard)
+	client, err := newDebuggerClient(ctx, &opts, quiet)
+	if err != nil {
+		return err
+	}
+	_, err = client.GetContainerInfo(ctx, opts.Target)
+	return err
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+)
+
+// fakePodman serves the libpod API on a unix socket and knows the given
+// containers, the conxec-debugger-* ones are debugger sessions.
+func fakePodman(t *testing.T, containers ...string) string {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "podman.sock")
+	lis, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	known := map[string]bool{}
+	for _, c := range containers {
+		known[c] = true
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_ping"):
+			w.Write([]byte("OK"))
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			// the known debuggers are listed as sessions
+			list := []string{}
+			for _, c := range containers {
+				if runID, ok := strings.CutPrefix(c, "conxec-debugger-"); ok {
+					list = append(list, `{"Id":"`+c+`","Names":["`+c+`"],"State":"running","Labels":{"io.conxec.session":"`+runID+`"}}`)
+				}
+			}
+			w.Write([]byte("[" + strings.Join(list, ",") + "]"))
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			name := path.Base(path.Dir(r.URL.Path))
+			if !known[name] {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"message":"no such container"}`))
+				return
+			}
+			w.Write([]byte(`{"Id":"` + name + `","State":{"Running":true}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})}
+	go server.Serve(lis)
+	t.Cleanup(func() { server.Close() })
+	return sock
+}
+
+func TestDetectRuntime(t *testing.T) {
+	rootful := probe{schema: schemaPodman, address: fakePodman(t, "web", "db")}
+	rootless := probe{schema: schemaPodman, address: fakePodman(t, "web", "cache")}
+	probes := []probe{rootful, rootless}
+
+	tests := []struct {
+		target  string
+		want    probe
+		wantErr string
+	}{
+		{target: "db", want: rootful},
+		{target: "cache", want: rootless},
+		{target: "web", wantErr: "known by several runtimes"},
+		{target: "missing", wantErr: "not found in any runtime"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			opts := &exec.ExecOptions{Target: tt.target}
+			cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard)
+			err := detectRuntime(context.Background(), opts, probes, cliStream)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("detectRuntime() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("detectRuntime() error = %v", err)
+			}
+			if got := (probe{schema: opts.Schema, address: opts.Runtime}); got != tt.want {
+				t.Errorf("detected %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuntimeProbes(t *testing.T) {
+	tests := []struct {
+		address string
+		want    []probe
+	}{
+		{"/var/run/docker.sock", []probe{{schemaDocker, "unix:///var/run/docker.sock"}}},
+		{"unix:///run/podman/podman.sock", []probe{{schemaPodman, "/run/podman/podman.sock"}}},
+		{"/run/k3s/containerd/containerd.sock", []probe{{schemaContainerd, "/run/k3s/containerd/containerd.sock"}}},
+		{"/var/run/crio/crio.sock", []probe{{schemaCRI, "/var/run/crio/crio.sock"}}},
+		{"ssh://user@host", []probe{{schemaDocker, "ssh://user@host"}}},
+		{"/tmp/runtime.sock", []probe{
+			{schemaDocker, "unix:///tmp/runtime.sock"},
+			{schemaPodman, "/tmp/runtime.sock"},
+			{schemaContainerd, "/tmp/runtime.sock"},
+			{schemaCRI, "/tmp/runtime.sock"},
+		}},
+	}
+	for _, tt := range tests {
+		if got := runtimeProbes(tt.address); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("runtimeProbes(%q) = %v, want %v", tt.address, got, tt.want)
+		}
+	}
+}
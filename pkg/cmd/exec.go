@@ -2,22 +2,39 @@ package cmd
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"io"
 	"os"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/debasishbsws/conxec/pkg/audit"
 	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/exec/containerd"
+	"github.com/debasishbsws/conxec/pkg/exec/cri"
 	"github.com/debasishbsws/conxec/pkg/exec/docker"
+	"github.com/debasishbsws/conxec/pkg/exec/kubernetes"
+	"github.com/debasishbsws/conxec/pkg/exec/podman"
+	"github.com/debasishbsws/conxec/pkg/exec/process"
 	"github.com/debasishbsws/conxec/pkg/iocli"
 	"github.com/spf13/cobra"
 )
 
 const (
+	schemaCompose    = "compose://"
 	schemaContainerd = "containerd://"
+	schemaCRI        = "cri://"
 	schemaDocker     = "docker://"
+	schemaKubernetes = "k8s://"
+	schemaPid        = "pid://"
+	schemaPodman     = "podman://"
 )
 
+var schemas = []string{schemaCompose, schemaContainerd, schemaCRI, schemaDocker, schemaKubernetes, schemaPid, schemaPodman}
+
+const detachKeysUsage = `Key sequence detaching from a docker:// or podman:// debugger with a TTY, leaving it running (default "ctrl-p,ctrl-q" or "detachKeys" of the config file)`
+
 func ExecCmd() *cobra.Command {
 	var target string
 	var command []string
@@ -28,6 +45,24 @@ func ExecCmd() *cobra.Command {
 	var tty bool
 	var interactive bool
 	var mountDir string
+	var kubeconfig string
+	var kubeContext string
+	var dockerContext string
+	var runtimeType string
+	var detach bool
+	var detachKeys string
+	var followRestarts bool
+	var timeout time.Duration
+	var idleTimeout time.Duration
+	var record string
+	var shell bool
+	var script string
+	var share string
+	var process string
+	var inheritEnv bool
+	var inheritCwd bool
+	var env []string
+	var envFile string
 
 	cmd := &cobra.Command{
 		Use:   "exec [container-id/name] [command]",
@@ -37,29 +72,75 @@ func ExecCmd() *cobra.Command {
 			target = args[0]
 			if len(args) > 1 {
 				command = args[1:]
-			} else {
+			} else if script == "" {
 				command = []string{"sh"}
 			}
+			// the idle time is the one of the terminal of the debugger
+			if idleTimeout != 0 && !tty {
+				return fmt.Errorf("--idle-timeout needs a TTY (-t)")
+			}
+			scriptContent, err := readScript(script, shell, interactive, cmd.InOrStdin())
+			if err != nil {
+				return err
+			}
+			envVars, err := readEnv(envFile, env)
+			if err != nil {
+				return err
+			}
 			aditionalPackages, err := cmd.Flags().GetStringSlice("application")
 			if err != nil {
 				return err
 			}
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			auditLog, err := audit.New(cfg.Audit)
+			if err != nil {
+				return err
+			}
+			// the audit log never fails the session, its errors are only shown
+			defer func() {
+				if err := auditLog.Close(); err != nil {
+					fmt.Fprintln(cmd.ErrOrStderr(), err)
+				}
+			}()
 			opt := []exec.Option{
 				exec.WithTarget(target),
 				exec.WithCommand(command),
+				exec.WithShell(shell),
+				exec.WithScript(scriptContent),
+				exec.WithShare(share),
+				exec.WithProcess(process),
+				exec.WithInheritEnv(inheritEnv),
+				exec.WithInheritCwd(inheritCwd),
+				exec.WithEnv(envVars),
 				exec.WithDebuggerImage(dbgImage),
 				exec.WithUser(userGroup),
 				exec.WithName(name),
 				exec.WithRuntime(runtime),
+				exec.WithRuntimeType(runtimeType),
 				exec.WithTty(tty),
 				exec.WithStdin(interactive),
 				exec.WithAditionalPackages(aditionalPackages),
 				exec.WithMountDir(mountDir),
+				exec.WithKubeconfig(kubeconfig),
+				exec.WithKubeContext(kubeContext),
+				exec.WithDockerContext(dockerContext),
+				exec.WithDetach(detach),
+				exec.WithDetachKeys(cfg.detachKeys(detachKeys)),
+				exec.WithFollowRestarts(followRestarts),
+				exec.WithTimeout(cfg.timeout(timeout)),
+				exec.WithIdleTimeout(cfg.idleTimeout(idleTimeout)),
+				exec.WithRecord(record),
+				exec.WithAudit(auditLog),
 			}
 			exec, err := exec.New(opt)
 			if err != nil {
 				return err
 			}
+			// the usage is only relevant for the errors of the flags
+			cmd.SilenceUsage = true
 			return ExecuteCmd(cmd.Context(), exec)
 		},
 	}
@@ -73,36 +154,202 @@ func ExecCmd() *cobra.Command {
 	)
 	cmd.Flags().BoolVarP(&interactive, "interactive", "i", false, `Keep the STDIN open (as in "docker exec -i")`)
 	cmd.Flags().BoolVarP(&tty, "tty", "t", false, `Allocate a pseudo-TTY (as in "docker exec -t")`)
+	cmd.Flags().BoolVar(&shell, "shell", false, `Run the command as a sh script of its words joined by spaces (e.g. --shell web 'ps | grep app'), instead of passing its arguments as is`)
+	cmd.Flags().StringVar(&script, "script", "", `Run this local sh script in the target, "-" to read it from stdin, the command is its arguments (e.g. --script diag.sh web -v)`)
+	cmd.Flags().StringVar(&share, "share", "", `Namespaces of the target the debugger joins, some of net, pid, ipc, uts and mnt, mnt runs the command in the root filesystem of the target and needs pid (default "net,pid,mnt")`)
+	cmd.Flags().StringVar(&process, "process", "", "Process of the target the command runs in the root filesystem and working directory of: a pid, a name or a POSIX extended regex of its command line as for grep -E (default PID 1)")
+	cmd.Flags().BoolVar(&inheritEnv, "inherit-env", false, "Run the command with the environment of the process of the target instead of the one of the debugger, the tools of the debugger stay in the PATH")
+	cmd.Flags().BoolVar(&inheritCwd, "inherit-cwd", false, "Start the command in the working directory of the process of the target")
+	cmd.Flags().StringArrayVarP(&env, "env", "e", nil, `Set an environment variable of the command, KEY=VAL or KEY to take it from the local environment (as in "docker exec -e")`)
+	cmd.Flags().StringVar(&envFile, "env-file", "", "Read environment variables of the command from this file of KEY=VAL lines, -e overrides them")
+	cmd.Flags().BoolVarP(&detach, "detach", "d", false, `Start the debugger in the background and print its session, reattach with "conxec attach" (use -dit for a shell)`)
+	cmd.Flags().StringVar(&detachKeys, "detach-keys", "", detachKeysUsage)
+	cmd.Flags().BoolVar(&followRestarts, "follow-restarts", false, "Create the debugger again when the target restarts, instead of ending the session when it exits")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, `End the session after this time, e.g. "30m" (default and maximum "timeout" of the config file, no limit without it)`)
+	cmd.Flags().DurationVar(&idleTimeout, "idle-timeout", 0, `End a session with a TTY after this time without input or output, e.g. "10m" (default and maximum "idleTimeout" of the config file, no limit without it)`)
+	cmd.Flags().StringVar(&record, "record", "", `Record the output of the session, what is typed in it and its terminal size to this asciicast v2 file, play it with "conxec replay"`)
 	cmd.Flags().StringVar(&runtime, "runtime", "",
-		`Runtime address ("/var/run/docker.sock" | "/run/containerd/containerd.sock" | "https://<kube-api-addr>:8433/...)`,
+		`Runtime address ("/var/run/docker.sock" | "/run/containerd/containerd.sock" | "/var/run/crio/crio.sock" | OCI layout directory for pid:// | "https://<kube-api-addr>:8433/...)`,
+	)
+	cmd.Flags().StringVar(&runtimeType, "runtime-type", "",
+		`type of runtime of targets without schema ("docker" | "compose" | "containerd" | "podman" | "cri" | "k8s" | "pid"), detected when not set`,
 	)
 	cmd.Flags().StringSliceP("application", "a", []string{}, "additional application to install in the debugger image works only with root user")
 	cmd.Flags().StringVarP(&mountDir, "mount", "m", "", "mount directory in the target container can be access by $MNTD")
+	cmd.Flags().StringVar(&dockerContext, "context", "",
+		`docker CLI context to use for docker:// targets (default is the current context, as in "docker context use")`,
+	)
+	cmd.Flags().StringVar(&kubeconfig, "kubeconfig", "", "path to the kubeconfig file for k8s:// targets (default $KUBECONFIG or ~/.kube/config)")
+	cmd.Flags().StringVar(&kubeContext, "kube-context", "", "kubeconfig context to use for k8s:// targets")
 	return cmd
 }
 
+// readScript reads the script of --script, nil without it.
+func readScript(path string, shell, interactive bool, stdin io.Reader) ([]byte, error) {
+	switch {
+	case path == "":
+		return nil, nil
+	case shell:
+		return nil, fmt.Errorf("--script can't be used with --shell, the script is run by sh")
+	case path == "-" && interactive:
+		return nil, fmt.Errorf("--script - reads the script from stdin, it can't be used with -i")
+	}
+	var script []byte
+	var err error
+	if path == "-" {
+		script, err = io.ReadAll(stdin)
+	} else {
+		script, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script: %w", err)
+	}
+	return script, nil
+}
+
+// readEnv returns the variables of --env-file followed by the ones of -e, as
+// KEY=VAL. A KEY without value takes the one of the local environment and is
+// left out when it is not set there, as with docker. The file has a variable
+// per line, blank lines and lines starting with # are skipped.
+func readEnv(path string, env []string) ([]string, error) {
+	var vars []string
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read env file: %w", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSuffix(line, "\r")
+			if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+				continue
+			}
+			vars = append(vars, line)
+		}
+	}
+	vars = append(vars, env...)
+	resolved := []string{}
+	for _, kv := range vars {
+		if strings.Contains(kv, "=") {
+			resolved = append(resolved, kv)
+			continue
+		}
+		if value, ok := os.LookupEnv(kv); ok {
+			resolved = append(resolved, kv+"="+value)
+		}
+	}
+	return resolved, nil
+}
+
 func ExecuteCmd(ctx context.Context, execOpts *exec.ExecOptions) error {
+	clistream := iocli.NewCliStream(os.Stdin, os.Stdout, os.Stderr)
+
+	client, err := resolveClient(ctx, execOpts, clistream)
+	if err != nil {
+		return err
+	}
+	if execOpts.Schema == schemaCompose {
+		containerID, err := client.(*docker.DockerClient).ResolveComposeTarget(ctx, execOpts.Target)
+		if err != nil {
+			return err
+		}
+		clistream.PrintAux("Compose service %q is container %s\n", execOpts.Target, containerID)
+		execOpts.Target = containerID
+		// the debugger is a plain docker container
+		execOpts.Schema = schemaDocker
+	}
+	if execOpts.Record != "" {
+		if execOpts.Detach {
+			return fmt.Errorf("detached sessions can't be recorded, conxec records the streams it is attached to")
+		}
+		recorder, err := iocli.CreateRecording(execOpts.Record, strings.Join(execOpts.Command, " "), execOpts.Schema+execOpts.Target, clistream)
+		if err != nil {
+			return err
+		}
+		clistream.SetRecorder(recorder)
+		err = exec.RunDebugger(ctx, client, execOpts, clistream)
+		if closeErr := recorder.Close(); closeErr != nil {
+			if err != nil {
+				clistream.PrintAux("%s\n", closeErr)
+				return err
+			}
+			return closeErr
+		}
+		return err
+	}
+	return exec.RunDebugger(ctx, client, execOpts, clistream)
+}
+
+// resolveClient splits the schema from the target, or detects the runtime of
+// targets without one, and connects to the runtime.
+func resolveClient(ctx context.Context, execOpts *exec.ExecOptions, clistream *iocli.CliStream) (exec.DebuggerClient, error) {
 	if sep := strings.Index(execOpts.Target, "://"); sep != -1 {
 		execOpts.Schema = execOpts.Target[:sep+3]
 		execOpts.Target = execOpts.Target[sep+3:]
-	} else {
-		execOpts.Schema = schemaDocker
 	}
+	if execOpts.RuntimeType != "" {
+		schema := execOpts.RuntimeType + "://"
+		if !slices.Contains(schemas, schema) {
+			return nil, fmt.Errorf("unknown runtime type %q", execOpts.RuntimeType)
+		}
+		if execOpts.Schema != "" && execOpts.Schema != schema {
+			return nil, fmt.Errorf("conflicting options: the target has the schema %q but --runtime-type is %q", execOpts.Schema, execOpts.RuntimeType)
+		}
+		execOpts.Schema = schema
+	}
+	if execOpts.Schema == "" {
+		if err := detectRuntime(ctx, execOpts, defaultProbes(execOpts), clistream); err != nil {
+			return nil, err
+		}
+	}
+	return newDebuggerClient(ctx, execOpts, clistream)
+}
 
-	clistream := iocli.NewCliStream(os.Stdin, os.Stdout, os.Stderr)
-
+// newDebuggerClient connects to the runtime of the schema of the target.
+func newDebuggerClient(ctx context.Context, execOpts *exec.ExecOptions, clistream *iocli.CliStream) (exec.DebuggerClient, error) {
 	switch execOpts.Schema {
-	case schemaDocker:
+	case schemaDocker, schemaCompose:
 		dockerClient, err := docker.NewClient(ctx, execOpts, clistream)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		return exec.RunDebugger(ctx, dockerClient, execOpts, clistream)
+		return dockerClient, nil
 
 	case schemaContainerd:
-		return errors.New("coming soon...")
+		containerdClient, err := containerd.NewClient(ctx, execOpts, clistream)
+		if err != nil {
+			return nil, err
+		}
+		return containerdClient, nil
+
+	case schemaPodman:
+		podmanClient, err := podman.NewClient(ctx, execOpts, clistream)
+		if err != nil {
+			return nil, err
+		}
+		return podmanClient, nil
+
+	case schemaCRI:
+		criClient, err := cri.NewClient(ctx, execOpts, clistream)
+		if err != nil {
+			return nil, err
+		}
+		return criClient, nil
+
+	case schemaKubernetes:
+		kubeClient, err := kubernetes.NewClient(ctx, execOpts, clistream)
+		if err != nil {
+			return nil, err
+		}
+		return kubeClient, nil
+
+	case schemaPid:
+		processClient, err := process.NewClient(ctx, execOpts, clistream)
+		if err != nil {
+			return nil, err
+		}
+		return processClient, nil
 
 	default:
-		return fmt.Errorf("unknown schema %q", execOpts.Schema)
+		return nil, fmt.Errorf("unknown schema %q", execOpts.Schema)
 	}
 }
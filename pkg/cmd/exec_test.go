@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadEnv(t *testing.T) {
+	t.Setenv("CONXEC_TEST_LOCAL", "local")
+	envFile := filepath.Join(t.TempDir(), "app.env")
+	content := "# the database\nDATABASE_URL=postgres://db/app?sslmode=disable\r\n\n  \nEMPTY=\nCONXEC_TEST_LOCAL\nCONXEC_TEST_UNSET\nMODE=file\n"
+	if err := os.WriteFile(envFile, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		env     []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "none", want: []string{}},
+		{name: "flags", env: []string{"A=1", "B=x=y", "CONXEC_TEST_LOCAL", "CONXEC_TEST_UNSET"}, want: []string{"A=1", "B=x=y", "CONXEC_TEST_LOCAL=local"}},
+		{
+			name: "file and overrides",
+			path: envFile,
+			env:  []string{"MODE=flag"},
+			want: []string{"DATABASE_URL=postgres://db/app?sslmode=disable", "EMPTY=", "CONXEC_TEST_LOCAL=local", "MODE=file", "MODE=flag"},
+		},
+		{name: "missing file", path: filepath.Join(t.TempDir(), "missing.env"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readEnv(tt.path, tt.env)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readEnv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	goruntime "runtime"
+	"strings"
+	"time"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+	"github.com/spf13/cobra"
+)
+
+// gcGracePeriod keeps the debuggers being started by a concurrent conxec exec,
+// which are created but not running yet.
+const gcGracePeriod = time.Minute
+
+// staleStatuses are the statuses of the debuggers which are not running, as
+// reported by the runtimes.
+var staleStatuses = map[string]bool{
+	"created":    true,
+	"configured": true,
+	"exited":     true,
+	"stopped":    true,
+	"dead":       true,
+}
+
+func GcCmd() *cobra.Command {
+	var dryRun bool
+	var dockerContext string
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove the exited debugger containers and the files killed debuggers left in the targets",
+		Long: `Remove the exited debugger containers of the local runtimes, and the /tmp/.conxec-* symlinks
+left in the running targets by the debuggers which were killed before cleaning up. The symlinks
+are looked up through /proc, which needs conxec to run as root on the host of the targets.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			execOpts, err := exec.New([]exec.Option{
+				exec.WithDockerContext(dockerContext),
+			})
+			if err != nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+
+			clistream := iocli.NewCliStream(os.Stdin, os.Stdout, os.Stderr)
+			probes, err := sessionProbes(execOpts)
+			if err != nil {
+				return err
+			}
+			procDir := "/proc"
+			if goruntime.GOOS != "linux" || os.Geteuid() != 0 {
+				clistream.PrintAux("Not running as root on linux, the files left in the targets are not looked up\n")
+				procDir = ""
+			}
+			return collectGarbage(cmd.Context(), execOpts, probes, procDir, dryRun, clistream)
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print what would be removed without removing it")
+	cmd.Flags().StringVar(&dockerContext, "context", "", "docker CLI context to use for docker:// debuggers")
+	return cmd
+}
+
+// collectGarbage removes the stale debugger containers of the runtimes, then
+// the artefacts found through procDir whose debugger is gone. The artefacts
+// are kept when a runtime can't be listed, as its debuggers could be alive.
+func collectGarbage(ctx context.Context, execOpts *exec.ExecOptions, probes []probe, procDir string, dryRun bool, clistream *iocli.CliStream) error {
+	action := "Removing"
+	if dryRun {
+		action = "Would remove"
+	}
+
+	var errs []error
+	var unreachable []string
+	live := map[string]bool{}
+	for _, p := range probes {
+		manager, err := p.sessionManager(ctx, execOpts)
+		if err != nil {
+			unreachable = append(unreachable, fmt.Sprintf("%s: %v", p, err))
+			continue
+		}
+		listCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+		sessions, err := manager.ListSessions(listCtx)
+		cancel()
+		if err != nil {
+			unreachable = append(unreachable, fmt.Sprintf("%s: %v", p, err))
+			continue
+		}
+
+		for _, s := range sessions {
+			if !staleStatuses[s.Status] || time.Since(s.Started) < gcGracePeriod {
+				live[s.RunID] = true
+				continue
+			}
+			clistream.PrintOut("%s debugger container %s%s (%s)\n", action, p.schema, s.ID, s.Status)
+			if dryRun {
+				continue
+			}
+			if err := manager.StopSession(ctx, s.ID); err != nil {
+				live[s.RunID] = true
+				errs = append(errs, fmt.Errorf("failed to remove debugger container %s%s: %w", p.schema, s.ID, err))
+			}
+		}
+	}
+
+	if len(unreachable) != 0 {
+		errs = append(errs, fmt.Errorf("the files left in the targets were not removed, the debuggers of some runtimes could not be listed:\n  %s",
+			strings.Join(unreachable, "\n  ")))
+		return errors.Join(errs...)
+	}
+	if procDir == "" {
+		return errors.Join(errs...)
+	}
+
+	artefacts, err := exec.FindArtefacts(procDir)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	for _, a := range artefacts {
+		// the labels of the runtimes are only needed when the symlink doesn't
+		// tell, kubernetes debuggers have none
+		if a.Alive != nil && *a.Alive || a.Alive == nil && live[a.RunID] {
+			continue
+		}
+		clistream.PrintOut("%s %s (process %d %s)\n", action, a.Path, a.Pid, a.Command)
+		if dryRun {
+			continue
+		}
+		if err := os.Remove(a.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("failed to remove %s: %w", a.Path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+)
+
+// fakeProc returns a /proc with a single target whose /tmp holds the
+// artefacts of the given runs.
+func fakeProc(t *testing.T, runIDs ...string) (proc, tmp string) {
+	t.Helper()
+	proc = t.TempDir()
+	tmp = filepath.Join(proc, "100", "root", "tmp")
+	if err := os.MkdirAll(tmp, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(proc, "100", "ns"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("mnt:[1]", filepath.Join(proc, "100", "ns", "mnt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(proc, "100", "comm"), []byte("app\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(proc, "100", "cmdline"), []byte("app\x00"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range runIDs {
+		if err := os.Symlink("/proc/42/root/bin/", filepath.Join(tmp, ".conxec-bin-"+id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return proc, tmp
+}
+
+func TestCollectGarbage(t *testing.T) {
+	podman := probe{schema: schemaPodman, address: fakePodman(t, "web", "conxec-debugger-1a2b3c4d")}
+	proc, tmp := fakeProc(t, "1a2b3c4d", "5e6f7a8b")
+
+	var out bytes.Buffer
+	cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), &out, io.Discard)
+	if err := collectGarbage(context.Background(), &exec.ExecOptions{}, []probe{podman}, proc, true, cliStream); err != nil {
+		t.Fatalf("collectGarbage() dry run error = %v", err)
+	}
+	if want := "Would remove " + filepath.Join(tmp, ".conxec-bin-5e6f7a8b") + " (process 100 app)\n"; out.String() != want {
+		t.Errorf("dry run output = %q, want %q", out.String(), want)
+	}
+	if _, err := os.Lstat(filepath.Join(tmp, ".conxec-bin-5e6f7a8b")); err != nil {
+		t.Errorf("dry run removed the artefact: %v", err)
+	}
+
+	if err := collectGarbage(context.Background(), &exec.ExecOptions{}, []probe{podman}, proc, false, cliStream); err != nil {
+		t.Fatalf("collectGarbage() error = %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(tmp, ".conxec-bin-5e6f7a8b")); !os.IsNotExist(err) {
+		t.Errorf("artefact of the gone debugger was not removed")
+	}
+	if _, err := os.Lstat(filepath.Join(tmp, ".conxec-bin-1a2b3c4d")); err != nil {
+		t.Errorf("artefact of the running debugger was removed: %v", err)
+	}
+}
+
+func TestCollectGarbageUnreachableRuntime(t *testing.T) {
+	podman := probe{schema: schemaPodman, address: fakePodman(t, "web")}
+	unreachable := probe{schema: schemaPodman, address: "/nonexistent/podman.sock"}
+	proc, tmp := fakeProc(t, "5e6f7a8b")
+
+	cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard)
+	err := collectGarbage(context.Background(), &exec.ExecOptions{}, []probe{podman, unreachable}, proc, false, cliStream)
+	if err == nil || !strings.Contains(err.Error(), "could not be listed") {
+		t.Errorf("collectGarbage() error = %v, want the unreachable runtime", err)
+	}
+	if _, err := os.Lstat(filepath.Join(tmp, ".conxec-bin-5e6f7a8b")); err != nil {
+		t.Errorf("artefact was removed while a runtime could not be listed: %v", err)
+	}
+}
+
+func TestCollectGarbageUnlabelledSession(t *testing.T) {
+	// kubernetes debuggers have no labels the runtimes list, the entrypoint of
+	// the run tells they are alive
+	podman := probe{schema: schemaPodman, address: fakePodman(t, "web")}
+	proc, tmp := fakeProc(t, "1a2b3c4d", "5e6f7a8b")
+	targetProc := filepath.Join(proc, "100", "root", "proc")
+	for _, dir := range []string{"1", "42"} {
+		if err := os.MkdirAll(filepath.Join(targetProc, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entrypoint := "sh\x00-c\x00ln -fs /proc/$$/root/bin/ /proc/1/root/tmp/.conxec-bin-1a2b3c4d\n\x00"
+	if err := os.WriteFile(filepath.Join(targetProc, "42", "cmdline"), []byte(entrypoint), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard)
+	if err := collectGarbage(context.Background(), &exec.ExecOptions{}, []probe{podman}, proc, false, cliStream); err != nil {
+		t.Fatalf("collectGarbage() error = %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(tmp, ".conxec-bin-1a2b3c4d")); err != nil {
+		t.Errorf("artefact of the running debugger was removed: %v", err)
+	}
+	// the PID was reused by another run
+	if _, err := os.Lstat(filepath.Join(tmp, ".conxec-bin-5e6f7a8b")); !os.IsNotExist(err) {
+		t.Errorf("artefact of the gone debugger was not removed")
+	}
+}
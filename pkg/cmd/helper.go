@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"github.com/debasishbsws/conxec/pkg/exec/process"
+	"github.com/spf13/cobra"
+)
+
+// helperCmd runs the debugger of pid:// targets, it is only invoked by conxec
+// itself.
+func helperCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:                process.HelperCommand,
+		Hidden:             true,
+		DisableFlagParsing: true,
+		SilenceUsage:       true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return process.RunHelper(args)
+		},
+	}
+}
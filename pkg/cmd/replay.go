@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/debasishbsws/conxec/pkg/iocli"
+	"github.com/spf13/cobra"
+)
+
+func ReplayCmd() *cobra.Command {
+	var speed float64
+
+	cmd := &cobra.Command{
+		Use:   "replay <recording>",
+		Short: `Play back in the terminal a session recorded with "conxec exec --record"`,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if speed <= 0 {
+				return fmt.Errorf("invalid speed %v: it must be positive", speed)
+			}
+			cmd.SilenceUsage = true
+
+			f, err := os.Open(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to open recording: %w", err)
+			}
+			defer f.Close()
+			return iocli.Replay(cmd.Context(), f, os.Stdout, speed)
+		},
+	}
+
+	cmd.Flags().Float64VarP(&speed, "speed", "s", 1, `Playback speed, e.g. 2 plays twice as fast and 0.5 half as fast`)
+	return cmd
+}
@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	goruntime "runtime"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/debasishbsws/conxec/pkg/audit"
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+)
+
+// sessionsOptions are the flags shared by the sessions commands.
+type sessionsOptions struct {
+	runtime       string
+	runtimeType   string
+	dockerContext string
+	kubeconfig    string
+	kubeContext   string
+}
+
+func (o *sessionsOptions) execOptions(session string) (*exec.ExecOptions, error) {
+	return exec.New([]exec.Option{
+		exec.WithTarget(session),
+		exec.WithRuntime(o.runtime),
+		exec.WithRuntimeType(o.runtimeType),
+		exec.WithDockerContext(o.dockerContext),
+		exec.WithKubeconfig(o.kubeconfig),
+		exec.WithKubeContext(o.kubeContext),
+	})
+}
+
+func SessionsCmd() *cobra.Command {
+	opts := &sessionsOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "sessions",
+		Short: "List, stop and show the output of the debugger sessions",
+	}
+	cmd.AddCommand(sessionsLsCmd(opts))
+	cmd.AddCommand(sessionsStopCmd(opts))
+	cmd.AddCommand(sessionsLogsCmd(opts))
+
+	cmd.PersistentFlags().StringVar(&opts.runtime, "runtime", "", `Runtime address ("/var/run/docker.sock" | "/run/containerd/containerd.sock" | "/var/run/crio/crio.sock" | "https://<kube-api-addr>:8433/...)`)
+	cmd.PersistentFlags().StringVar(&opts.runtimeType, "runtime-type", "",
+		`type of runtime of the sessions ("docker" | "containerd" | "podman" | "cri" | "k8s" | "pid"), all the local runtimes when not set`,
+	)
+	cmd.PersistentFlags().StringVar(&opts.dockerContext, "context", "", "docker CLI context to use for docker:// sessions")
+	cmd.PersistentFlags().StringVar(&opts.kubeconfig, "kubeconfig", "", "path to the kubeconfig file for k8s:// sessions (default $KUBECONFIG or ~/.kube/config)")
+	cmd.PersistentFlags().StringVar(&opts.kubeContext, "kube-context", "", "kubeconfig context to use for k8s:// sessions")
+	return cmd
+}
+
+func sessionsLsCmd(opts *sessionsOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:     "ls",
+		Aliases: []string{"list"},
+		Short:   "List the debugger sessions of the local runtimes",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			execOpts, err := opts.execOptions("")
+			if err != nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+
+			clistream := iocli.NewCliStream(os.Stdin, os.Stdout, os.Stderr)
+			probes, err := sessionProbes(execOpts)
+			if err != nil {
+				return err
+			}
+			sessions, err := listSessions(cmd.Context(), execOpts, probes, clistream)
+			if err != nil {
+				return err
+			}
+			printSessions(clistream.OutputStream(), sessions, time.Now())
+			return nil
+		},
+	}
+}
+
+func sessionsStopCmd(opts *sessionsOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "stop [schema://]<session>...",
+		Short: "Stop debugger sessions and remove their containers",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			auditLog, err := audit.New(cfg.Audit)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := auditLog.Close(); err != nil {
+					fmt.Fprintln(cmd.ErrOrStderr(), err)
+				}
+			}()
+			clistream := iocli.NewCliStream(os.Stdin, os.Stdout, os.Stderr)
+			for _, session := range args {
+				execOpts, err := opts.execOptions(session)
+				if err != nil {
+					return err
+				}
+				execOpts.Audit = auditLog
+				manager, err := resolveSessionManager(cmd.Context(), execOpts, clistream)
+				if err != nil {
+					return err
+				}
+				if err := exec.StopSession(cmd.Context(), manager, execOpts); err != nil {
+					return err
+				}
+				clistream.PrintOut("%s\n", session)
+			}
+			return nil
+		},
+	}
+}
+
+func sessionsLogsCmd(opts *sessionsOptions) *cobra.Command {
+	var follow bool
+
+	cmd := &cobra.Command{
+		Use:   "logs [schema://]<session>",
+		Short: "Show the output of a debugger session",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			execOpts, err := opts.execOptions(args[0])
+			if err != nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+
+			clistream := iocli.NewCliStream(os.Stdin, os.Stdout, os.Stderr)
+			manager, err := resolveSessionManager(cmd.Context(), execOpts, clistream)
+			if err != nil {
+				return err
+			}
+			return manager.SessionLogs(cmd.Context(), execOpts.Target, follow, clistream.OutputStream(), clistream.ErrorStream())
+		},
+	}
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "Follow the output until the debugger exits")
+	return cmd
+}
+
+func resolveSessionManager(ctx context.Context, execOpts *exec.ExecOptions, clistream *iocli.CliStream) (exec.SessionManager, error) {
+	client, err := resolveClient(ctx, execOpts, clistream)
+	if err != nil {
+		return nil, err
+	}
+	manager, ok := client.(exec.SessionManager)
+	if !ok {
+		return nil, fmt.Errorf("sessions are not supported for %s targets", execOpts.Schema)
+	}
+	return manager, nil
+}
+
+// sessionProbes returns the runtimes the sessions are listed from: the one of
+// --runtime-type, or the local runtimes and the pid:// sessions when run as
+// root. Clusters are only listed with --runtime-type k8s.
+func sessionProbes(execOpts *exec.ExecOptions) ([]probe, error) {
+	if execOpts.RuntimeType != "" {
+		schema := execOpts.RuntimeType + "://"
+		if schema == schemaCompose {
+			schema = schemaDocker
+		}
+		if !slices.Contains(schemas, schema) {
+			return nil, fmt.Errorf("unknown runtime type %q", execOpts.RuntimeType)
+		}
+		return []probe{{schema: schema, address: execOpts.Runtime}}, nil
+	}
+	probes := defaultProbes(execOpts)
+	if goruntime.GOOS == "linux" && os.Geteuid() == 0 && execOpts.Runtime == "" {
+		probes = append(probes, probe{schema: schemaPid})
+	}
+	if len(probes) == 0 {
+		return nil, fmt.Errorf("no container runtime found on this host, use --runtime or --runtime-type")
+	}
+	return probes, nil
+}
+
+// listedSession is a session with the schema of its runtime.
+type listedSession struct {
+	exec.Session
+	schema string
+}
+
+// listSessions lists the sessions of every runtime, the runtimes which can't
+// be reached are reported and skipped unless none of them can be.
+func listSessions(ctx context.Context, execOpts *exec.ExecOptions, probes []probe, clistream *iocli.CliStream) ([]listedSession, error) {
+	sessions := []listedSession{}
+	var errs []string
+	for _, p := range probes {
+		found, err := p.listSessions(ctx, execOpts)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", p, err))
+			continue
+		}
+		for _, s := range found {
+			sessions = append(sessions, listedSession{Session: s, schema: p.schema})
+		}
+	}
+	if len(errs) == len(probes) {
+		return nil, fmt.Errorf("failed to list the debugger sessions:\n  %s", strings.Join(errs, "\n  "))
+	}
+	for _, e := range errs {
+		clistream.PrintAux("Skipping %s\n", e)
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Started.After(sessions[j].Started)
+	})
+	return sessions, nil
+}
+
+func (p probe) listSessions(ctx context.Context, execOpts *exec.ExecOptions) ([]exec.Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	manager, err := p.sessionManager(ctx, execOpts)
+	if err != nil {
+		return nil, err
+	}
+	return manager.ListSessions(ctx)
+}
+
+// sessionManager connects to the runtime quietly, as the runtimes are used
+// in turn.
+func (p probe) sessionManager(ctx context.Context, execOpts *exec.ExecOptions) (exec.SessionManager, error) {
+	opts := *execOpts
+	opts.Schema = p.schema
+	opts.Runtime = p.address
+	quiet := iocli.NewCliStream(io.NopCloser(strings.NewReader("")), io.Discard, io.Discard)
+	client, err := newDebuggerClient(ctx, &opts, quiet)
+	if err != nil {
+		return nil, err
+	}
+	manager, ok := client.(exec.SessionManager)
+	if !ok {
+		return nil, fmt.Errorf("sessions are not supported")
+	}
+	return manager, nil
+}
+
+func printSessions(out io.Writer, sessions []listedSession, now time.Time) {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "SESSION\tTARGET\tCOMMAND\tUSER\tSTARTED\tSTATUS")
+	for _, s := range sessions {
+		started := "unknown"
+		if !s.Started.IsZero() {
+			started = units.HumanDuration(now.Sub(s.Started)) + " ago"
+		}
+		fmt.Fprintf(w, "%s%s\t%s\t%s\t%s\t%s\t%s\n", s.schema, s.ID, s.Target, s.Command, s.User, started, s.Status)
+	}
+	w.Flush()
+}
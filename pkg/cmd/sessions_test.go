@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+)
+
+func TestListSessions(t *testing.T) {
+	podman := probe{schema: schemaPodman, address: fakePodman(t, "web", "conxec-debugger-1a2b3c4d")}
+	unreachable := probe{schema: schemaPodman, address: "/nonexistent/podman.sock"}
+
+	var aux bytes.Buffer
+	cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, &aux)
+	sessions, err := listSessions(context.Background(), &exec.ExecOptions{}, []probe{podman, unreachable}, cliStream)
+	if err != nil {
+		t.Fatalf("listSessions() error = %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].schema != schemaPodman || sessions[0].ID != "conxec-debugger-1a2b3c4d" {
+		t.Errorf("listSessions() = %+v, want the podman debugger", sessions)
+	}
+	if !strings.Contains(aux.String(), "Skipping podman (/nonexistent/podman.sock)") {
+		t.Errorf("unreachable runtime not reported, output = %q", aux.String())
+	}
+
+	if _, err := listSessions(context.Background(), &exec.ExecOptions{}, []probe{unreachable}, cliStream); err == nil {
+		t.Errorf("listSessions() without any reachable runtime succeeded")
+	}
+}
+
+func TestPrintSessions(t *testing.T) {
+	now := time.Date(2023, 11, 2, 10, 5, 0, 0, time.UTC)
+	var out bytes.Buffer
+	printSessions(&out, []listedSession{{
+		Session: exec.Session{
+			ID:      "conxec-debugger-1a2b3c4d",
+			Target:  "web",
+			Command: "ps aux",
+			User:    "root:root",
+			Started: now.Add(-5 * time.Minute),
+			Status:  "running",
+		},
+		schema: schemaDocker,
+	}}, now)
+
+	want := "SESSION                             TARGET   COMMAND   USER        STARTED         STATUS\n" +
+		"docker://conxec-debugger-1a2b3c4d   web      ps aux    root:root   5 minutes ago   running\n"
+	if out.String() != want {
+		t.Errorf("printSessions() =\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,113 @@
+package exec
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// artefactName matches the symlinks the entrypoint creates in the /tmp of the
+// target, see conxec-entrypoint.templ.
+var artefactName = regexp.MustCompile(`^\.conxec-(bin|usrbin|mount|script)-([0-9a-f]+)$`)
+
+// artefactTarget matches the targets of the symlinks, in the root of the
+// entrypoint of the debugger as seen from the PID namespace of the target.
+var artefactTarget = regexp.MustCompile(`^/proc/([0-9]+)/root/`)
+
+// Artefact is a symlink left by a debugger in the /tmp of a target, when the
+// entrypoint did not run to completion.
+type Artefact struct {
+	Path    string // Path is the path of the symlink from the host, through /proc/<pid>/root
+	RunID   string
+	Pid     int
+	Command string // Command is the name of the process the target was found from
+	// Alive tells whether the entrypoint the symlink points to still runs,
+	// nil when it can't be told from the symlink and the /proc of the target
+	Alive *bool
+}
+
+// FindArtefacts looks the artefacts up in the /tmp of every mount namespace of
+// the host, reached through the root of one of its processes.
+func FindArtefacts(procDir string) ([]Artefact, error) {
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %w", err)
+	}
+	seen := map[string]bool{}
+	artefacts := []Artefact{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		procPath := filepath.Join(procDir, entry.Name())
+		// kernel threads have no command line, and processes exit while being
+		// looked at, they are skipped
+		if cmdline, err := os.ReadFile(filepath.Join(procPath, "cmdline")); err != nil || len(cmdline) == 0 {
+			continue
+		}
+		mntNs, err := os.Readlink(filepath.Join(procPath, "ns", "mnt"))
+		if err != nil || seen[mntNs] {
+			continue
+		}
+		seen[mntNs] = true
+
+		tmp := filepath.Join(procPath, "root", "tmp")
+		// a symlinked /tmp would be resolved on the host, not in the target
+		if fi, err := os.Lstat(tmp); err != nil || !fi.IsDir() {
+			continue
+		}
+		files, err := os.ReadDir(tmp)
+		if err != nil {
+			continue
+		}
+		comm, _ := os.ReadFile(filepath.Join(procPath, "comm"))
+		for _, f := range files {
+			m := artefactName.FindStringSubmatch(f.Name())
+			if m == nil || f.Type()&os.ModeSymlink == 0 {
+				continue
+			}
+			path := filepath.Join(tmp, f.Name())
+			artefacts = append(artefacts, Artefact{
+				Path:    path,
+				RunID:   m[2],
+				Pid:     pid,
+				Command: strings.TrimSpace(string(comm)),
+				Alive:   debuggerAlive(filepath.Join(procPath, "root", "proc"), path, m[2]),
+			})
+		}
+	}
+	return artefacts, nil
+}
+
+// debuggerAlive tells whether the debugger of the run which created the
+// symlink still runs: the process it points to, looked up in the /proc of the
+// target, is the entrypoint of the run, which holds the run ID in its command
+// line. It is nil when the symlink or the /proc of the target can't be read.
+func debuggerAlive(targetProc, link, runID string) *bool {
+	dest, err := os.Readlink(link)
+	if err != nil {
+		return nil
+	}
+	m := artefactTarget.FindStringSubmatch(dest)
+	if m == nil {
+		return nil
+	}
+	// every PID namespace has a PID 1, the /proc of the target is mounted
+	if _, err := os.Stat(filepath.Join(targetProc, "1")); err != nil {
+		return nil
+	}
+	alive := false
+	cmdline, err := os.ReadFile(filepath.Join(targetProc, m[1], "cmdline"))
+	switch {
+	case err == nil:
+		alive = bytes.Contains(cmdline, []byte(".conxec-bin-"+runID))
+	case !os.IsNotExist(err):
+		return nil
+	}
+	return &alive
+}
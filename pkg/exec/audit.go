@@ -0,0 +1,90 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/debasishbsws/conxec/pkg/audit"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+)
+
+// auditEvent returns the audit event of a debugger session, its type is set
+// when it is logged. The digest of the debugger image is only looked up when
+// the sessions are audited.
+func auditEvent(ctx context.Context, client DebuggerClient, opts *ExecOptions, runID string, target *ContainerInspectInfo, command []string) audit.Event {
+	event := audit.Event{
+		Session:       runID,
+		Debugger:      opts.Name,
+		Target:        opts.Schema + opts.Target,
+		TargetID:      target.ID,
+		TargetName:    target.Name,
+		TargetImage:   target.Image,
+		DebuggerImage: opts.DbgImg,
+		Command:       command,
+		Packages:      opts.AditionalPackages,
+		MountDir:      opts.mountDir,
+		Privileged:    target.IsPrivileged,
+		Detached:      opts.Detach,
+	}
+	if digester, ok := client.(ImageDigester); ok && opts.Audit != nil {
+		// the audit log goes without the digest rather than failing the session
+		event.DebuggerImageDigest, _ = digester.ImageDigest(ctx, opts.DbgImg)
+	}
+	return event
+}
+
+// auditEnd logs how the attached session ended: its exit code, an error, or
+// a detach leaving it running.
+func auditEnd(logger *audit.Logger, event audit.Event, err error) {
+	event.Event = audit.EventEnd
+	var statusErr iocli.StatusError
+	switch {
+	case err == nil:
+		code := 0
+		event.ExitCode = &code
+	case errors.Is(err, iocli.ErrDetached):
+		event.Event = audit.EventDetach
+	case errors.As(err, &statusErr):
+		code := statusErr.Code()
+		event.ExitCode = &code
+	default:
+		event.Error = err.Error()
+	}
+	logger.Log(event)
+}
+
+// sessionAuditEvent returns the audit event of a detached session, the target
+// of the options is its debugger. The event is filled from the labels of the
+// debugger when the runtime lists it.
+func sessionAuditEvent(ctx context.Context, manager SessionManager, opts *ExecOptions) audit.Event {
+	event := audit.Event{Debugger: opts.Target, Detached: true}
+	if manager == nil || opts.Audit == nil {
+		return event
+	}
+	// the audit log goes without the labels rather than failing the session
+	sessions, _ := manager.ListSessions(ctx)
+	for _, s := range sessions {
+		if s.ID == opts.Target {
+			event.Session = s.RunID
+			event.Target = opts.Schema + s.Target
+			event.TargetID = s.Target
+			event.Command = strings.Fields(s.Command)
+			break
+		}
+	}
+	return event
+}
+
+// StopSession stops a debugger session, the target of the options is its
+// debugger, and logs it to the audit log.
+func StopSession(ctx context.Context, manager SessionManager, opts *ExecOptions) error {
+	event := sessionAuditEvent(ctx, manager, opts)
+	err := manager.StopSession(ctx, opts.Target)
+	event.Event = audit.EventStop
+	if err != nil {
+		event.Error = err.Error()
+	}
+	opts.Audit.Log(event)
+	return err
+}
@@ -0,0 +1,301 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/oci"
+	"github.com/containerd/containerd/platforms"
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+	"github.com/docker/cli/cli/streams"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	defaultAddress   = "/run/containerd/containerd.sock"
+	defaultNamespace = "default"
+)
+
+type ContainerdClient struct {
+	services   services
+	namespace  string
+	out        *streams.Out
+	targetSpec *oci.Spec
+	share      exec.Share
+}
+
+// NewClient connects to containerd at the runtime address. The target may be
+// prefixed with a containerd namespace (e.g: k8s.io/<container-id>), otherwise
+// $CONTAINERD_NAMESPACE or the "default" namespace is used.
+func NewClient(ctx context.Context, opts *exec.ExecOptions, clistream *iocli.CliStream) (*ContainerdClient, error) {
+	address := opts.Runtime
+	if address == "" {
+		address = defaultAddress
+	}
+	client, err := containerd.New(address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create containerd client: %w", err)
+	}
+
+	c := newClient(&containerdServices{client: client}, clistream)
+	opts.Target, c.namespace = splitNamespace(opts.Target)
+	c.share = opts.Share
+	return c, nil
+}
+
+func newClient(svc services, clistream *iocli.CliStream) *ContainerdClient {
+	return &ContainerdClient{
+		services:  svc,
+		namespace: defaultNamespace,
+		out:       clistream.AuxStream(),
+		share:     exec.DefaultShare,
+	}
+}
+
+func splitNamespace(target string) (string, string) {
+	if ns, id, ok := strings.Cut(target, "/"); ok {
+		return id, ns
+	}
+	if ns := os.Getenv("CONTAINERD_NAMESPACE"); ns != "" {
+		return target, ns
+	}
+	return target, defaultNamespace
+}
+
+func (c *ContainerdClient) withNamespace(ctx context.Context) context.Context {
+	return namespaces.WithNamespace(ctx, c.namespace)
+}
+
+func (c *ContainerdClient) GetContainerInfo(ctx context.Context, container string) (*exec.ContainerInspectInfo, error) {
+	ctx = c.withNamespace(ctx)
+	spec, err := c.services.Spec(ctx, container)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to inspect container: %w", err)
+	}
+	pid, status, err := c.services.TaskStatus(ctx, container)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get task of container: %w", err)
+	}
+
+	info := &exec.ContainerInspectInfo{
+		ID:            container,
+		Isrunning:     status == containerd.Running,
+		IsPrivileged:  isPrivileged(spec),
+		IsPidModeHost: !hasNamespace(spec, specs.PIDNamespace),
+		Pid:           int(pid),
+		Platform:      platforms.DefaultString(),
+	}
+	// the debugger is pulled for the host when the image of the target is gone
+	if platform, err := c.services.ImagePlatform(ctx, container); err == nil {
+		info.Platform = platform
+	}
+	if spec.Process != nil {
+		info.User = spec.Process.User.Username
+		if info.User == "" {
+			info.User = strconv.FormatUint(uint64(spec.Process.User.UID), 10)
+		}
+	}
+	// containerd containers have no name, only the image is looked up
+	if conts, err := c.services.Containers(ctx, "id=="+container); err == nil && len(conts) == 1 {
+		info.Image = conts[0].Image
+	}
+	c.targetSpec = spec
+	return info, nil
+}
+
+func (c *ContainerdClient) PullImage(ctx context.Context, image string, platform string) error {
+	ctx = c.withNamespace(ctx)
+	present, err := c.services.HasImage(ctx, image)
+	if err != nil {
+		return fmt.Errorf("failed to look up image: %w", err)
+	}
+	if present {
+		fmt.Fprintln(c.out, "Debugger image already present")
+		return nil
+	}
+	if err := c.services.Pull(ctx, image, platform); err != nil {
+		return fmt.Errorf("failed to pull image: %w", err)
+	}
+	return nil
+}
+
+func (c *ContainerdClient) CreateContainer(ctx context.Context, targetInspect *exec.ContainerInspectInfo,
+	image, entrypoint, user, containerName string, labels map[string]string,
+	tty, stdin bool, mountDir string,
+) (string, error) {
+	specOpts, err := c.debuggerSpecOpts(targetInspect, entrypoint, user, tty, mountDir)
+	if err != nil {
+		return "", err
+	}
+	if err := c.services.NewContainer(c.withNamespace(ctx), containerName, image, labels, specOpts); err != nil {
+		return "", fmt.Errorf("failed to create container: %w", err)
+	}
+	return containerName, nil
+}
+
+// debuggerSpecOpts builds the spec of the debugger container that joins the
+// PID and network namespaces of the target task.
+func (c *ContainerdClient) debuggerSpecOpts(targetInspect *exec.ContainerInspectInfo,
+	entrypoint, user string, tty bool, mountDir string,
+) ([]oci.SpecOpts, error) {
+	specOpts := []oci.SpecOpts{
+		oci.WithProcessArgs("sh", "-c", entrypoint),
+		oci.WithUser(user),
+	}
+	// the namespaces which are not shared are new ones, as in the default spec
+	for _, ns := range []struct {
+		nsType specs.LinuxNamespaceType
+		name   string
+		shared bool
+	}{
+		{specs.PIDNamespace, "pid", c.share.Pid},
+		{specs.NetworkNamespace, "net", c.share.Net},
+		{specs.IPCNamespace, "ipc", c.share.IPC},
+		{specs.UTSNamespace, "uts", c.share.UTS},
+	} {
+		if ns.shared {
+			specOpts = append(specOpts, oci.WithLinuxNamespace(specs.LinuxNamespace{
+				Type: ns.nsType,
+				Path: fmt.Sprintf("/proc/%d/ns/%s", targetInspect.Pid, ns.name),
+			}))
+		}
+	}
+	if tty {
+		specOpts = append(specOpts, oci.WithTTY)
+	}
+	if targetInspect.IsPrivileged {
+		specOpts = append(specOpts, oci.WithPrivileged)
+	} else if c.targetSpec != nil && c.targetSpec.Process != nil && c.targetSpec.Process.Capabilities != nil {
+		specOpts = append(specOpts, oci.WithCapabilities(c.targetSpec.Process.Capabilities.Bounding))
+	}
+	if mountDir != "" {
+		absMountDir, err := filepath.Abs(mountDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert %s to absolute path: %w", mountDir, err)
+		}
+		specOpts = append(specOpts, oci.WithMounts([]specs.Mount{{
+			Destination: "/work",
+			Type:        "bind",
+			Source:      absMountDir,
+			Options:     []string{"rbind", "rw"},
+		}}))
+	}
+	return specOpts, nil
+}
+
+func (c *ContainerdClient) AttachContainer(ctx context.Context, containerID string, tty, stdin bool, cliStream *iocli.CliStream) error {
+	ctx = c.withNamespace(ctx)
+	// remove the debugger container when it exits, like docker's AutoRemove
+	defer func() {
+		if err := c.services.DeleteContainer(context.WithoutCancel(ctx), containerID); err != nil {
+			fmt.Fprintf(c.out, "failed to remove debugger container: %s\n", err)
+		}
+	}()
+
+	recorder := cliStream.Recorder()
+	var cin io.Reader
+	if stdin {
+		cin = recorder.TeeInput(cliStream.InputStream())
+	}
+	cout := recorder.TeeOutput(cliStream.OutputStream())
+	cerr := recorder.TeeOutput(cliStream.ErrorStream())
+	ioOpts := []cio.Opt{cio.WithStreams(cin, cout, cerr)}
+	if tty {
+		ioOpts = append(ioOpts, cio.WithTerminal)
+	}
+
+	t, err := c.services.NewTask(ctx, containerID, cio.NewCreator(ioOpts...))
+	if err != nil {
+		return fmt.Errorf("failed to create debugger task: %w", err)
+	}
+	defer t.Delete(context.WithoutCancel(ctx), containerd.WithProcessKill)
+
+	statusCh, err := t.Wait(ctx)
+	if err != nil {
+		return fmt.Errorf("waiting debugger task failed: %w", err)
+	}
+
+	if tty {
+		if err := cliStream.InputStream().SetRawTerminal(); err != nil {
+			return fmt.Errorf("failed to set raw terminal: %w", err)
+		}
+		defer cliStream.InputStream().RestoreTerminal()
+	}
+
+	if err := t.Start(ctx); err != nil {
+		return fmt.Errorf("cannot start debugger task: %w", err)
+	}
+
+	if tty && cliStream.OutputStream().IsTerminal() {
+		// the resizing ends with the attach
+		resizeCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		iocli.StartResizing(resizeCtx, cliStream, func(ctx context.Context, height, width uint) error {
+			return t.Resize(ctx, uint32(width), uint32(height))
+		})
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case status := <-statusCh:
+		code, _, err := status.Result()
+		if err != nil {
+			return fmt.Errorf("waiting debugger task failed: %w", err)
+		}
+		return exec.ExitStatus(int(code))
+	}
+}
+
+// ImageDigest returns the reference by digest of the image.
+func (c *ContainerdClient) ImageDigest(ctx context.Context, image string) (string, error) {
+	digest, err := c.services.ImageDigest(c.withNamespace(ctx), image)
+	if err != nil {
+		return "", fmt.Errorf("failed to get image: %w", err)
+	}
+	return digest, nil
+}
+
+// SignalContainer sends the signal to the entrypoint of the debugger.
+func (c *ContainerdClient) SignalContainer(ctx context.Context, containerID string, sig syscall.Signal) error {
+	return c.services.SignalTask(c.withNamespace(ctx), containerID, sig)
+}
+
+func hasNamespace(spec *oci.Spec, nsType specs.LinuxNamespaceType) bool {
+	if spec.Linux == nil {
+		return false
+	}
+	for _, ns := range spec.Linux.Namespaces {
+		if ns.Type == nsType {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrivileged reports whether the spec looks like the one of a privileged
+// container: no masked or read-only paths and CAP_SYS_ADMIN in the bounding set.
+func isPrivileged(spec *oci.Spec) bool {
+	if spec.Linux == nil || len(spec.Linux.MaskedPaths) != 0 || len(spec.Linux.ReadonlyPaths) != 0 {
+		return false
+	}
+	if spec.Process == nil || spec.Process.Capabilities == nil {
+		return false
+	}
+	for _, c := range spec.Process.Capabilities.Bounding {
+		if c == "CAP_SYS_ADMIN" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,447 @@
+package containerd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/oci"
+	"github.com/containerd/containerd/platforms"
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+type fakeTask struct {
+	started  bool
+	deleted  bool
+	exitCode uint32
+	signals  []syscall.Signal
+}
+
+func (t *fakeTask) Start(ctx context.Context) error {
+	t.started = true
+	return nil
+}
+
+func (t *fakeTask) Wait(ctx context.Context) (<-chan containerd.ExitStatus, error) {
+	ch := make(chan containerd.ExitStatus, 1)
+	ch <- *containerd.NewExitStatus(t.exitCode, time.Now(), nil)
+	return ch, nil
+}
+
+func (t *fakeTask) Resize(ctx context.Context, w, h uint32) error {
+	return nil
+}
+
+func (t *fakeTask) Delete(ctx context.Context, opts ...containerd.ProcessDeleteOpts) (*containerd.ExitStatus, error) {
+	t.deleted = true
+	return nil, nil
+}
+
+type fakeServices struct {
+	namespace  string
+	specs      map[string]*oci.Spec
+	pids       map[string]uint32
+	images     map[string]bool
+	platforms  map[string]string // platforms are the ones of the images of the containers
+	pulled     string            // pulled is the platform of the last pull
+	containers map[string][]oci.SpecOpts
+	labels     map[string]map[string]string
+	task       *fakeTask
+}
+
+func newFakeServices() *fakeServices {
+	return &fakeServices{
+		specs:      map[string]*oci.Spec{},
+		pids:       map[string]uint32{},
+		images:     map[string]bool{},
+		platforms:  map[string]string{},
+		containers: map[string][]oci.SpecOpts{},
+		labels:     map[string]map[string]string{},
+		task:       &fakeTask{},
+	}
+}
+
+func (s *fakeServices) checkNamespace(ctx context.Context) error {
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return err
+	}
+	s.namespace = ns
+	return nil
+}
+
+func (s *fakeServices) Spec(ctx context.Context, id string) (*oci.Spec, error) {
+	if err := s.checkNamespace(ctx); err != nil {
+		return nil, err
+	}
+	spec, ok := s.specs[id]
+	if !ok {
+		return nil, errdefs.ErrNotFound
+	}
+	return spec, nil
+}
+
+func (s *fakeServices) TaskStatus(ctx context.Context, id string) (uint32, containerd.ProcessStatus, error) {
+	pid, ok := s.pids[id]
+	if !ok {
+		return 0, containerd.Stopped, nil
+	}
+	return pid, containerd.Running, nil
+}
+
+func (s *fakeServices) HasImage(ctx context.Context, ref string) (bool, error) {
+	return s.images[ref], nil
+}
+
+func (s *fakeServices) ImageDigest(ctx context.Context, ref string) (string, error) {
+	if !s.images[ref] {
+		return "", errdefs.ErrNotFound
+	}
+	return ref + "@sha256:0123", nil
+}
+
+func (s *fakeServices) ImagePlatform(ctx context.Context, id string) (string, error) {
+	platform, ok := s.platforms[id]
+	if !ok {
+		return "", errdefs.ErrNotFound
+	}
+	return platform, nil
+}
+
+func (s *fakeServices) Pull(ctx context.Context, ref string, platform string) error {
+	if err := s.checkNamespace(ctx); err != nil {
+		return err
+	}
+	s.images[ref] = true
+	s.pulled = platform
+	return nil
+}
+
+func (s *fakeServices) NewContainer(ctx context.Context, id, ref string, labels map[string]string, specOpts []oci.SpecOpts) error {
+	if !s.images[ref] {
+		return errdefs.ErrNotFound
+	}
+	s.containers[id] = specOpts
+	s.labels[id] = labels
+	return nil
+}
+
+func (s *fakeServices) NewTask(ctx context.Context, id string, ioCreator cio.Creator) (task, error) {
+	if _, ok := s.containers[id]; !ok {
+		return nil, errdefs.ErrNotFound
+	}
+	return s.task, nil
+}
+
+func (s *fakeServices) DeleteContainer(ctx context.Context, id string) error {
+	delete(s.containers, id)
+	delete(s.labels, id)
+	return nil
+}
+
+func (s *fakeServices) Namespaces(ctx context.Context) ([]string, error) {
+	return []string{defaultNamespace}, nil
+}
+
+// Containers returns the debugger containers, the filters are only checked
+// for an id.
+func (s *fakeServices) Containers(ctx context.Context, filters ...string) ([]containers.Container, error) {
+	if err := s.checkNamespace(ctx); err != nil {
+		return nil, err
+	}
+	var list []containers.Container
+	for id, labels := range s.labels {
+		if _, ok := labels[exec.LabelSession]; !ok {
+			continue
+		}
+		if len(filters) > 0 && strings.HasPrefix(filters[0], "id==") && !strings.HasPrefix(filters[0], "id=="+id+",") {
+			continue
+		}
+		list = append(list, containers.Container{ID: id, Labels: labels})
+	}
+	return list, nil
+}
+
+func (s *fakeServices) KillTask(ctx context.Context, id string) error {
+	delete(s.pids, id)
+	return nil
+}
+
+func (s *fakeServices) SignalTask(ctx context.Context, id string, sig syscall.Signal) error {
+	if err := s.checkNamespace(ctx); err != nil {
+		return err
+	}
+	if _, ok := s.containers[id]; !ok {
+		return errdefs.ErrNotFound
+	}
+	s.task.signals = append(s.task.signals, sig)
+	return nil
+}
+
+func targetSpec(withPidNamespace bool) *oci.Spec {
+	spec := &oci.Spec{
+		Process: &specs.Process{
+			User: specs.User{UID: 65532},
+			Capabilities: &specs.LinuxCapabilities{
+				Bounding: []string{"CAP_CHOWN", "CAP_NET_RAW"},
+			},
+		},
+		Linux: &specs.Linux{
+			MaskedPaths: []string{"/proc/kcore"},
+		},
+	}
+	if withPidNamespace {
+		spec.Linux.Namespaces = []specs.LinuxNamespace{{Type: specs.PIDNamespace}}
+	}
+	return spec
+}
+
+func TestSplitNamespace(t *testing.T) {
+	t.Setenv("CONTAINERD_NAMESPACE", "")
+	tests := []struct {
+		target string
+		id     string
+		ns     string
+	}{
+		{target: "nginx", id: "nginx", ns: "default"},
+		{target: "k8s.io/0123abcd", id: "0123abcd", ns: "k8s.io"},
+		{target: "default/nginx", id: "nginx", ns: "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			id, ns := splitNamespace(tt.target)
+			if id != tt.id || ns != tt.ns {
+				t.Errorf("splitNamespace(%q) = %q, %q, want %q, %q", tt.target, id, ns, tt.id, tt.ns)
+			}
+		})
+	}
+}
+
+func TestGetContainerInfo(t *testing.T) {
+	svc := newFakeServices()
+	svc.specs["app"] = targetSpec(true)
+	svc.pids["app"] = 4242
+	svc.specs["stopped"] = targetSpec(false)
+
+	c := newClient(svc, iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard))
+	c.namespace = "k8s.io"
+
+	info, err := c.GetContainerInfo(context.Background(), "app")
+	if err != nil {
+		t.Fatalf("GetContainerInfo() error = %v", err)
+	}
+	if svc.namespace != "k8s.io" {
+		t.Errorf("namespace = %q, want %q", svc.namespace, "k8s.io")
+	}
+	// the image of the target is gone, the debugger is for the host
+	want := exec.ContainerInspectInfo{ID: "app", Isrunning: true, Pid: 4242, User: "65532", Platform: platforms.DefaultString()}
+	if *info != want {
+		t.Errorf("GetContainerInfo() = %+v, want %+v", *info, want)
+	}
+
+	// the target runs an image of another platform than the host, emulated
+	svc.platforms["app"] = "linux/s390x"
+	if info, err = c.GetContainerInfo(context.Background(), "app"); err != nil {
+		t.Fatalf("GetContainerInfo() error = %v", err)
+	}
+	if info.Platform != "linux/s390x" {
+		t.Errorf("platform = %q, want the one of the image of the target", info.Platform)
+	}
+
+	info, err = c.GetContainerInfo(context.Background(), "stopped")
+	if err != nil {
+		t.Fatalf("GetContainerInfo() error = %v", err)
+	}
+	if info.Isrunning || !info.IsPidModeHost {
+		t.Errorf("GetContainerInfo() = %+v, want stopped task in host pid mode", *info)
+	}
+
+	if _, err := c.GetContainerInfo(context.Background(), "missing"); !errors.Is(err, errdefs.ErrNotFound) {
+		t.Errorf("GetContainerInfo() error = %v, want not found", err)
+	}
+}
+
+func TestRunDebugger(t *testing.T) {
+	svc := newFakeServices()
+	svc.specs["app"] = targetSpec(true)
+	svc.pids["app"] = 4242
+	svc.platforms["app"] = "linux/s390x"
+
+	cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard)
+	c := newClient(svc, cliStream)
+	opts, err := exec.New([]exec.Option{
+		exec.WithTarget("app"),
+		exec.WithCommand([]string{"ls"}),
+		exec.WithDebuggerImage(""),
+		exec.WithUser("root:0::root:0"),
+		exec.WithName("conxec-debugger-test"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := exec.RunDebugger(context.Background(), c, opts, cliStream); err != nil {
+		t.Fatalf("RunDebugger() error = %v", err)
+	}
+	if !svc.images[opts.DbgImg] {
+		t.Errorf("debugger image %q was not pulled", opts.DbgImg)
+	}
+	if svc.pulled != "linux/s390x" {
+		t.Errorf("debugger image pulled for %q, want the platform of the target", svc.pulled)
+	}
+	if !svc.task.started || !svc.task.deleted {
+		t.Errorf("debugger task started = %v, deleted = %v, want both", svc.task.started, svc.task.deleted)
+	}
+	if _, ok := svc.containers["conxec-debugger-test"]; ok {
+		t.Errorf("debugger container was not removed")
+	}
+}
+
+func TestRunDebuggerExitStatus(t *testing.T) {
+	svc := newFakeServices()
+	svc.specs["app"] = targetSpec(true)
+	svc.pids["app"] = 4242
+	svc.task.exitCode = 3
+
+	cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard)
+	opts, err := exec.New([]exec.Option{
+		exec.WithTarget("app"),
+		exec.WithCommand([]string{"false"}),
+		exec.WithDebuggerImage(""),
+		exec.WithUser("root:0::root:0"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = exec.RunDebugger(context.Background(), newClient(svc, cliStream), opts, cliStream)
+	var statusErr iocli.StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code() != 3 {
+		t.Errorf("RunDebugger() error = %v, want exit status 3", err)
+	}
+}
+
+func TestDebuggerSpecOpts(t *testing.T) {
+	c := newClient(newFakeServices(), iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard))
+	c.targetSpec = targetSpec(true)
+
+	specOpts, err := c.debuggerSpecOpts(&exec.ContainerInspectInfo{Pid: 4242}, "echo hi", "0:0", true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := &oci.Spec{Process: &specs.Process{}, Linux: &specs.Linux{}}
+	if err := oci.ApplyOpts(context.Background(), nil, &containers.Container{}, spec, specOpts...); err != nil {
+		t.Fatal(err)
+	}
+
+	wantNs := map[specs.LinuxNamespaceType]string{
+		specs.PIDNamespace:     "/proc/4242/ns/pid",
+		specs.NetworkNamespace: "/proc/4242/ns/net",
+	}
+	for _, ns := range spec.Linux.Namespaces {
+		if path, ok := wantNs[ns.Type]; ok && ns.Path == path {
+			delete(wantNs, ns.Type)
+		}
+	}
+	if len(wantNs) != 0 {
+		t.Errorf("namespaces %v not joined, got %+v", wantNs, spec.Linux.Namespaces)
+	}
+	if !spec.Process.Terminal {
+		t.Errorf("terminal not enabled")
+	}
+	if got := spec.Process.Args; len(got) != 3 || got[2] != "echo hi" {
+		t.Errorf("args = %q, want entrypoint", got)
+	}
+	if got := spec.Process.Capabilities.Bounding; len(got) != 2 {
+		t.Errorf("capabilities = %q, want the target ones", got)
+	}
+}
+
+func TestDebuggerSpecOptsShare(t *testing.T) {
+	c := newClient(newFakeServices(), iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard))
+	c.share = exec.Share{Net: true, IPC: true, UTS: true}
+
+	specOpts, err := c.debuggerSpecOpts(&exec.ContainerInspectInfo{Pid: 4242}, "echo hi", "0:0", false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := &oci.Spec{Process: &specs.Process{}, Linux: &specs.Linux{Namespaces: []specs.LinuxNamespace{
+		{Type: specs.PIDNamespace}, {Type: specs.NetworkNamespace}, {Type: specs.IPCNamespace}, {Type: specs.UTSNamespace},
+	}}}
+	if err := oci.ApplyOpts(context.Background(), nil, &containers.Container{}, spec, specOpts...); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []specs.LinuxNamespace{
+		{Type: specs.PIDNamespace},
+		{Type: specs.NetworkNamespace, Path: "/proc/4242/ns/net"},
+		{Type: specs.IPCNamespace, Path: "/proc/4242/ns/ipc"},
+		{Type: specs.UTSNamespace, Path: "/proc/4242/ns/uts"},
+	}
+	if !reflect.DeepEqual(spec.Linux.Namespaces, want) {
+		t.Errorf("namespaces = %+v, want %+v", spec.Linux.Namespaces, want)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	svc := newFakeServices()
+	svc.images["busybox"] = true
+	c := newClient(svc, iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard))
+	labels := exec.SessionLabels("1a2b3c4d", "app", []string{"sh"}, "root:root", time.Now())
+	if _, err := c.CreateContainer(c.withNamespace(context.Background()), &exec.ContainerInspectInfo{Pid: 4242},
+		"busybox", "sh", "0:0", "conxec-debugger-1a2b3c4d", labels, false, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	svc.pids["conxec-debugger-1a2b3c4d"] = 4243
+
+	sessions, err := c.ListSessions(context.Background())
+	if err != nil {
+		t.Fatalf("ListSessions() error = %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != "default/conxec-debugger-1a2b3c4d" || sessions[0].Target != "app" || sessions[0].Status != "running" {
+		t.Fatalf("ListSessions() = %+v, want the debugger session", sessions)
+	}
+
+	if err := c.StopSession(context.Background(), "app"); err == nil {
+		t.Errorf("StopSession() of a container which is not a debugger succeeded")
+	}
+	if err := c.StopSession(context.Background(), "conxec-debugger-1a2b3c4d"); err != nil {
+		t.Fatalf("StopSession() error = %v", err)
+	}
+	if _, ok := svc.containers["conxec-debugger-1a2b3c4d"]; ok {
+		t.Errorf("debugger container was not removed")
+	}
+}
+
+func TestSignalContainer(t *testing.T) {
+	svc := newFakeServices()
+	svc.images["busybox"] = true
+	c := newClient(svc, iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard))
+	if _, err := c.CreateContainer(c.withNamespace(context.Background()), &exec.ContainerInspectInfo{Pid: 4242},
+		"busybox", "sh", "0:0", "conxec-debugger-test", nil, false, false, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.SignalContainer(context.Background(), "conxec-debugger-test", syscall.SIGTERM); err != nil {
+		t.Fatalf("SignalContainer() error = %v", err)
+	}
+	if len(svc.task.signals) != 1 || svc.task.signals[0] != syscall.SIGTERM || svc.namespace != defaultNamespace {
+		t.Errorf("signals = %v in namespace %q, want SIGTERM in %q", svc.task.signals, svc.namespace, defaultNamespace)
+	}
+	if err := c.SignalContainer(context.Background(), "conxec-debugger-gone", syscall.SIGTERM); err == nil {
+		t.Errorf("SignalContainer() of an unknown container succeeded")
+	}
+}
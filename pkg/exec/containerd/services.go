@@ -0,0 +1,213 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"syscall"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/oci"
+	"github.com/containerd/containerd/platforms"
+)
+
+// services is the subset of containerd used by ContainerdClient. It is
+// implemented by containerdServices on top of a real containerd client, and
+// can be faked in tests so the backend runs without a daemon.
+type services interface {
+	// Spec returns the OCI runtime spec of the container.
+	Spec(ctx context.Context, id string) (*oci.Spec, error)
+	// TaskStatus returns the pid and the status of the container's task.
+	TaskStatus(ctx context.Context, id string) (uint32, containerd.ProcessStatus, error)
+	// HasImage reports whether the image is already in the image store.
+	HasImage(ctx context.Context, ref string) (bool, error)
+	// ImageDigest returns the reference of the image by digest.
+	ImageDigest(ctx context.Context, ref string) (string, error)
+	// ImagePlatform returns the platform of the image of the container, the
+	// one its layers are unpacked for.
+	ImagePlatform(ctx context.Context, id string) (string, error)
+	// Pull fetches the image into the content store and unpacks it.
+	Pull(ctx context.Context, ref string, platform string) error
+	// NewContainer creates a labeled container from the image with a new
+	// snapshot.
+	NewContainer(ctx context.Context, id, ref string, labels map[string]string, specOpts []oci.SpecOpts) error
+	// NewTask creates the task of the container with the given IO.
+	NewTask(ctx context.Context, id string, ioCreator cio.Creator) (task, error)
+	// DeleteContainer removes the container and its snapshot.
+	DeleteContainer(ctx context.Context, id string) error
+	// Namespaces returns the containerd namespaces.
+	Namespaces(ctx context.Context) ([]string, error)
+	// Containers returns the containers matching one of the filters.
+	Containers(ctx context.Context, filters ...string) ([]containers.Container, error)
+	// KillTask kills the task of the container and deletes it.
+	KillTask(ctx context.Context, id string) error
+	// SignalTask sends the signal to the init process of the container's task.
+	SignalTask(ctx context.Context, id string, sig syscall.Signal) error
+}
+
+// task is the subset of containerd.Task used to run the debugger.
+type task interface {
+	Start(ctx context.Context) error
+	Wait(ctx context.Context) (<-chan containerd.ExitStatus, error)
+	Resize(ctx context.Context, w, h uint32) error
+	Delete(ctx context.Context, opts ...containerd.ProcessDeleteOpts) (*containerd.ExitStatus, error)
+}
+
+type containerdServices struct {
+	client *containerd.Client
+}
+
+var _ services = &containerdServices{}
+
+func (s *containerdServices) Spec(ctx context.Context, id string) (*oci.Spec, error) {
+	cont, err := s.client.LoadContainer(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return cont.Spec(ctx)
+}
+
+func (s *containerdServices) TaskStatus(ctx context.Context, id string) (uint32, containerd.ProcessStatus, error) {
+	cont, err := s.client.LoadContainer(ctx, id)
+	if err != nil {
+		return 0, containerd.Unknown, err
+	}
+	t, err := cont.Task(ctx, nil)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return 0, containerd.Stopped, nil
+		}
+		return 0, containerd.Unknown, err
+	}
+	status, err := t.Status(ctx)
+	if err != nil {
+		return 0, containerd.Unknown, err
+	}
+	return t.Pid(), status.Status, nil
+}
+
+func (s *containerdServices) HasImage(ctx context.Context, ref string) (bool, error) {
+	if _, err := s.client.GetImage(ctx, ref); err != nil {
+		if errdefs.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *containerdServices) ImageDigest(ctx context.Context, ref string) (string, error) {
+	image, err := s.client.GetImage(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	return image.Name() + "@" + image.Target().Digest.String(), nil
+}
+
+func (s *containerdServices) ImagePlatform(ctx context.Context, id string) (string, error) {
+	cont, err := s.client.LoadContainer(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	info, err := cont.Info(ctx)
+	if err != nil {
+		return "", err
+	}
+	image, err := s.client.ImageService().Get(ctx, info.Image)
+	if err != nil {
+		return "", err
+	}
+	ps, err := images.Platforms(ctx, s.client.ContentStore(), image.Target)
+	if err != nil {
+		return "", err
+	}
+	// the platform of the host first, containerd unpacks it when it can
+	host := platforms.Default()
+	sort.SliceStable(ps, func(i, j int) bool { return host.Less(ps[i], ps[j]) })
+	for _, p := range ps {
+		unpacked, err := containerd.NewImageWithPlatform(s.client, image, platforms.OnlyStrict(p)).IsUnpacked(ctx, info.Snapshotter)
+		if err == nil && unpacked {
+			return platforms.Format(p), nil
+		}
+	}
+	return "", fmt.Errorf("no platform of image %q is unpacked", info.Image)
+}
+
+func (s *containerdServices) Pull(ctx context.Context, ref string, platform string) error {
+	if platform == "" {
+		platform = platforms.DefaultString()
+	}
+	_, err := s.client.Pull(ctx, ref, containerd.WithPullUnpack, containerd.WithPlatform(platform))
+	return err
+}
+
+func (s *containerdServices) NewContainer(ctx context.Context, id, ref string, labels map[string]string, specOpts []oci.SpecOpts) error {
+	image, err := s.client.GetImage(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("failed to get image %q: %w", ref, err)
+	}
+	specOpts = append([]oci.SpecOpts{oci.WithImageConfig(image)}, specOpts...)
+	_, err = s.client.NewContainer(ctx, id,
+		containerd.WithImage(image),
+		containerd.WithNewSnapshot(id+"-snapshot", image),
+		containerd.WithNewSpec(specOpts...),
+		containerd.WithContainerLabels(labels),
+	)
+	return err
+}
+
+func (s *containerdServices) NewTask(ctx context.Context, id string, ioCreator cio.Creator) (task, error) {
+	cont, err := s.client.LoadContainer(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return cont.NewTask(ctx, ioCreator)
+}
+
+func (s *containerdServices) DeleteContainer(ctx context.Context, id string) error {
+	cont, err := s.client.LoadContainer(ctx, id)
+	if err != nil {
+		return err
+	}
+	return cont.Delete(ctx, containerd.WithSnapshotCleanup)
+}
+
+func (s *containerdServices) Namespaces(ctx context.Context) ([]string, error) {
+	return s.client.NamespaceService().List(ctx)
+}
+
+func (s *containerdServices) Containers(ctx context.Context, filters ...string) ([]containers.Container, error) {
+	return s.client.ContainerService().List(ctx, filters...)
+}
+
+func (s *containerdServices) KillTask(ctx context.Context, id string) error {
+	cont, err := s.client.LoadContainer(ctx, id)
+	if err != nil {
+		return err
+	}
+	t, err := cont.Task(ctx, nil)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	_, err = t.Delete(ctx, containerd.WithProcessKill)
+	return err
+}
+
+func (s *containerdServices) SignalTask(ctx context.Context, id string, sig syscall.Signal) error {
+	cont, err := s.client.LoadContainer(ctx, id)
+	if err != nil {
+		return err
+	}
+	t, err := cont.Task(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return t.Kill(ctx, sig)
+}
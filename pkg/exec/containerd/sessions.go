@@ -0,0 +1,65 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/debasishbsws/conxec/pkg/exec"
+)
+
+// sessionFilter matches the containers labeled as debugger sessions.
+var sessionFilter = fmt.Sprintf("labels.%q", exec.LabelSession)
+
+// ListSessions returns the debugger containers of all the namespaces, their
+// ids are prefixed with the namespace.
+func (c *ContainerdClient) ListSessions(ctx context.Context) ([]exec.Session, error) {
+	nss, err := c.services.Namespaces(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	sessions := []exec.Session{}
+	for _, ns := range nss {
+		nsCtx := namespaces.WithNamespace(ctx, ns)
+		conts, err := c.services.Containers(nsCtx, sessionFilter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list debugger containers of namespace %q: %w", ns, err)
+		}
+		for _, cont := range conts {
+			_, status, err := c.services.TaskStatus(nsCtx, cont.ID)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to get task of container: %w", err)
+			}
+			sessions = append(sessions, exec.SessionFromLabels(ns+"/"+cont.ID, string(status), cont.Labels))
+		}
+	}
+	return sessions, nil
+}
+
+// StopSession kills the debugger task and removes its container.
+func (c *ContainerdClient) StopSession(ctx context.Context, id string) error {
+	ctx = c.withNamespace(ctx)
+	conts, err := c.services.Containers(ctx, fmt.Sprintf("id==%s,%s", id, sessionFilter))
+	if err != nil {
+		return fmt.Errorf("failed to look up debugger container: %w", err)
+	}
+	if len(conts) == 0 {
+		return fmt.Errorf("container %q is not a conxec debugger session of namespace %q", id, c.namespace)
+	}
+	if err := c.services.KillTask(ctx, id); err != nil {
+		return fmt.Errorf("failed to kill debugger task: %w", err)
+	}
+	// the attached conxec removes the container too once the task is gone
+	if err := c.services.DeleteContainer(ctx, id); err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to remove debugger container: %w", err)
+	}
+	return nil
+}
+
+// SessionLogs is not supported, containerd does not keep the output of the
+// tasks: it only goes to the fifos of the attached conxec.
+func (c *ContainerdClient) SessionLogs(ctx context.Context, id string, follow bool, stdout, stderr io.Writer) error {
+	return fmt.Errorf("containerd does not keep the output of the debugger, it is only shown by the attached conxec")
+}
@@ -0,0 +1,462 @@
+package cri
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+	"github.com/docker/cli/cli/streams"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+const (
+	defaultAddress = "/var/run/crio/crio.sock"
+
+	stopTimeoutSeconds = 10
+	exitTimeout        = 10 * time.Second
+)
+
+// streamFunc streams the IO of a container from the URL returned by the
+// Attach call of the CRI runtime.
+type streamFunc func(ctx context.Context, url string, streamOpts remotecommand.StreamOptions) error
+
+type CRIClient struct {
+	runtime runtimeapi.RuntimeServiceClient
+	image   runtimeapi.ImageServiceClient
+	out     *streams.Out
+	stream  streamFunc
+
+	sandboxID  string
+	targetCaps *runtimeapi.Capability
+	share      exec.Share
+}
+
+// NewClient connects to the CRI RuntimeService and ImageService served on
+// the runtime socket (CRI-O by default).
+func NewClient(ctx context.Context, opts *exec.ExecOptions, clistream *iocli.CliStream) (*CRIClient, error) {
+	if opts.ShareSet {
+		if err := opts.Share.Require(exec.Share{Net: true, IPC: true, UTS: true},
+			"cri:// debuggers are always in the network, IPC and UTS namespaces of the pod of the target"); err != nil {
+			return nil, err
+		}
+	}
+	address := opts.Runtime
+	if address == "" {
+		address = defaultAddress
+	}
+	address = strings.TrimPrefix(address, "unix://")
+
+	conn, err := grpc.DialContext(ctx, address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", addr)
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to CRI runtime: %w", err)
+	}
+
+	c := newClient(runtimeapi.NewRuntimeServiceClient(conn), runtimeapi.NewImageServiceClient(conn), clistream)
+	c.stream = streamURL
+	c.share = opts.Share
+	return c, nil
+}
+
+func newClient(runtime runtimeapi.RuntimeServiceClient, image runtimeapi.ImageServiceClient, clistream *iocli.CliStream) *CRIClient {
+	return &CRIClient{
+		runtime: runtime,
+		image:   image,
+		out:     clistream.AuxStream(),
+		share:   exec.DefaultShare,
+	}
+}
+
+// streamURL attaches to the streaming server of the runtime, the same way
+// the kubelet proxies pods/attach requests.
+func streamURL(ctx context.Context, rawURL string, streamOpts remotecommand.StreamOptions) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid streaming URL %q: %w", rawURL, err)
+	}
+	executor, err := remotecommand.NewSPDYExecutor(&rest.Config{}, "POST", u)
+	if err != nil {
+		return fmt.Errorf("failed to create SPDY executor: %w", err)
+	}
+	return executor.StreamWithContext(ctx, streamOpts)
+}
+
+// verboseInfo is the part of the verbose ContainerStatus info that both
+// CRI-O and containerd report.
+type verboseInfo struct {
+	Pid         int         `json:"pid"`
+	Privileged  bool        `json:"privileged"`
+	RuntimeSpec *specs.Spec `json:"runtimeSpec"`
+}
+
+// verboseSandboxInfo is the part of the verbose PodSandboxStatus info of
+// containerd giving the log directory of the pod.
+type verboseSandboxInfo struct {
+	Config struct {
+		LogDirectory string `json:"log_directory"`
+	} `json:"config"`
+}
+
+func (c *CRIClient) GetContainerInfo(ctx context.Context, container string) (*exec.ContainerInspectInfo, error) {
+	resp, err := c.runtime.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{
+		ContainerId: container,
+		Verbose:     true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to inspect container: %w", err)
+	}
+	status := resp.GetStatus()
+
+	list, err := c.runtime.ListContainers(ctx, &runtimeapi.ListContainersRequest{
+		Filter: &runtimeapi.ContainerFilter{Id: status.GetId()},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list containers: %w", err)
+	}
+	if len(list.GetContainers()) != 1 {
+		return nil, fmt.Errorf("Failed to find the pod sandbox of container %q", container)
+	}
+
+	var verbose verboseInfo
+	if raw, ok := resp.GetInfo()["info"]; ok {
+		if err := json.Unmarshal([]byte(raw), &verbose); err != nil {
+			return nil, fmt.Errorf("Failed to decode verbose container status: %w", err)
+		}
+	}
+
+	info := &exec.ContainerInspectInfo{
+		ID:           status.GetId(),
+		Name:         status.GetMetadata().GetName(),
+		Image:        status.GetImage().GetImage(),
+		Isrunning:    status.GetState() == runtimeapi.ContainerState_CONTAINER_RUNNING,
+		IsPrivileged: verbose.Privileged,
+		Pid:          verbose.Pid,
+	}
+	if spec := verbose.RuntimeSpec; spec != nil {
+		info.IsPidModeHost = !hasNamespace(spec, specs.PIDNamespace)
+		if spec.Process != nil {
+			info.User = spec.Process.User.Username
+			if info.User == "" {
+				info.User = strconv.FormatUint(uint64(spec.Process.User.UID), 10)
+			}
+			if caps := spec.Process.Capabilities; caps != nil {
+				c.targetCaps = &runtimeapi.Capability{AddCapabilities: trimCapPrefix(caps.Bounding)}
+			}
+		}
+	}
+	c.sandboxID = list.GetContainers()[0].GetPodSandboxId()
+	return info, nil
+}
+
+func (c *CRIClient) PullImage(ctx context.Context, image string, platform string) error {
+	status, err := c.image.ImageStatus(ctx, &runtimeapi.ImageStatusRequest{
+		Image: &runtimeapi.ImageSpec{Image: image},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get image status: %w", err)
+	}
+	if status.GetImage() != nil {
+		fmt.Fprintln(c.out, "Debugger image already present")
+		return nil
+	}
+	if _, err := c.image.PullImage(ctx, &runtimeapi.PullImageRequest{
+		Image: &runtimeapi.ImageSpec{Image: image},
+	}); err != nil {
+		return fmt.Errorf("failed to pull image: %w", err)
+	}
+	return nil
+}
+
+// ImageDigest returns the repo digest of the image, or its ID when it was not
+// pulled from a registry.
+func (c *CRIClient) ImageDigest(ctx context.Context, image string) (string, error) {
+	status, err := c.image.ImageStatus(ctx, &runtimeapi.ImageStatusRequest{
+		Image: &runtimeapi.ImageSpec{Image: image},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get image status: %w", err)
+	}
+	if status.GetImage() == nil {
+		return "", fmt.Errorf("image %q not found", image)
+	}
+	if digests := status.GetImage().GetRepoDigests(); len(digests) > 0 {
+		return digests[0], nil
+	}
+	return status.GetImage().GetId(), nil
+}
+
+func (c *CRIClient) CreateContainer(ctx context.Context, targetInspect *exec.ContainerInspectInfo,
+	image, entrypoint, user, containerName string, labels map[string]string,
+	tty, stdin bool, mountDir string,
+) (string, error) {
+	sandbox, err := c.runtime.PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{
+		PodSandboxId: c.sandboxID,
+		Verbose:      true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get pod sandbox status: %w", err)
+	}
+
+	var mounts []*runtimeapi.Mount
+	if mountDir != "" {
+		absMountDir, err := filepath.Abs(mountDir)
+		if err != nil {
+			return "", fmt.Errorf("failed to convert %s to absolute path: %w", mountDir, err)
+		}
+		mounts = append(mounts, &runtimeapi.Mount{ContainerPath: "/work", HostPath: absMountDir})
+	}
+
+	// share the PID namespace of the target, the network, IPC and UTS ones
+	// are always the pod's
+	namespaceOptions := &runtimeapi.NamespaceOption{
+		Network:  runtimeapi.NamespaceMode_POD,
+		Pid:      runtimeapi.NamespaceMode_TARGET,
+		Ipc:      runtimeapi.NamespaceMode_POD,
+		TargetId: targetInspect.ID,
+	}
+	if !c.share.Pid {
+		namespaceOptions.Pid = runtimeapi.NamespaceMode_CONTAINER
+		namespaceOptions.TargetId = ""
+	} else if targetInspect.IsPidModeHost {
+		namespaceOptions.Pid = runtimeapi.NamespaceMode_NODE
+		namespaceOptions.TargetId = ""
+	}
+	securityContext := &runtimeapi.LinuxContainerSecurityContext{
+		Privileged:       targetInspect.IsPrivileged,
+		Capabilities:     c.targetCaps,
+		NamespaceOptions: namespaceOptions,
+	}
+	name := strings.Split(user, ":")[0]
+	if uid, err := strconv.ParseInt(name, 10, 64); err == nil {
+		securityContext.RunAsUser = &runtimeapi.Int64Value{Value: uid}
+	} else if name == "root" {
+		securityContext.RunAsUser = &runtimeapi.Int64Value{Value: 0}
+	} else {
+		securityContext.RunAsUsername = name
+	}
+
+	resp, err := c.runtime.CreateContainer(ctx, &runtimeapi.CreateContainerRequest{
+		PodSandboxId: c.sandboxID,
+		Config: &runtimeapi.ContainerConfig{
+			Metadata:  &runtimeapi.ContainerMetadata{Name: containerName},
+			Image:     &runtimeapi.ImageSpec{Image: image},
+			Command:   []string{"sh"},
+			Args:      []string{"-c", entrypoint},
+			Stdin:     stdin,
+			StdinOnce: stdin,
+			Tty:       tty,
+			Mounts:    mounts,
+			Labels:    labels,
+			// relative to the log directory of the pod, the runtime keeps no
+			// logs without it
+			LogPath: containerName + ".log",
+			Linux:   &runtimeapi.LinuxContainerConfig{SecurityContext: securityContext},
+		},
+		SandboxConfig: sandboxConfig(sandbox),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create container: %w", err)
+	}
+	return resp.GetContainerId(), nil
+}
+
+// sandboxConfig rebuilds the config of a running pod sandbox, CreateContainer
+// requires it to be passed along the container config.
+func sandboxConfig(sandbox *runtimeapi.PodSandboxStatusResponse) *runtimeapi.PodSandboxConfig {
+	status := sandbox.GetStatus()
+	config := &runtimeapi.PodSandboxConfig{
+		Metadata:     status.GetMetadata(),
+		Labels:       status.GetLabels(),
+		Annotations:  status.GetAnnotations(),
+		LogDirectory: sandboxLogDirectory(sandbox),
+	}
+	if ns := status.GetLinux().GetNamespaces(); ns != nil {
+		config.Linux = &runtimeapi.LinuxPodSandboxConfig{
+			SecurityContext: &runtimeapi.LinuxSandboxSecurityContext{NamespaceOptions: ns.GetOptions()},
+		}
+	}
+	return config
+}
+
+// sandboxLogDirectory returns the log directory of the pod sandbox, which the
+// status only has in the verbose info of containerd. It is the one the
+// kubelet gives to the pods otherwise.
+func sandboxLogDirectory(sandbox *runtimeapi.PodSandboxStatusResponse) string {
+	var verbose verboseSandboxInfo
+	if raw, ok := sandbox.GetInfo()["info"]; ok && json.Unmarshal([]byte(raw), &verbose) == nil && verbose.Config.LogDirectory != "" {
+		return verbose.Config.LogDirectory
+	}
+	metadata := sandbox.GetStatus().GetMetadata()
+	if metadata.GetUid() == "" {
+		return ""
+	}
+	return filepath.Join("/var/log/pods", metadata.GetNamespace()+"_"+metadata.GetName()+"_"+metadata.GetUid())
+}
+
+func (c *CRIClient) AttachContainer(ctx context.Context, containerID string, tty, stdin bool, cliStream *iocli.CliStream) error {
+	// remove the debugger container when it exits, like docker's AutoRemove
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), (stopTimeoutSeconds+5)*time.Second)
+		defer cancel()
+		if _, err := c.runtime.StopContainer(ctx, &runtimeapi.StopContainerRequest{
+			ContainerId: containerID, Timeout: stopTimeoutSeconds,
+		}); err != nil {
+			fmt.Fprintf(c.out, "failed to stop debugger container: %s\n", err)
+		}
+		if _, err := c.runtime.RemoveContainer(ctx, &runtimeapi.RemoveContainerRequest{ContainerId: containerID}); err != nil {
+			fmt.Fprintf(c.out, "failed to remove debugger container: %s\n", err)
+		}
+	}()
+
+	if _, err := c.runtime.StartContainer(ctx, &runtimeapi.StartContainerRequest{ContainerId: containerID}); err != nil {
+		return fmt.Errorf("cannot start debugger container: %w", err)
+	}
+
+	// the runtimes only attach to running containers, the output written
+	// before is only in the log file. Without input the log file is followed
+	// instead, it has all of the output.
+	if !stdin {
+		resp, err := c.runtime.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: containerID})
+		if err == nil && resp.GetStatus().GetLogPath() != "" {
+			if err := c.printLogs(ctx, containerID, true, cliStream); err != nil {
+				return err
+			}
+			return c.exitStatus(ctx, containerID)
+		}
+	}
+
+	resp, err := c.runtime.Attach(ctx, &runtimeapi.AttachRequest{
+		ContainerId: containerID,
+		Stdin:       stdin,
+		Tty:         tty,
+		Stdout:      true,
+		Stderr:      !tty,
+	})
+	if err != nil {
+		// too short lived to be attached, its output is in the logs
+		if c.exited(ctx, containerID) {
+			if err := c.printLogs(ctx, containerID, false, cliStream); err != nil {
+				return err
+			}
+			return c.exitStatus(ctx, containerID)
+		}
+		return fmt.Errorf("failed to attach container: %w", err)
+	}
+
+	recorder := cliStream.Recorder()
+	streamOpts := remotecommand.StreamOptions{
+		Stdout: recorder.TeeOutput(cliStream.OutputStream()),
+		Tty:    tty,
+	}
+	if stdin {
+		streamOpts.Stdin = recorder.TeeInput(cliStream.InputStream())
+	}
+	if !tty {
+		streamOpts.Stderr = recorder.TeeOutput(cliStream.ErrorStream())
+	}
+	if tty {
+		if err := cliStream.InputStream().SetRawTerminal(); err != nil {
+			return fmt.Errorf("failed to set raw terminal: %w", err)
+		}
+		defer cliStream.InputStream().RestoreTerminal()
+
+		if cliStream.OutputStream().IsTerminal() {
+			// the resizing ends with the stream
+			resizeCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			sizeQueue := iocli.NewTerminalSizeQueue()
+			defer sizeQueue.Close()
+			streamOpts.TerminalSizeQueue = sizeQueue
+			iocli.StartResizing(resizeCtx, cliStream, sizeQueue.Resize)
+		}
+	}
+
+	if err := c.stream(ctx, resp.GetUrl(), streamOpts); err != nil {
+		return fmt.Errorf("failed to stream debugger container: %w", err)
+	}
+	return c.exitStatus(ctx, containerID)
+}
+
+// printLogs writes the output of the debugger from the log file of the
+// runtime, until the debugger exits with follow.
+func (c *CRIClient) printLogs(ctx context.Context, containerID string, follow bool, cliStream *iocli.CliStream) error {
+	recorder := cliStream.Recorder()
+	return c.SessionLogs(ctx, containerID, follow,
+		recorder.TeeOutput(cliStream.OutputStream()), recorder.TeeOutput(cliStream.ErrorStream()))
+}
+
+// exited tells whether the container exited.
+func (c *CRIClient) exited(ctx context.Context, containerID string) bool {
+	resp, err := c.runtime.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: containerID})
+	return err == nil && resp.GetStatus().GetState() == runtimeapi.ContainerState_CONTAINER_EXITED
+}
+
+// exitStatus waits for the debugger to exit and returns its exit status.
+func (c *CRIClient) exitStatus(ctx context.Context, containerID string) error {
+	exitCode, err := c.waitForExit(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	return exec.ExitStatus(exitCode)
+}
+
+// waitForExit returns the exit code of the container, the stream ends when
+// the debugger exits but the runtime may report it a bit later.
+func (c *CRIClient) waitForExit(ctx context.Context, containerID string) (int, error) {
+	var exitCode int
+	err := wait.PollUntilContextTimeout(ctx, 100*time.Millisecond, exitTimeout, true,
+		func(ctx context.Context) (bool, error) {
+			resp, err := c.runtime.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: containerID})
+			if err != nil {
+				return false, fmt.Errorf("failed to get debugger container status: %w", err)
+			}
+			if resp.GetStatus().GetState() != runtimeapi.ContainerState_CONTAINER_EXITED {
+				return false, nil
+			}
+			exitCode = int(resp.GetStatus().GetExitCode())
+			return true, nil
+		})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the exit code of the debugger: %w", err)
+	}
+	return exitCode, nil
+}
+
+func hasNamespace(spec *specs.Spec, nsType specs.LinuxNamespaceType) bool {
+	if spec.Linux == nil {
+		return false
+	}
+	for _, ns := range spec.Linux.Namespaces {
+		if ns.Type == nsType {
+			return true
+		}
+	}
+	return false
+}
+
+// trimCapPrefix converts OCI capability names (CAP_NET_RAW) to the CRI ones (NET_RAW).
+func trimCapPrefix(caps []string) []string {
+	trimmed := make([]string, 0, len(caps))
+	for _, c := range caps {
+		trimmed = append(trimmed, strings.TrimPrefix(c, "CAP_"))
+	}
+	return trimmed
+}
@@ -0,0 +1,509 @@
+package cri
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"k8s.io/client-go/tools/remotecommand"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// fakeRuntime is an in-process CRI server keeping containers in memory.
+type fakeRuntime struct {
+	runtimeapi.UnimplementedRuntimeServiceServer
+	runtimeapi.UnimplementedImageServiceServer
+
+	mu         sync.Mutex
+	containers map[string]*runtimeapi.Container
+	configs    map[string]*runtimeapi.ContainerConfig
+	images     map[string]bool
+	pids       map[string]int
+	exitCodes  map[string]int32
+	logPaths   map[string]string
+
+	// logDir is the log directory of the pod sandbox, reported in its verbose
+	// status as containerd does
+	logDir string
+	// onStart runs the process of a started container
+	onStart func(id string)
+}
+
+func newFakeRuntime() *fakeRuntime {
+	return &fakeRuntime{
+		containers: map[string]*runtimeapi.Container{},
+		configs:    map[string]*runtimeapi.ContainerConfig{},
+		images:     map[string]bool{},
+		pids:       map[string]int{},
+		exitCodes:  map[string]int32{},
+		logPaths:   map[string]string{},
+	}
+}
+
+func (r *fakeRuntime) addContainer(id, sandboxID string, pid int) {
+	r.containers[id] = &runtimeapi.Container{
+		Id:           id,
+		PodSandboxId: sandboxID,
+		State:        runtimeapi.ContainerState_CONTAINER_RUNNING,
+	}
+	r.pids[id] = pid
+}
+
+func (r *fakeRuntime) ContainerStatus(ctx context.Context, req *runtimeapi.ContainerStatusRequest) (*runtimeapi.ContainerStatusResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.containers[req.GetContainerId()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "container %q not found", req.GetContainerId())
+	}
+	info, err := json.Marshal(verboseInfo{
+		Pid: r.pids[c.Id],
+		RuntimeSpec: &specs.Spec{
+			Process: &specs.Process{
+				User:         specs.User{UID: 65532},
+				Capabilities: &specs.LinuxCapabilities{Bounding: []string{"CAP_NET_RAW"}},
+			},
+			Linux: &specs.Linux{Namespaces: []specs.LinuxNamespace{{Type: specs.PIDNamespace}}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &runtimeapi.ContainerStatusResponse{
+		Status: &runtimeapi.ContainerStatus{
+			Id:       c.Id,
+			State:    c.State,
+			ExitCode: r.exitCodes[c.Id],
+			Labels:   c.Labels,
+			LogPath:  r.logPaths[c.Id],
+		},
+		Info: map[string]string{"info": string(info)},
+	}, nil
+}
+
+func (r *fakeRuntime) ListContainers(ctx context.Context, req *runtimeapi.ListContainersRequest) (*runtimeapi.ListContainersResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	resp := &runtimeapi.ListContainersResponse{}
+	for id, c := range r.containers {
+		if filter := req.GetFilter(); filter != nil && filter.GetId() != "" && filter.GetId() != id {
+			continue
+		}
+		if !matchLabels(c.GetLabels(), req.GetFilter().GetLabelSelector()) {
+			continue
+		}
+		resp.Containers = append(resp.Containers, c)
+	}
+	return resp, nil
+}
+
+func matchLabels(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *fakeRuntime) PodSandboxStatus(ctx context.Context, req *runtimeapi.PodSandboxStatusRequest) (*runtimeapi.PodSandboxStatusResponse, error) {
+	resp := &runtimeapi.PodSandboxStatusResponse{
+		Status: &runtimeapi.PodSandboxStatus{
+			Id:       req.GetPodSandboxId(),
+			Metadata: &runtimeapi.PodSandboxMetadata{Name: "web", Namespace: "shop"},
+		},
+	}
+	if r.logDir != "" && req.GetVerbose() {
+		resp.Info = map[string]string{"info": fmt.Sprintf(`{"config":{"log_directory":%q}}`, r.logDir)}
+	}
+	return resp, nil
+}
+
+func (r *fakeRuntime) CreateContainer(ctx context.Context, req *runtimeapi.CreateContainerRequest) (*runtimeapi.CreateContainerResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.images[req.GetConfig().GetImage().GetImage()] {
+		return nil, status.Errorf(codes.NotFound, "image not found")
+	}
+	if req.GetSandboxConfig().GetMetadata().GetName() != "web" {
+		return nil, status.Errorf(codes.InvalidArgument, "missing sandbox config")
+	}
+	id := fmt.Sprintf("dbg-%d", len(r.containers))
+	r.containers[id] = &runtimeapi.Container{
+		Id:           id,
+		PodSandboxId: req.GetPodSandboxId(),
+		State:        runtimeapi.ContainerState_CONTAINER_CREATED,
+		Labels:       req.GetConfig().GetLabels(),
+	}
+	r.configs[id] = req.GetConfig()
+	// the runtime keeps the logs when the container has a log path in the log
+	// directory of the pod
+	if dir, path := req.GetSandboxConfig().GetLogDirectory(), req.GetConfig().GetLogPath(); dir != "" && path != "" {
+		r.logPaths[id] = filepath.Join(dir, path)
+	}
+	return &runtimeapi.CreateContainerResponse{ContainerId: id}, nil
+}
+
+func (r *fakeRuntime) StartContainer(ctx context.Context, req *runtimeapi.StartContainerRequest) (*runtimeapi.StartContainerResponse, error) {
+	r.mu.Lock()
+	r.containers[req.GetContainerId()].State = runtimeapi.ContainerState_CONTAINER_RUNNING
+	r.mu.Unlock()
+	if r.onStart != nil {
+		r.onStart(req.GetContainerId())
+	}
+	return &runtimeapi.StartContainerResponse{}, nil
+}
+
+func (r *fakeRuntime) Attach(ctx context.Context, req *runtimeapi.AttachRequest) (*runtimeapi.AttachResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.containers[req.GetContainerId()].GetState() != runtimeapi.ContainerState_CONTAINER_RUNNING {
+		return nil, status.Errorf(codes.FailedPrecondition, "container is not running")
+	}
+	return &runtimeapi.AttachResponse{Url: "http://127.0.0.1:10010/attach/" + req.GetContainerId()}, nil
+}
+
+// exit makes the container exit with the code, as its process would.
+func (r *fakeRuntime) exit(id string, code int32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.containers[id].State = runtimeapi.ContainerState_CONTAINER_EXITED
+	r.exitCodes[id] = code
+}
+
+func (r *fakeRuntime) StopContainer(ctx context.Context, req *runtimeapi.StopContainerRequest) (*runtimeapi.StopContainerResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.containers[req.GetContainerId()].State = runtimeapi.ContainerState_CONTAINER_EXITED
+	return &runtimeapi.StopContainerResponse{}, nil
+}
+
+func (r *fakeRuntime) RemoveContainer(ctx context.Context, req *runtimeapi.RemoveContainerRequest) (*runtimeapi.RemoveContainerResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.containers, req.GetContainerId())
+	return &runtimeapi.RemoveContainerResponse{}, nil
+}
+
+func (r *fakeRuntime) ImageStatus(ctx context.Context, req *runtimeapi.ImageStatusRequest) (*runtimeapi.ImageStatusResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.images[req.GetImage().GetImage()] {
+		return &runtimeapi.ImageStatusResponse{}, nil
+	}
+	return &runtimeapi.ImageStatusResponse{Image: &runtimeapi.Image{Id: req.GetImage().GetImage()}}, nil
+}
+
+func (r *fakeRuntime) PullImage(ctx context.Context, req *runtimeapi.PullImageRequest) (*runtimeapi.PullImageResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.images[req.GetImage().GetImage()] = true
+	return &runtimeapi.PullImageResponse{ImageRef: req.GetImage().GetImage()}, nil
+}
+
+// startFakeRuntime serves the fake runtime over an in-memory connection and
+// returns a client connected to it.
+func startFakeRuntime(t *testing.T, runtime *fakeRuntime, cliStream *iocli.CliStream) *CRIClient {
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	runtimeapi.RegisterRuntimeServiceServer(server, runtime)
+	runtimeapi.RegisterImageServiceServer(server, runtime)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return newClient(runtimeapi.NewRuntimeServiceClient(conn), runtimeapi.NewImageServiceClient(conn), cliStream)
+}
+
+func newTestStream() *iocli.CliStream {
+	return iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard)
+}
+
+func TestGetContainerInfo(t *testing.T) {
+	runtime := newFakeRuntime()
+	runtime.addContainer("app", "sandbox-1", 4242)
+	c := startFakeRuntime(t, runtime, newTestStream())
+
+	info, err := c.GetContainerInfo(context.Background(), "app")
+	if err != nil {
+		t.Fatalf("GetContainerInfo() error = %v", err)
+	}
+	want := exec.ContainerInspectInfo{ID: "app", Isrunning: true, Pid: 4242, User: "65532"}
+	if *info != want {
+		t.Errorf("GetContainerInfo() = %+v, want %+v", *info, want)
+	}
+	if c.sandboxID != "sandbox-1" {
+		t.Errorf("sandbox = %q, want %q", c.sandboxID, "sandbox-1")
+	}
+
+	if _, err := c.GetContainerInfo(context.Background(), "missing"); status.Code(err) != codes.NotFound {
+		t.Errorf("GetContainerInfo() error = %v, want not found", err)
+	}
+}
+
+func TestRunDebugger(t *testing.T) {
+	runtime := newFakeRuntime()
+	runtime.addContainer("app", "sandbox-1", 4242)
+	cliStream := newTestStream()
+	c := startFakeRuntime(t, runtime, cliStream)
+
+	var streamedURL string
+	c.stream = func(ctx context.Context, url string, streamOpts remotecommand.StreamOptions) error {
+		streamedURL = url
+		runtime.exit(path.Base(url), 0)
+		return nil
+	}
+
+	opts, err := exec.New([]exec.Option{
+		exec.WithTarget("app"),
+		exec.WithCommand([]string{"ls"}),
+		exec.WithDebuggerImage(""),
+		exec.WithUser("root:0::root:0"),
+		exec.WithName("conxec-debugger-test"),
+		exec.WithTty(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.RunDebugger(context.Background(), c, opts, cliStream); err != nil {
+		t.Fatalf("RunDebugger() error = %v", err)
+	}
+
+	if !runtime.images[opts.DbgImg] {
+		t.Errorf("debugger image %q was not pulled", opts.DbgImg)
+	}
+	if len(runtime.configs) != 1 {
+		t.Fatalf("created containers = %d, want 1", len(runtime.configs))
+	}
+	for id, config := range runtime.configs {
+		if _, ok := runtime.containers[id]; ok {
+			t.Errorf("debugger container %q was not removed", id)
+		}
+		if streamedURL != "http://127.0.0.1:10010/attach/"+id {
+			t.Errorf("streamed URL = %q", streamedURL)
+		}
+		ns := config.GetLinux().GetSecurityContext().GetNamespaceOptions()
+		if ns.GetPid() != runtimeapi.NamespaceMode_TARGET || ns.GetTargetId() != "app" {
+			t.Errorf("namespace options = %v, want the target PID namespace", ns)
+		}
+		if got := config.GetLinux().GetSecurityContext().GetCapabilities().GetAddCapabilities(); len(got) != 1 || got[0] != "NET_RAW" {
+			t.Errorf("capabilities = %q, want the target ones", got)
+		}
+		if !config.GetTty() || config.GetMetadata().GetName() != "conxec-debugger-test" {
+			t.Errorf("container config = %v", config)
+		}
+	}
+}
+
+func TestRunDebuggerExitStatus(t *testing.T) {
+	runtime := newFakeRuntime()
+	runtime.addContainer("app", "sandbox-1", 4242)
+	cliStream := newTestStream()
+	c := startFakeRuntime(t, runtime, cliStream)
+	c.stream = func(ctx context.Context, url string, streamOpts remotecommand.StreamOptions) error {
+		runtime.exit(path.Base(url), 42)
+		return nil
+	}
+
+	opts, err := exec.New([]exec.Option{
+		exec.WithTarget("app"),
+		exec.WithCommand([]string{"false"}),
+		exec.WithDebuggerImage(""),
+		exec.WithUser("root:0::root:0"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = exec.RunDebugger(context.Background(), c, opts, cliStream)
+	var statusErr iocli.StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code() != 42 {
+		t.Errorf("RunDebugger() error = %v, want exit status 42", err)
+	}
+}
+
+func TestRunDebuggerShortLived(t *testing.T) {
+	for _, stdin := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stdin=%t", stdin), func(t *testing.T) {
+			runtime := newFakeRuntime()
+			runtime.addContainer("app", "sandbox-1", 4242)
+			runtime.logDir = t.TempDir()
+			// the debugger exits before it can be attached
+			runtime.onStart = func(id string) {
+				logs := "2023-11-02T10:00:00.000000000Z stdout F hello\n" +
+					"2023-11-02T10:00:00.000000001Z stderr F oops\n"
+				if err := os.WriteFile(runtime.logPaths[id], []byte(logs), 0o644); err != nil {
+					t.Error(err)
+				}
+				runtime.exit(id, 3)
+			}
+			var stdout, stderr bytes.Buffer
+			cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), &stdout, &stderr)
+			c := startFakeRuntime(t, runtime, cliStream)
+			c.stream = func(ctx context.Context, url string, streamOpts remotecommand.StreamOptions) error {
+				t.Errorf("streamed the exited debugger")
+				return nil
+			}
+
+			opts, err := exec.New([]exec.Option{
+				exec.WithTarget("app"),
+				exec.WithCommand([]string{"echo", "hello"}),
+				exec.WithDebuggerImage(""),
+				exec.WithUser("root:0::root:0"),
+				exec.WithStdin(stdin),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = exec.RunDebugger(context.Background(), c, opts, cliStream)
+			var statusErr iocli.StatusError
+			if !errors.As(err, &statusErr) || statusErr.Code() != 3 {
+				t.Errorf("RunDebugger() error = %v, want exit status 3", err)
+			}
+			if stdout.String() != "hello\n" || !strings.HasSuffix(stderr.String(), "\noops\n") {
+				t.Errorf("RunDebugger() stdout = %q, stderr = %q", stdout.String(), stderr.String())
+			}
+		})
+	}
+}
+
+func TestSessions(t *testing.T) {
+	runtime := newFakeRuntime()
+	runtime.addContainer("app", "sandbox-1", 4242)
+	runtime.images["busybox"] = true
+	c := startFakeRuntime(t, runtime, newTestStream())
+	if _, err := c.GetContainerInfo(context.Background(), "app"); err != nil {
+		t.Fatal(err)
+	}
+	labels := exec.SessionLabels("1a2b3c4d", "app", []string{"sh"}, "root:root", time.Now())
+	id, err := c.CreateContainer(context.Background(), &exec.ContainerInspectInfo{ID: "app"},
+		"busybox", "sh", "root:root", "conxec-debugger-1a2b3c4d", labels, false, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logPath := filepath.Join(t.TempDir(), "0.log")
+	runtime.logPaths[id] = logPath
+	logs := "2023-11-02T10:00:00.000000000Z stdout P hel\n" +
+		"2023-11-02T10:00:00.000000001Z stdout F lo\n" +
+		"2023-11-02T10:00:00.000000002Z stderr F oops\n"
+	if err := os.WriteFile(logPath, []byte(logs), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := c.ListSessions(context.Background())
+	if err != nil {
+		t.Fatalf("ListSessions() error = %v", err)
+	}
+	// the debuggers are told by their session label only
+	if len(sessions) != 1 || sessions[0].ID != id || sessions[0].Target != "app" || sessions[0].Status != "created" {
+		t.Fatalf("ListSessions() = %+v, want the debugger session", sessions)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := c.SessionLogs(context.Background(), id, false, &stdout, &stderr); err != nil {
+		t.Fatalf("SessionLogs() error = %v", err)
+	}
+	if stdout.String() != "hello\n" || stderr.String() != "oops\n" {
+		t.Errorf("SessionLogs() stdout = %q, stderr = %q", stdout.String(), stderr.String())
+	}
+
+	if err := c.StopSession(context.Background(), "app"); err == nil {
+		t.Errorf("StopSession() of a container which is not a debugger succeeded")
+	}
+	if err := c.StopSession(context.Background(), id); err != nil {
+		t.Fatalf("StopSession() error = %v", err)
+	}
+	if _, ok := runtime.containers[id]; ok {
+		t.Errorf("debugger container was not removed")
+	}
+}
+
+func TestNewClientShare(t *testing.T) {
+	tests := []struct {
+		share   string
+		wantErr string
+	}{
+		{share: ""},
+		{share: "net,pid,ipc,uts,mnt"},
+		{share: "net,ipc,uts"},
+		{share: "pid,mnt", wantErr: "add net,ipc,uts to --share"},
+		{share: "net,pid,mnt", wantErr: "add ipc,uts to --share"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.share, func(t *testing.T) {
+			opts, err := exec.New([]exec.Option{exec.WithShare(tt.share), exec.WithRuntime(filepath.Join(t.TempDir(), "crio.sock"))})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = NewClient(context.Background(), opts, newTestStream())
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("NewClient() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSandboxLogDirectory(t *testing.T) {
+	metadata := &runtimeapi.PodSandboxMetadata{Name: "web", Namespace: "shop", Uid: "1234-5678"}
+	tests := []struct {
+		name    string
+		sandbox *runtimeapi.PodSandboxStatusResponse
+		want    string
+	}{
+		{
+			name: "containerd",
+			sandbox: &runtimeapi.PodSandboxStatusResponse{
+				Status: &runtimeapi.PodSandboxStatus{Metadata: metadata},
+				Info:   map[string]string{"info": `{"config":{"log_directory":"/var/log/pods/custom"}}`},
+			},
+			want: "/var/log/pods/custom",
+		},
+		{
+			name:    "kubelet pod",
+			sandbox: &runtimeapi.PodSandboxStatusResponse{Status: &runtimeapi.PodSandboxStatus{Metadata: metadata}},
+			want:    "/var/log/pods/shop_web_1234-5678",
+		},
+		{
+			name: "no pod uid",
+			sandbox: &runtimeapi.PodSandboxStatusResponse{Status: &runtimeapi.PodSandboxStatus{
+				Metadata: &runtimeapi.PodSandboxMetadata{Name: "web", Namespace: "shop"},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sandboxLogDirectory(tt.sandbox); got != tt.want {
+				t.Errorf("sandboxLogDirectory() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
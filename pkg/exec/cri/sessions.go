@@ -0,0 +1,140 @@
+package cri
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+const logPollInterval = 500 * time.Millisecond
+
+// ListSessions returns the debugger containers, including the exited ones
+// which are not removed yet. The label selectors of CRI only match values,
+// the debuggers are told by their session label.
+func (c *CRIClient) ListSessions(ctx context.Context) ([]exec.Session, error) {
+	resp, err := c.runtime.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list debugger containers: %w", err)
+	}
+	sessions := []exec.Session{}
+	for _, cont := range resp.GetContainers() {
+		if _, ok := cont.GetLabels()[exec.LabelSession]; !ok {
+			continue
+		}
+		sessions = append(sessions, exec.SessionFromLabels(cont.GetId(), stateName(cont.GetState()), cont.GetLabels()))
+	}
+	return sessions, nil
+}
+
+// stateName converts CONTAINER_RUNNING to running.
+func stateName(state runtimeapi.ContainerState) string {
+	return strings.ToLower(strings.TrimPrefix(state.String(), "CONTAINER_"))
+}
+
+// StopSession stops the debugger container and removes it.
+func (c *CRIClient) StopSession(ctx context.Context, id string) error {
+	if _, err := c.sessionStatus(ctx, id); err != nil {
+		return err
+	}
+	if _, err := c.runtime.StopContainer(ctx, &runtimeapi.StopContainerRequest{
+		ContainerId: id, Timeout: stopTimeoutSeconds,
+	}); err != nil {
+		return fmt.Errorf("failed to stop debugger container: %w", err)
+	}
+	if _, err := c.runtime.RemoveContainer(ctx, &runtimeapi.RemoveContainerRequest{ContainerId: id}); err != nil {
+		return fmt.Errorf("failed to remove debugger container: %w", err)
+	}
+	return nil
+}
+
+// SessionLogs writes the output of the debugger from the log file kept by
+// the runtime, which needs conxec to run on the node.
+func (c *CRIClient) SessionLogs(ctx context.Context, id string, follow bool, stdout, stderr io.Writer) error {
+	status, err := c.sessionStatus(ctx, id)
+	if err != nil {
+		return err
+	}
+	if status.GetLogPath() == "" {
+		return fmt.Errorf("the runtime keeps no log of debugger container %q", id)
+	}
+	f, err := os.Open(status.GetLogPath())
+	if err != nil {
+		return fmt.Errorf("failed to open debugger logs: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if err == nil {
+			writeLogLine(line, stdout, stderr)
+			continue
+		}
+		if !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read debugger logs: %w", err)
+		}
+		if !follow {
+			writeLogLine(line, stdout, stderr)
+			return nil
+		}
+		// an incomplete line is read again once the runtime wrote it all
+		if _, err := f.Seek(-int64(len(line)), io.SeekCurrent); err != nil {
+			return fmt.Errorf("failed to read debugger logs: %w", err)
+		}
+		r.Reset(f)
+		if resp, err := c.runtime.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: id}); err != nil ||
+			resp.GetStatus().GetState() == runtimeapi.ContainerState_CONTAINER_EXITED {
+			// the last lines are written before the exit is reported
+			follow = false
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(logPollInterval):
+		}
+	}
+}
+
+// writeLogLine writes the content of a line of the CRI log format:
+// "<timestamp> <stdout|stderr> <P|F> <content>", partial (P) lines are
+// continued by the next one.
+func writeLogLine(line string, stdout, stderr io.Writer) {
+	fields := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 4)
+	if len(fields) < 3 {
+		return
+	}
+	var content string
+	if len(fields) == 4 {
+		content = fields[3]
+	}
+	if fields[2] != "P" {
+		content += "\n"
+	}
+	out := stdout
+	if fields[1] == "stderr" {
+		out = stderr
+	}
+	io.WriteString(out, content)
+}
+
+// sessionStatus returns the status of the container and checks it is a
+// debugger, so the sessions commands can't be used on other containers.
+func (c *CRIClient) sessionStatus(ctx context.Context, id string) (*runtimeapi.ContainerStatus, error) {
+	resp, err := c.runtime.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get debugger container status: %w", err)
+	}
+	if _, ok := resp.GetStatus().GetLabels()[exec.LabelSession]; !ok {
+		return nil, fmt.Errorf("container %q is not a conxec debugger session", id)
+	}
+	return resp.GetStatus(), nil
+}
@@ -1,11 +1,17 @@
 package docker
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/debasishbsws/conxec/pkg/exec"
 	"github.com/debasishbsws/conxec/pkg/iocli"
@@ -22,24 +28,50 @@ type DockerClient struct {
 	client        *client.Client
 	out           *streams.Out
 	targetInspect *types.ContainerJSON
+	detachKeys    []byte
+	controlKeys   []byte
+	share         exec.Share
 }
 
 func NewClient(ctx context.Context, opts *exec.ExecOptions, clistream *iocli.CliStream) (*DockerClient, error) {
-	dockerOpts := []client.Opt{
-		client.FromEnv,
-		client.WithAPIVersionNegotiation(),
+	if opts.Share.UTS {
+		return nil, fmt.Errorf("docker can't share the uts namespace of a container, only the one of the host")
 	}
-	if opts.Runtime != "" {
-		dockerOpts = append(dockerOpts, client.WithHost(opts.Runtime))
+	contextName, err := resolveContextName(opts.DockerContext, opts.Runtime)
+	if err != nil {
+		return nil, err
+	}
+
+	var dockerOpts []client.Opt
+	var endpoint string
+	if contextName == defaultContextName {
+		// $DOCKER_HOST, $DOCKER_TLS_VERIFY and $DOCKER_CERT_PATH
+		endpoint = opts.Runtime
+		if endpoint == "" {
+			endpoint = os.Getenv(client.EnvOverrideHost)
+		}
+		dockerOpts, err = hostClientOpts(endpoint)
+	} else {
+		dockerOpts, endpoint, err = contextClientOpts(contextName)
+	}
+	if err != nil {
+		return nil, err
 	}
 	dockerClient, err := client.NewClientWithOpts(dockerOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create docker client: %w", err)
 	}
+	if endpoint == "" {
+		endpoint = dockerClient.DaemonHost()
+	}
+	clistream.PrintAux("Using docker endpoint %q (context %q)\n", endpoint, contextName)
 
 	return &DockerClient{
-		client: dockerClient,
-		out:    clistream.AuxStream(),
+		client:      dockerClient,
+		out:         clistream.AuxStream(),
+		detachKeys:  opts.DetachKeys,
+		controlKeys: opts.ControlKeys,
+		share:       opts.Share,
 	}, nil
 }
 
@@ -50,7 +82,9 @@ func (c *DockerClient) GetContainerInfo(ctx context.Context, container string) (
 	}
 	info := &exec.ContainerInspectInfo{
 		ID:            conInspect.ID,
-		Isrunning:     conInspect.State.Running,
+		Name:          strings.TrimPrefix(conInspect.Name, "/"),
+		Image:         conInspect.Config.Image,
+		Isrunning:     conInspect.State.Running && !conInspect.State.Restarting,
 		IsPrivileged:  conInspect.HostConfig.Privileged,
 		IsPidModeHost: conInspect.HostConfig.PidMode.IsHost(),
 		Pid:           conInspect.State.Pid,
@@ -78,8 +112,21 @@ func (c *DockerClient) PullImage(ctx context.Context, image string, platform str
 	return jsonmessage.DisplayJSONMessagesToStream(resp, c.out, nil)
 }
 
+// ImageDigest returns the repo digest of the image, or its ID when it was not
+// pulled from a registry.
+func (c *DockerClient) ImageDigest(ctx context.Context, image string) (string, error) {
+	inspect, _, err := c.client.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image: %w", err)
+	}
+	if len(inspect.RepoDigests) > 0 {
+		return inspect.RepoDigests[0], nil
+	}
+	return inspect.ID, nil
+}
+
 func (c *DockerClient) CreateContainer(ctx context.Context, targetInspect *exec.ContainerInspectInfo,
-	image, entrypoint, user, containerName string,
+	image, entrypoint, user, containerName string, labels map[string]string,
 	tty, stdin bool, mountDir string,
 ) (string, error) {
 	var bindMount []string
@@ -91,6 +138,30 @@ func (c *DockerClient) CreateContainer(ctx context.Context, targetInspect *exec.
 		bindMount = []string{absMountDir + ":/work"}
 	}
 
+	hostConfig := &container.HostConfig{
+		Privileged: targetInspect.IsPrivileged,
+		CapAdd:     c.targetInspect.HostConfig.CapAdd,
+		CapDrop:    c.targetInspect.HostConfig.CapDrop,
+
+		AutoRemove: true, // remove the container when it exits TODO: make it configurable '--rm' flag
+		Binds:      bindMount,
+	}
+	networkingConfig := &network.NetworkingConfig{}
+	if c.share.Pid {
+		hostConfig.PidMode = container.PidMode("container:" + targetInspect.ID)
+	}
+	if c.share.Net {
+		hostConfig.NetworkMode = container.NetworkMode("container:" + targetInspect.ID)
+		networkingConfig.EndpointsConfig = c.targetInspect.NetworkSettings.Networks
+	}
+	if c.share.IPC {
+		// only the IPC namespaces created shareable can be joined
+		if mode := c.targetInspect.HostConfig.IpcMode; mode != "" && !mode.IsShareable() && !mode.IsHost() {
+			return "", fmt.Errorf("the IPC namespace of the target is %q and can't be shared, run it with --ipc shareable or leave ipc out of --share", mode)
+		}
+		hostConfig.IpcMode = container.IpcMode("container:" + targetInspect.ID)
+	}
+
 	resp, err := c.client.ContainerCreate(ctx, &container.Config{
 		Image:        image,
 		Entrypoint:   []string{"sh"},
@@ -101,20 +172,10 @@ func (c *DockerClient) CreateContainer(ctx context.Context, targetInspect *exec.
 		AttachStdin:  stdin,
 		AttachStdout: true,
 		AttachStderr: true,
+		Labels:       labels,
 	},
-		&container.HostConfig{
-			Privileged: targetInspect.IsPrivileged,
-			CapAdd:     c.targetInspect.HostConfig.CapAdd,
-			CapDrop:    c.targetInspect.HostConfig.CapDrop,
-
-			AutoRemove:  true, // remove the container when it exits TODO: make it configurable '--rm' flag
-			PidMode:     container.PidMode("container:" + targetInspect.ID),
-			NetworkMode: container.NetworkMode("container:" + targetInspect.ID),
-			Binds:       bindMount,
-		},
-		&network.NetworkingConfig{
-			EndpointsConfig: c.targetInspect.NetworkSettings.Networks,
-		},
+		hostConfig,
+		networkingConfig,
 		nil,
 		containerName,
 	)
@@ -126,6 +187,62 @@ func (c *DockerClient) CreateContainer(ctx context.Context, targetInspect *exec.
 }
 
 func (c *DockerClient) AttachContainer(ctx context.Context, containerID string, tty, stdin bool, cliStream *iocli.CliStream) error {
+	return c.attach(ctx, containerID, tty, stdin, false, true, cliStream)
+}
+
+// StartContainer starts a detached debugger container.
+func (c *DockerClient) StartContainer(ctx context.Context, containerID string) error {
+	return c.client.ContainerStart(ctx, containerID, types.ContainerStartOptions{})
+}
+
+// SignalContainer sends the signal to the entrypoint of the debugger.
+func (c *DockerClient) SignalContainer(ctx context.Context, containerID string, sig syscall.Signal) error {
+	return c.client.ContainerKill(ctx, containerID, strconv.Itoa(int(sig)))
+}
+
+// AttachSession reattaches to a running debugger container.
+func (c *DockerClient) AttachSession(ctx context.Context, containerID string, readOnly bool, cliStream *iocli.CliStream) error {
+	inspect, err := c.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return fmt.Errorf("Failed to inspect debugger container: %w", err)
+	}
+	if !inspect.State.Running {
+		return fmt.Errorf("debugger session %q is not running", containerID)
+	}
+	return c.attach(ctx, inspect.ID, inspect.Config.Tty, inspect.Config.OpenStdin && !readOnly, readOnly, false, cliStream)
+}
+
+// SessionTerminalSize reads the size of the TTY of a running debugger
+// container, with an exec in it.
+func (c *DockerClient) SessionTerminalSize(ctx context.Context, containerID string) (uint, uint, error) {
+	created, err := c.client.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		Cmd:          exec.TerminalSizeCommand,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to exec in debugger container: %w", err)
+	}
+	resp, err := c.client.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to exec in debugger container: %w", err)
+	}
+	defer resp.Close()
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, resp.Reader); err != nil {
+		return 0, 0, fmt.Errorf("failed to exec in debugger container: %w", err)
+	}
+	if stderr.Len() > 0 {
+		return 0, 0, errors.New(strings.TrimSpace(stderr.String()))
+	}
+	return exec.ParseTerminalSize(stdout.String())
+}
+
+// attach streams the IO of the debugger container until it exits or the user
+// detaches, the container is started once attached unless it is already
+// running. A read-only attach only reads the local TTY for the detach and the
+// control keys, and leaves its size to the other users.
+func (c *DockerClient) attach(ctx context.Context, containerID string, tty, stdin, readOnly, start bool, cliStream *iocli.CliStream) error {
 	resp, err := c.client.ContainerAttach(ctx, containerID, types.ContainerAttachOptions{
 		Stream: true,
 		Stdin:  stdin,
@@ -138,7 +255,7 @@ func (c *DockerClient) AttachContainer(ctx context.Context, containerID string,
 	defer resp.Close()
 
 	var cin io.ReadCloser
-	if stdin {
+	if stdin || readOnly {
 		cin = cliStream.InputStream()
 	}
 
@@ -148,97 +265,60 @@ func (c *DockerClient) AttachContainer(ctx context.Context, containerID string,
 		cerr = cliStream.OutputStream()
 	}
 
+	streamDone := make(chan struct{})
+	detached := make(chan error, 1)
 	go func() {
-		s := ioStreamer{
-			streams:      cliStream,
-			inputStream:  cin,
-			outputStream: cout,
-			errorStream:  cerr,
-			resp:         resp,
-			tty:          tty,
-			stdin:        stdin,
+		defer close(streamDone)
+		s := iocli.IOStreamer{
+			Streams:      cliStream,
+			InputStream:  cin,
+			OutputStream: cout,
+			ErrorStream:  cerr,
+			Resp:         resp,
+			Tty:          tty,
+			Stdin:        stdin,
+			ReadOnly:     readOnly,
+			DetachKeys:   c.detachKeys,
+			ControlKeys:  c.controlKeys,
 		}
 
-		if err := s.stream(ctx); err != nil {
-			log.Printf("ioStreamer.stream() failed: %s", err)
+		if err := s.Stream(ctx); errors.Is(err, iocli.ErrDetached) || errors.Is(err, iocli.ErrTakeControl) {
+			detached <- err
+		} else if err != nil {
+			log.Printf("IOStreamer.Stream() failed: %s", err)
 		}
 	}()
 
-	if err := c.client.ContainerStart(ctx, containerID, types.ContainerStartOptions{}); err != nil {
-		return fmt.Errorf("cannot start debugger container: %w", err)
-	}
-
-	if tty && cliStream.OutputStream().IsTerminal() {
-		iocli.StartResizing(ctx, cliStream.OutputStream(), c.client, containerID)
-	}
+	// wait before starting, the debugger container is auto removed and a
+	// short lived one could be gone before ContainerWait
+	statusCh, errCh := c.client.ContainerWait(ctx, containerID, container.WaitConditionRemoved)
 
-	statusCh, errCh := c.client.ContainerWait(ctx, containerID, container.WaitConditionNotRunning)
-	select {
-	case err := <-errCh:
-		if err != nil {
-			return fmt.Errorf("waiting debugger container failed: %w", err)
+	if start {
+		if err := c.client.ContainerStart(ctx, containerID, types.ContainerStartOptions{}); err != nil {
+			return fmt.Errorf("cannot start debugger container: %w", err)
 		}
-	case <-statusCh:
 	}
 
-	return nil
-
-}
-
-type ioStreamer struct {
-	streams *iocli.CliStream
-
-	inputStream  io.ReadCloser
-	outputStream io.Writer
-	errorStream  io.Writer
-
-	resp types.HijackedResponse
-
-	stdin bool
-	tty   bool
-}
-
-func (s *ioStreamer) stream(ctx context.Context) error {
-	if s.tty {
-		s.streams.InputStream().SetRawTerminal()
-		s.streams.OutputStream().SetRawTerminal()
-		defer func() {
-			s.streams.InputStream().RestoreTerminal()
-			s.streams.OutputStream().RestoreTerminal()
-		}()
+	if tty && !readOnly && cliStream.OutputStream().IsTerminal() {
+		// the resizing ends with the attach
+		resizeCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		iocli.StartResizing(resizeCtx, cliStream, func(ctx context.Context, height, width uint) error {
+			return c.client.ContainerResize(ctx, containerID, types.ResizeOptions{Height: height, Width: width})
+		})
 	}
 
-	inDone := make(chan error)
-	go func() {
-		if s.stdin {
-			if _, err := io.Copy(s.resp.Conn, s.inputStream); err != nil {
-				log.Printf("Error forwarding stdin: %s", err)
-			}
-		}
-		close(inDone)
-	}()
-
-	outDone := make(chan error)
-	go func() {
-		var err error
-		if s.tty {
-			_, err = io.Copy(s.outputStream, s.resp.Reader)
-		} else {
-			_, err = stdcopy.StdCopy(s.outputStream, s.errorStream, s.resp.Reader)
-		}
-		if err != nil {
-			log.Printf("Error forwarding stdout/stderr: %s", err)
-		}
-		close(outDone)
-	}()
-
 	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-inDone:
-		<-outDone
-		return nil
-	case <-outDone:
-		return nil
+	case err := <-detached:
+		return err
+	case err := <-errCh:
+		return fmt.Errorf("waiting debugger container failed: %w", err)
+	case status := <-statusCh:
+		if status.Error != nil {
+			return fmt.Errorf("waiting debugger container failed: %s", status.Error.Message)
+		}
+		// flush the output of the debugger before exiting
+		<-streamDone
+		return exec.ExitStatus(int(status.StatusCode))
 	}
 }
@@ -0,0 +1,40 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestNewClientShareUTS(t *testing.T) {
+	opts, err := exec.New([]exec.Option{exec.WithShare("net,pid,uts,mnt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard)
+	if _, err := NewClient(context.Background(), opts, cliStream); err == nil || !strings.Contains(err.Error(), "uts") {
+		t.Errorf("NewClient() error = %v, want the uts namespace rejected", err)
+	}
+}
+
+func TestCreateContainerShareIPC(t *testing.T) {
+	// the target is checked before the daemon is called, there is none
+	c := &DockerClient{
+		targetInspect: &types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+			HostConfig: &container.HostConfig{IpcMode: "private"},
+		}},
+		share: exec.Share{Pid: true, IPC: true, Mnt: true},
+	}
+	_, err := c.CreateContainer(context.Background(), &exec.ContainerInspectInfo{ID: "0123456789abcdef"},
+		"busybox", "sh", "root:root", "conxec-debugger-1a2b3c4d", nil, false, false, "")
+	if err == nil || !strings.Contains(err.Error(), `the IPC namespace of the target is "private"`) {
+		t.Errorf("CreateContainer() error = %v, want the private IPC namespace rejected", err)
+	}
+}
@@ -0,0 +1,77 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+)
+
+const (
+	composeProjectLabel = "com.docker.compose.project"
+	composeServiceLabel = "com.docker.compose.service"
+	composeNumberLabel  = "com.docker.compose.container-number"
+)
+
+type containerLister interface {
+	ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error)
+}
+
+// ResolveComposeTarget returns the id of the running container of a Compose
+// service addressed as project/service[/replica].
+func (c *DockerClient) ResolveComposeTarget(ctx context.Context, target string) (string, error) {
+	return resolveComposeTarget(ctx, c.client, target)
+}
+
+func resolveComposeTarget(ctx context.Context, lister containerLister, target string) (string, error) {
+	parts := strings.Split(target, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("invalid compose target %q. Use: <project>/<service>[/<replica>]", target)
+	}
+	project, service := parts[0], parts[1]
+
+	args := filters.NewArgs(
+		filters.Arg("label", composeProjectLabel+"="+project),
+		filters.Arg("label", composeServiceLabel+"="+service),
+		filters.Arg("status", "running"),
+	)
+	if len(parts) == 3 {
+		if _, err := strconv.Atoi(parts[2]); err != nil {
+			return "", fmt.Errorf("invalid replica %q of compose target %q: must be a number", parts[2], target)
+		}
+		args.Add("label", composeNumberLabel+"="+parts[2])
+	}
+
+	containers, err := lister.ContainerList(ctx, types.ContainerListOptions{Filters: args})
+	if err != nil {
+		return "", fmt.Errorf("failed to list containers of compose service %q: %w", target, err)
+	}
+
+	switch len(containers) {
+	case 0:
+		return "", fmt.Errorf("no running container found for compose service %q", target)
+	case 1:
+		return containers[0].ID, nil
+	default:
+		sort.Slice(containers, func(i, j int) bool {
+			ni, _ := strconv.Atoi(containers[i].Labels[composeNumberLabel])
+			nj, _ := strconv.Atoi(containers[j].Labels[composeNumberLabel])
+			return ni < nj
+		})
+		candidates := make([]string, 0, len(containers))
+		for _, cont := range containers {
+			name := cont.ID[:min(12, len(cont.ID))]
+			if len(cont.Names) > 0 {
+				name = strings.TrimPrefix(cont.Names[0], "/")
+			}
+			candidates = append(candidates, fmt.Sprintf("  %s/%s/%s (%s)",
+				project, service, cont.Labels[composeNumberLabel], name))
+		}
+		return "", fmt.Errorf("compose service %q has %d running replicas, pick one of:\n%s",
+			target, len(containers), strings.Join(candidates, "\n"))
+	}
+}
@@ -0,0 +1,84 @@
+package docker
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+type fakeLister struct {
+	containers []types.Container
+}
+
+// ContainerList applies the label filters, the other filters are ignored.
+func (l *fakeLister) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	var list []types.Container
+	for _, c := range l.containers {
+		match := true
+		for _, label := range options.Filters.Get("label") {
+			k, v, hasValue := strings.Cut(label, "=")
+			if got, ok := c.Labels[k]; !ok || hasValue && got != v {
+				match = false
+			}
+		}
+		if match {
+			list = append(list, c)
+		}
+	}
+	return list, nil
+}
+
+func composeContainer(id, project, service, number string) types.Container {
+	return types.Container{
+		ID:    id,
+		Names: []string{"/" + project + "-" + service + "-" + number},
+		Labels: map[string]string{
+			composeProjectLabel: project,
+			composeServiceLabel: service,
+			composeNumberLabel:  number,
+		},
+	}
+}
+
+func TestResolveComposeTarget(t *testing.T) {
+	lister := &fakeLister{containers: []types.Container{
+		composeContainer("db1", "shop", "db", "1"),
+		composeContainer("web2", "shop", "web", "2"),
+		composeContainer("web1", "shop", "web", "1"),
+		composeContainer("other", "blog", "web", "1"),
+	}}
+
+	tests := []struct {
+		target  string
+		want    string
+		wantErr string
+	}{
+		{target: "shop/db", want: "db1"},
+		{target: "shop/web/2", want: "web2"},
+		{target: "blog/web", want: "other"},
+		{target: "shop/web", wantErr: "shop/web/1 (shop-web-1)\n  shop/web/2 (shop-web-2)"},
+		{target: "shop/cache", wantErr: "no running container"},
+		{target: "shop/web/3", wantErr: "no running container"},
+		{target: "shop/web/x", wantErr: "must be a number"},
+		{target: "shop", wantErr: "invalid compose target"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			got, err := resolveComposeTarget(context.Background(), lister, tt.target)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("resolveComposeTarget() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveComposeTarget() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveComposeTarget() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
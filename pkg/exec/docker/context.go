@@ -0,0 +1,69 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/context/docker"
+	"github.com/docker/cli/cli/context/store"
+	"github.com/docker/docker/client"
+)
+
+const defaultContextName = "default"
+
+// contextStoreConfig reads only the docker endpoint of the contexts, the
+// metadata of the context itself is not used.
+var contextStoreConfig = store.NewConfig(
+	func() interface{} { return &map[string]interface{}{} },
+	store.EndpointTypeGetter(docker.DockerEndpoint, func() interface{} { return &docker.EndpointMeta{} }),
+)
+
+// resolveContextName picks the Docker CLI context the same way the docker CLI
+// does: the --context flag, then $DOCKER_HOST (which means the default
+// context), $DOCKER_CONTEXT and finally the current context of the config file.
+func resolveContextName(flagContext, runtime string) (string, error) {
+	if flagContext != "" && runtime != "" {
+		return "", errors.New("conflicting options: either specify --runtime or --context, not both")
+	}
+	if flagContext != "" {
+		return flagContext, nil
+	}
+	if runtime != "" || os.Getenv(client.EnvOverrideHost) != "" {
+		return defaultContextName, nil
+	}
+	if name := os.Getenv("DOCKER_CONTEXT"); name != "" {
+		return name, nil
+	}
+	if cfg := config.LoadDefaultConfigFile(io.Discard); cfg.CurrentContext != "" {
+		return cfg.CurrentContext, nil
+	}
+	return defaultContextName, nil
+}
+
+// contextClientOpts returns the client options for the endpoint and the TLS
+// material stored in the context (~/.docker/contexts), along with the host
+// of the endpoint. ssh:// endpoints go through the same ssh tunnel as
+// hostClientOpts.
+func contextClientOpts(contextName string) ([]client.Opt, string, error) {
+	s := store.New(config.ContextStoreDir(), contextStoreConfig)
+	meta, err := s.GetMetadata(contextName)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load docker context %q: %w", contextName, err)
+	}
+	epMeta, err := docker.EndpointFromContext(meta)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid docker context %q: %w", contextName, err)
+	}
+	ep, err := docker.WithTLSData(s, contextName, epMeta)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load TLS data of docker context %q: %w", contextName, err)
+	}
+	opts, err := ep.ClientOpts()
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid endpoint of docker context %q: %w", contextName, err)
+	}
+	return opts, ep.Host, nil
+}
@@ -0,0 +1,94 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/context/docker"
+	"github.com/docker/cli/cli/context/store"
+	"github.com/docker/docker/client"
+)
+
+func setupConfigDir(t *testing.T, currentContext string) {
+	t.Helper()
+	dir := t.TempDir()
+	config.SetDir(dir)
+	t.Cleanup(func() { config.SetDir(os.Getenv("DOCKER_CONFIG")) })
+
+	cfg := `{"currentContext": "` + currentContext + `"}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := store.New(config.ContextStoreDir(), contextStoreConfig)
+	if err := s.CreateOrUpdate(store.Metadata{
+		Name:     "remote",
+		Metadata: map[string]interface{}{},
+		Endpoints: map[string]interface{}{
+			docker.DockerEndpoint: docker.EndpointMeta{Host: "tcp://remote.example.com:2376", SkipTLSVerify: true},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveContextName(t *testing.T) {
+	tests := []struct {
+		name           string
+		flagContext    string
+		runtime        string
+		dockerHost     string
+		dockerContext  string
+		currentContext string
+		want           string
+		wantErr        bool
+	}{
+		{name: "nothing set", want: "default"},
+		{name: "config file", currentContext: "remote", want: "remote"},
+		{name: "DOCKER_CONTEXT over config file", dockerContext: "other", currentContext: "remote", want: "other"},
+		{name: "DOCKER_HOST over DOCKER_CONTEXT", dockerHost: "tcp://h:2375", dockerContext: "other", want: "default"},
+		{name: "runtime over config file", runtime: "/var/run/docker.sock", currentContext: "remote", want: "default"},
+		{name: "flag over everything", flagContext: "remote", dockerHost: "tcp://h:2375", dockerContext: "other", want: "remote"},
+		{name: "flag and runtime", flagContext: "remote", runtime: "/var/run/docker.sock", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupConfigDir(t, tt.currentContext)
+			t.Setenv(client.EnvOverrideHost, tt.dockerHost)
+			t.Setenv("DOCKER_CONTEXT", tt.dockerContext)
+
+			got, err := resolveContextName(tt.flagContext, tt.runtime)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveContextName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveContextName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContextClientOpts(t *testing.T) {
+	setupConfigDir(t, "")
+
+	opts, host, err := contextClientOpts("remote")
+	if err != nil {
+		t.Fatalf("contextClientOpts() error = %v", err)
+	}
+	c, err := client.NewClientWithOpts(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "tcp://remote.example.com:2376" {
+		t.Errorf("contextClientOpts() host = %q, want the context endpoint", host)
+	}
+	if got := c.DaemonHost(); got != "tcp://remote.example.com:2376" {
+		t.Errorf("DaemonHost() = %q, want the context endpoint", got)
+	}
+
+	if _, _, err := contextClientOpts("missing"); err == nil {
+		t.Errorf("contextClientOpts() of a missing context succeeded")
+	}
+}
@@ -0,0 +1,51 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+type eventSource interface {
+	Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error)
+	ContainerInspect(ctx context.Context, container string) (types.ContainerJSON, error)
+}
+
+// WatchTarget reports the death of the target from the docker events, the
+// inspect of the dead container tells whether it was OOM killed.
+func (c *DockerClient) WatchTarget(ctx context.Context, targetID string) (<-chan exec.TargetExit, error) {
+	return watchTarget(ctx, c.client, targetID, c.out), nil
+}
+
+func watchTarget(ctx context.Context, source eventSource, targetID string, out io.Writer) <-chan exec.TargetExit {
+	msgs, errs := source.Events(ctx, types.EventsOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("container", targetID),
+			filters.Arg("event", "die"),
+		),
+	})
+	exits := make(chan exec.TargetExit, 1)
+	go func() {
+		select {
+		case msg := <-msgs:
+			exit := exec.TargetExit{}
+			exit.ExitCode, _ = strconv.Atoi(msg.Actor.Attributes["exitCode"])
+			if inspect, err := source.ContainerInspect(ctx, targetID); err == nil && inspect.State != nil {
+				exit.OOMKilled = inspect.State.OOMKilled
+			}
+			exits <- exit
+		case err := <-errs:
+			if ctx.Err() == nil {
+				fmt.Fprintf(out, "stopped watching the target: %s\n", err)
+			}
+		}
+	}()
+	return exits
+}
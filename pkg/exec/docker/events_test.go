@@ -0,0 +1,59 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+)
+
+type fakeEvents struct {
+	msgs      chan events.Message
+	errs      chan error
+	oomKilled bool
+	filters   types.EventsOptions
+}
+
+func (f *fakeEvents) Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error) {
+	f.filters = options
+	return f.msgs, f.errs
+}
+
+func (f *fakeEvents) ContainerInspect(ctx context.Context, container string) (types.ContainerJSON, error) {
+	return types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+		State: &types.ContainerState{OOMKilled: f.oomKilled, ExitCode: 137},
+	}}, nil
+}
+
+func TestWatchTarget(t *testing.T) {
+	source := &fakeEvents{msgs: make(chan events.Message, 1), errs: make(chan error, 1), oomKilled: true}
+	exits := watchTarget(context.Background(), source, "0123456789abcdef", io.Discard)
+	if got := source.filters.Filters.Get("container"); len(got) != 1 || got[0] != "0123456789abcdef" {
+		t.Errorf("container filter = %v, want the target", got)
+	}
+
+	source.msgs <- events.Message{Action: "die", Actor: events.Actor{Attributes: map[string]string{"exitCode": "137"}}}
+	select {
+	case exit := <-exits:
+		if want := (exec.TargetExit{ExitCode: 137, OOMKilled: true}); exit != want {
+			t.Errorf("exit = %+v, want %+v", exit, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("exit of the target was not reported")
+	}
+
+	// a broken event stream reports nothing
+	source = &fakeEvents{msgs: make(chan events.Message), errs: make(chan error, 1)}
+	exits = watchTarget(context.Background(), source, "0123456789abcdef", io.Discard)
+	source.errs <- errors.New("connection reset")
+	select {
+	case exit := <-exits:
+		t.Errorf("exit = %+v reported from a broken stream", exit)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
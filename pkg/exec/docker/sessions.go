@@ -0,0 +1,106 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// ListSessions returns the debugger containers, including the exited ones
+// which are not removed yet.
+func (c *DockerClient) ListSessions(ctx context.Context) ([]exec.Session, error) {
+	return listSessions(ctx, c.client)
+}
+
+func listSessions(ctx context.Context, lister containerLister) ([]exec.Session, error) {
+	containers, err := lister.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", exec.LabelSession)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list debugger containers: %w", err)
+	}
+	sessions := make([]exec.Session, 0, len(containers))
+	for _, cont := range containers {
+		name := cont.ID[:min(12, len(cont.ID))]
+		if len(cont.Names) > 0 {
+			name = strings.TrimPrefix(cont.Names[0], "/")
+		}
+		sessions = append(sessions, exec.SessionFromLabels(name, cont.State, cont.Labels))
+	}
+	return sessions, nil
+}
+
+// StopSession stops the debugger container and removes it. The entrypoint
+// gets SIGTERM to end the command and clean up the target, the container is
+// only killed when it is still running after exec.SessionStopTimeout.
+func (c *DockerClient) StopSession(ctx context.Context, id string) error {
+	inspect, err := c.sessionInspect(ctx, id)
+	if err != nil {
+		return err
+	}
+	if inspect.State != nil && inspect.State.Running {
+		if err := c.client.ContainerKill(ctx, id, "TERM"); err != nil && !client.IsErrNotFound(err) {
+			return fmt.Errorf("failed to stop debugger container: %w", err)
+		}
+		waitCtx, cancel := context.WithTimeout(ctx, exec.SessionStopTimeout)
+		waited, errs := c.client.ContainerWait(waitCtx, id, container.WaitConditionNotRunning)
+		// the debugger is auto removed, the wait fails once it is gone
+		select {
+		case <-waited:
+		case <-errs:
+		}
+		cancel()
+	}
+	// the debugger is auto removed, it can be gone already
+	err = c.client.ContainerRemove(ctx, id, types.ContainerRemoveOptions{Force: true})
+	if err != nil && !client.IsErrNotFound(err) && !strings.Contains(err.Error(), "already in progress") {
+		return fmt.Errorf("failed to remove debugger container: %w", err)
+	}
+	return nil
+}
+
+// SessionLogs writes the output of the debugger container.
+func (c *DockerClient) SessionLogs(ctx context.Context, id string, follow bool, stdout, stderr io.Writer) error {
+	inspect, err := c.sessionInspect(ctx, id)
+	if err != nil {
+		return err
+	}
+	logs, err := c.client.ContainerLogs(ctx, id, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     follow,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get debugger logs: %w", err)
+	}
+	defer logs.Close()
+
+	if inspect.Config.Tty {
+		_, err = io.Copy(stdout, logs)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, logs)
+	}
+	return err
+}
+
+// sessionInspect inspects the container and checks it is a debugger, so the
+// sessions commands can't be used on other containers.
+func (c *DockerClient) sessionInspect(ctx context.Context, id string) (types.ContainerJSON, error) {
+	inspect, err := c.client.ContainerInspect(ctx, id)
+	if err != nil {
+		return inspect, fmt.Errorf("Failed to inspect debugger container: %w", err)
+	}
+	if _, ok := inspect.Config.Labels[exec.LabelSession]; !ok {
+		return inspect, fmt.Errorf("container %q is not a conxec debugger session", id)
+	}
+	return inspect, nil
+}
@@ -0,0 +1,109 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+func TestListSessions(t *testing.T) {
+	started := time.Date(2023, 11, 2, 10, 0, 0, 0, time.UTC)
+	lister := &fakeLister{containers: []types.Container{
+		{
+			ID:     "0123456789abcdef",
+			Names:  []string{"/conxec-debugger-1a2b3c4d"},
+			State:  "running",
+			Labels: exec.SessionLabels("1a2b3c4d", "web1", []string{"sh"}, "root:root", started),
+		},
+		composeContainer("web1", "shop", "web", "1"),
+	}}
+
+	sessions, err := listSessions(context.Background(), lister)
+	if err != nil {
+		t.Fatalf("listSessions() error = %v", err)
+	}
+	want := []exec.Session{{
+		ID:      "conxec-debugger-1a2b3c4d",
+		RunID:   "1a2b3c4d",
+		Target:  "web1",
+		Command: "sh",
+		User:    "root:root",
+		Started: started,
+		Status:  "running",
+	}}
+	if len(sessions) != 1 || sessions[0] != want[0] {
+		t.Errorf("listSessions() = %+v, want %+v", sessions, want)
+	}
+}
+
+func TestStopSession(t *testing.T) {
+	tests := []struct {
+		name    string
+		running bool
+		want    []string
+	}{
+		{
+			// the entrypoint cleans up the target before the container is removed
+			name:    "running",
+			running: true,
+			want: []string{
+				"GET /containers/conxec-debugger-1a2b3c4d/json",
+				"POST /containers/conxec-debugger-1a2b3c4d/kill?signal=TERM",
+				"POST /containers/conxec-debugger-1a2b3c4d/wait?condition=not-running",
+				"DELETE /containers/conxec-debugger-1a2b3c4d?force=1",
+			},
+		},
+		{
+			name: "exited",
+			want: []string{
+				"GET /containers/conxec-debugger-1a2b3c4d/json",
+				"DELETE /containers/conxec-debugger-1a2b3c4d?force=1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path := strings.TrimPrefix(r.URL.Path, "/v1.43")
+				call := r.Method + " " + path
+				if r.URL.RawQuery != "" {
+					call += "?" + r.URL.RawQuery
+				}
+				calls = append(calls, call)
+				switch path {
+				case "/containers/conxec-debugger-1a2b3c4d/json":
+					fmt.Fprintf(w, `{"Id":"debugger-id","State":{"Running":%t},"Config":{"Labels":{"io.conxec.session":"1a2b3c4d"}}}`, tt.running)
+				case "/containers/conxec-debugger-1a2b3c4d/wait":
+					w.Write([]byte(`{"StatusCode":143}`))
+				case "/containers/conxec-debugger-1a2b3c4d/kill", "/containers/conxec-debugger-1a2b3c4d":
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+			dockerClient, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithVersion("1.43"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := &DockerClient{client: dockerClient}
+			if err := c.StopSession(context.Background(), "conxec-debugger-1a2b3c4d"); err != nil {
+				t.Fatalf("StopSession() error = %v", err)
+			}
+			if !reflect.DeepEqual(calls, tt.want) {
+				t.Errorf("calls = %q, want %q", calls, tt.want)
+			}
+		})
+	}
+}
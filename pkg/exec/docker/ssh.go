@@ -0,0 +1,39 @@
+package docker
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/docker/cli/cli/connhelper"
+	"github.com/docker/docker/client"
+)
+
+// hostClientOpts returns the client options of the default context for the
+// host given by --runtime or $DOCKER_HOST. ssh://user@host[:port] hosts are
+// tunnelled over an ssh connection running "docker system dial-stdio" on the
+// remote host, like the docker CLI does. The dialer is used for the hijacked
+// attach connection too.
+func hostClientOpts(host string) ([]client.Opt, error) {
+	opts := []client.Opt{
+		client.FromEnv,
+		client.WithAPIVersionNegotiation(),
+	}
+	if host == "" {
+		return opts, nil
+	}
+
+	helper, err := connhelper.GetConnectionHelper(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host %q: %w", host, err)
+	}
+	if helper == nil {
+		return append(opts, client.WithHost(host)), nil
+	}
+	return append(opts,
+		client.WithHTTPClient(&http.Client{
+			Transport: &http.Transport{DialContext: helper.Dialer},
+		}),
+		client.WithHost(helper.Host),
+		client.WithDialContext(helper.Dialer),
+	), nil
+}
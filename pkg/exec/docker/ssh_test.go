@@ -0,0 +1,40 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/docker/docker/client"
+)
+
+func TestHostClientOpts(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		want    string
+		wantErr bool
+	}{
+		{name: "unix socket", host: "unix:///var/run/docker.sock", want: "unix:///var/run/docker.sock"},
+		{name: "tcp", host: "tcp://127.0.0.1:2375", want: "tcp://127.0.0.1:2375"},
+		{name: "ssh", host: "ssh://dev@bastion.example.com:2222", want: "http://docker.example.com"},
+		{name: "ssh with query", host: "ssh://dev@bastion.example.com?x=y", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(client.EnvOverrideHost, "")
+			opts, err := hostClientOpts(tt.host)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("hostClientOpts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			c, err := client.NewClientWithOpts(opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.DaemonHost(); got != tt.want {
+				t.Errorf("DaemonHost() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
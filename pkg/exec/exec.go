@@ -1,24 +1,36 @@
 package exec
 
 import (
+	"bytes"
 	"context"
 	_ "embed"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
 	"regexp"
-	"strconv"
 	"strings"
+	"syscall"
 	"text/template"
+	"time"
 
+	"github.com/debasishbsws/conxec/pkg/audit"
 	"github.com/debasishbsws/conxec/pkg/iocli"
 	"github.com/google/uuid"
+	"github.com/moby/term"
 )
 
 const (
 	defaultDebuggerImage = "ghcr.io/debasishbsws/conxec-debugger:latest"
+	// defaultDetachKeys are the ones of docker
+	defaultDetachKeys = "ctrl-p,ctrl-q"
+	// maxScriptSize keeps the entrypoint, which holds the script, under the
+	// size limit of an argument on Linux
+	maxScriptSize = 64 << 10
 )
 
 func New(opt []Option) (*ExecOptions, error) {
-	exec := &ExecOptions{}
+	exec := &ExecOptions{Share: DefaultShare}
 	for _, o := range opt {
 		if err := o(exec); err != nil {
 			return nil, err
@@ -29,20 +41,42 @@ func New(opt []Option) (*ExecOptions, error) {
 }
 
 type ExecOptions struct {
-	Target            string   // target is the container id or name
-	Command           []string // cmd is the command to execute
-	DbgImg            string   // dbgImg is the debugger image
-	Name              string   // name is the name of the container
-	Runtime           string   // runtime is the docker runtime
-	Schema            string   // schema is the schema of the target
-	UserN             string   // user-name is the user name of the target
-	UserID            string   // user-id is the user id of the target
-	GroupN            string   // group-name is the group name of the target
-	GroupID           string   // group-id is the group id of the target
-	Tty               bool     // tty is the flag to enable tty
-	Stdin             bool     // interactive is the flag to enable interactive
-	AditionalPackages []string // aditionalPackages is the list of packages to install
-	mountDir          string   // mountDir is the directory to mount in the target container
+	Target            string        // target is the container id or name
+	Command           []string      // cmd is the command to execute
+	Shell             bool          // shell is the flag to run the command as a sh script instead of as is
+	Script            []byte        // script is the local sh script run in the target, with the command as its arguments
+	Share             Share         // share is the set of namespaces of the target the debugger joins
+	ShareSet          bool          // shareSet is the flag telling Share was given, the runtimes then reject the namespaces they can't keep private
+	Process           string        // process is the pid, name or regex of the process of the target the command runs in, PID 1 when empty
+	InheritEnv        bool          // inheritEnv is the flag to run the command with the environment of the process of the target
+	InheritCwd        bool          // inheritCwd is the flag to start the command in the working directory of the process of the target
+	Env               []string      // env is the list of KEY=VAL set for the command, over the inherited ones
+	DbgImg            string        // dbgImg is the debugger image
+	Name              string        // name is the name of the container
+	Runtime           string        // runtime is the docker runtime
+	RuntimeType       string        // runtimeType is the type of runtime to use when the target has no schema
+	Schema            string        // schema is the schema of the target
+	UserN             string        // user-name is the user name of the target
+	UserID            string        // user-id is the user id of the target
+	GroupN            string        // group-name is the group name of the target
+	GroupID           string        // group-id is the group id of the target
+	Tty               bool          // tty is the flag to enable tty
+	Stdin             bool          // interactive is the flag to enable interactive
+	AditionalPackages []string      // aditionalPackages is the list of packages to install
+	mountDir          string        // mountDir is the directory to mount in the target container
+	Kubeconfig        string        // kubeconfig is the path to the kubeconfig file
+	KubeContext       string        // kubeContext is the kubeconfig context to use
+	DockerContext     string        // dockerContext is the docker CLI context to use
+	Detach            bool          // detach is the flag to start the debugger without attaching to it
+	FollowRestarts    bool          // followRestarts is the flag to create the debugger again when the target restarts
+	DetachKeys        []byte        // detachKeys is the key sequence detaching from the debugger with a TTY
+	ReadOnly          bool          // readOnly is the flag to attach to a session without sending input
+	ControlKeys       []byte        // controlKeys is the key sequence taking control of a session attached read-only
+	controlKeys       string        // controlKeys as typed, printed to the read-only users
+	Timeout           time.Duration // timeout is the wall-clock limit of the session
+	IdleTimeout       time.Duration // idleTimeout is the limit of the time without input or output on the TTY
+	Record            string        // record is the path of the asciicast recording of the session
+	Audit             *audit.Logger // audit is the audit log of the sessions, nil when they are not audited
 }
 
 type Option func(*ExecOptions) error
@@ -61,6 +95,92 @@ func WithCommand(command []string) Option {
 	}
 }
 
+func WithShell(shell bool) Option {
+	return func(opt *ExecOptions) error {
+		opt.Shell = shell
+		return nil
+	}
+}
+
+// WithShare sets the namespaces of the target the debugger joins, a comma
+// separated list of net, pid, ipc, uts and mnt. The default is DefaultShare.
+func WithShare(namespaces string) Option {
+	return func(opt *ExecOptions) error {
+		if namespaces == "" {
+			return nil
+		}
+		share, err := ParseShare(namespaces)
+		if err != nil {
+			return err
+		}
+		opt.Share = share
+		opt.ShareSet = true
+		return nil
+	}
+}
+
+// WithProcess runs the command in the root filesystem and working directory
+// of a process of the target: a pid, a name or a regex of its command line.
+// The entrypoint matches the regex with grep -E, it is a POSIX ERE.
+func WithProcess(process string) Option {
+	return func(opt *ExecOptions) error {
+		if process == "" {
+			return nil
+		}
+		if _, err := regexp.CompilePOSIX(process); err != nil {
+			return fmt.Errorf("invalid process %q, regexes are POSIX extended ones as for grep -E: %w", process, err)
+		}
+		opt.Process = process
+		return nil
+	}
+}
+
+func WithInheritEnv(inheritEnv bool) Option {
+	return func(opt *ExecOptions) error {
+		opt.InheritEnv = inheritEnv
+		return nil
+	}
+}
+
+func WithInheritCwd(inheritCwd bool) Option {
+	return func(opt *ExecOptions) error {
+		opt.InheritCwd = inheritCwd
+		return nil
+	}
+}
+
+// WithEnv sets the KEY=VAL variables of the command, the last one of a key
+// wins.
+func WithEnv(env []string) Option {
+	return func(opt *ExecOptions) error {
+		for _, kv := range env {
+			key, _, ok := strings.Cut(kv, "=")
+			if !ok || key == "" || strings.ContainsRune(kv, 0) {
+				return fmt.Errorf("invalid environment variable %q: want KEY=VAL", kv)
+			}
+		}
+		opt.Env = append(opt.Env, env...)
+		return nil
+	}
+}
+
+// WithScript runs the sh script in the target, the command is its arguments.
+func WithScript(script []byte) Option {
+	return func(opt *ExecOptions) error {
+		if script == nil {
+			return nil
+		}
+		if len(script) > maxScriptSize {
+			return fmt.Errorf("script is too large: %d bytes, at most %d are supported", len(script), maxScriptSize)
+		}
+		if bytes.IndexByte(script, 0) >= 0 {
+			return fmt.Errorf("invalid script: it is not a text file")
+		}
+		opt.Script = script
+		return nil
+	}
+}
+
 func WithName(name string) Option {
 	return func(opt *ExecOptions) error {
 		opt.Name = name
@@ -85,6 +205,98 @@ func WithRuntime(runtime string) Option {
 	}
 }
 
+func WithRuntimeType(runtimeType string) Option {
+	return func(opt *ExecOptions) error {
+		opt.RuntimeType = runtimeType
+		return nil
+	}
+}
+
+func WithDetach(detach bool) Option {
+	return func(opt *ExecOptions) error {
+		opt.Detach = detach
+		return nil
+	}
+}
+
+func WithFollowRestarts(follow bool) Option {
+	return func(opt *ExecOptions) error {
+		opt.FollowRestarts = follow
+		return nil
+	}
+}
+
+func WithDetachKeys(keys string) Option {
+	if keys == "" {
+		keys = defaultDetachKeys
+	}
+	return func(opt *ExecOptions) error {
+		detachKeys, err := term.ToBytes(keys)
+		if err != nil {
+			return fmt.Errorf("invalid detach keys %q: %w", keys, err)
+		}
+		opt.DetachKeys = detachKeys
+		return nil
+	}
+}
+
+func WithReadOnly(readOnly bool) Option {
+	return func(opt *ExecOptions) error {
+		opt.ReadOnly = readOnly
+		return nil
+	}
+}
+
+// WithControlKeys lets the read-only users take control of the session with
+// the keys, they can't when keys is empty.
+func WithControlKeys(keys string) Option {
+	return func(opt *ExecOptions) error {
+		if keys == "" {
+			return nil
+		}
+		controlKeys, err := term.ToBytes(keys)
+		if err != nil {
+			return fmt.Errorf("invalid control keys %q: %w", keys, err)
+		}
+		opt.ControlKeys, opt.controlKeys = controlKeys, keys
+		return nil
+	}
+}
+
+func WithTimeout(timeout time.Duration) Option {
+	return func(opt *ExecOptions) error {
+		if timeout < 0 || (timeout > 0 && timeout < time.Second) {
+			return fmt.Errorf("invalid timeout %s: it must be 0 or at least 1s", timeout)
+		}
+		opt.Timeout = timeout
+		return nil
+	}
+}
+
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(opt *ExecOptions) error {
+		if timeout < 0 || (timeout > 0 && timeout < time.Second) {
+			return fmt.Errorf("invalid idle timeout %s: it must be 0 or at least 1s", timeout)
+		}
+		opt.IdleTimeout = timeout
+		return nil
+	}
+}
+
+func WithRecord(path string) Option {
+	return func(opt *ExecOptions) error {
+		opt.Record = path
+		return nil
+	}
+}
+
+func WithAudit(logger *audit.Logger) Option {
+	return func(opt *ExecOptions) error {
+		opt.Audit = logger
+		return nil
+	}
+}
+
 func WithUser(user string) Option {
 	reg, err := regexp.Compile(`^[a-z_][a-z0-9_-]*:[0-9]+::[a-z_][a-z0-9_-]*:[0-9]+$`)
 	if err != nil {
@@ -131,48 +343,225 @@ func WithMountDir(dir string) Option {
 	}
 }
 
+func WithKubeconfig(kubeconfig string) Option {
+	return func(opt *ExecOptions) error {
+		opt.Kubeconfig = kubeconfig
+		return nil
+	}
+}
+
+func WithKubeContext(kubeContext string) Option {
+	return func(opt *ExecOptions) error {
+		opt.KubeContext = kubeContext
+		return nil
+	}
+}
+
+func WithDockerContext(dockerContext string) Option {
+	return func(opt *ExecOptions) error {
+		opt.DockerContext = dockerContext
+		return nil
+	}
+}
+
 type DebuggerClient interface {
 	// GetContainerInfo returns the container info
 	GetContainerInfo(ctx context.Context, containerName string) (*ContainerInspectInfo, error)
 	// Pull an iamge from the registry if not present
 	PullImage(ctx context.Context, iamgeName string, patform string) error
-	// Create a Container and return the container id
+	// Create a Container with the session labels and return the container id
 	CreateContainer(ctx context.Context, targetInspect *ContainerInspectInfo,
-		image, entrypoint, user, containerName string,
+		image, entrypoint, user, containerName string, labels map[string]string,
 		tty, stdin bool, mountDir string) (containerID string, err error)
+	// Attach to the container, start it and wait for it to exit. The exit
+	// status of the debugger is returned with ExitStatus.
 	AttachContainer(ctx context.Context, containerID string, tty, stdin bool, cliStream *iocli.CliStream) error
 }
 
-func shellescape(args []string) []string {
-	escaped := []string{}
-	for _, a := range args {
-		// check if the string has any special characters or escape charecters
-		if strings.ContainsAny(a, " \t\n\r") {
-			escaped = append(escaped, strconv.Quote(a))
-		} else {
-			escaped = append(escaped, a)
-		}
+// SessionClient is implemented by the backends supporting detached debugger
+// sessions, which can be reattached later.
+type SessionClient interface {
+	// Start the debugger container without attaching to it
+	StartContainer(ctx context.Context, containerID string) error
+	// Reattach to a running debugger container and wait for it to exit. The
+	// TTY and stdin settings are the ones the container was created with, a
+	// read-only attach sends no input and does not resize the TTY.
+	AttachSession(ctx context.Context, containerID string, readOnly bool, cliStream *iocli.CliStream) error
+}
+
+// TerminalSizer is implemented by the backends which can tell the size of
+// the TTY of a running debugger, for its read-only users.
+type TerminalSizer interface {
+	// Return the size of the TTY of the debugger container
+	SessionTerminalSize(ctx context.Context, containerID string) (height, width uint, err error)
+}
+
+// entrypointPIDFile holds the PID of the entrypoint in the debugger, which
+// is not PID 1 when the debugger joins the PID namespace of the target.
+const entrypointPIDFile = "/tmp/.conxec-entrypoint.pid"
+
+// TerminalSizeCommand prints the size of the TTY of a debugger, the one of
+// its entrypoint, as "<rows> <columns>".
+var TerminalSizeCommand = []string{"sh", "-c", "stty size </proc/$(cat " + entrypointPIDFile + ")/fd/0"}
+
+// ParseTerminalSize parses the output of TerminalSizeCommand.
+func ParseTerminalSize(out string) (height, width uint, err error) {
+	if _, err := fmt.Sscanf(strings.TrimSpace(out), "%d %d", &height, &width); err != nil {
+		return 0, 0, fmt.Errorf("invalid terminal size %q", strings.TrimSpace(out))
+	}
+	return height, width, nil
+}
+
+// SignalClient is implemented by the backends which can signal a debugger
+// container, the local signals are passed to the debuggers without TTY.
+type SignalClient interface {
+	// Send the signal to the entrypoint of the debugger container
+	SignalContainer(ctx context.Context, containerID string, sig syscall.Signal) error
+}
+
+// ImageDigester is implemented by the backends which can tell the digest of
+// a pulled image, for the audit log.
+type ImageDigester interface {
+	// Return the repo digest of the image, or its ID when it has none
+	ImageDigest(ctx context.Context, image string) (string, error)
+}
+
+// ExitStatus returns the error carrying the exit code of the debugger to the
+// exit status of conxec, nil when the debugger succeeded.
+func ExitStatus(code int) error {
+	if code == 0 {
+		return nil
 	}
-	return escaped
+	return iocli.NewStatusError(code, "debugger exited with status %d", code)
+}
+
+// shellquote quotes the argument for sh, as a single word taken literally.
+func shellquote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// commandArgs returns the argv of the command run in the target: the command
+// as given, or a script of its words joined by spaces, as typed on a
+// terminal, run by sh with shell.
+func commandArgs(cmd []string, shell bool) []string {
+	if len(cmd) == 0 {
+		return []string{"sh"}
+	}
+	if shell {
+		return []string{"sh", "-c", strings.Join(cmd, " ")}
+	}
+	return cmd
 }
 
 //go:embed conxec-entrypoint.templ
 var entrypointTemplate string
 
-func generateEntrypoint(runID string, targetPID int, cmd []string, isRoot bool, apps []string) string {
+// limitWarning is how long before the end of a limited session the entrypoint
+// warns, at most half of the shortest limit.
+const limitWarning = time.Minute
+
+// timeoutGrace is how long conxec lets the debugger outlive the time limit of
+// the session, its watchdog ends the command and kills it 10s later.
+const timeoutGrace = 30 * time.Second
+
+// scriptPath is the path of the script of a debugger in the target, or in the
+// debugger itself without chroot.
+func scriptPath(runID string, chroot bool) string {
+	if !chroot {
+		return "/tmp/.conxec-script.sh"
+	}
+	return "/tmp/.conxec-script-" + runID
+}
+
+// entrypointOptions are the settings of the entrypoint of a debugger.
+type entrypointOptions struct {
+	RunID       string        // runID names the files of the debugger in the target
+	TargetPID   int           // targetPID is the process of the target the debugger enters
+	Process     string        // process is the pid, name or regex of the process the command runs in instead of targetPID
+	Command     []string      // command is the argv run in the target exactly, sh when empty
+	IsRoot      bool          // isRoot is the flag telling the packages can be installed
+	Tty         bool          // tty is the flag telling the debugger has a TTY
+	Packages    []string      // packages is the list of packages to install
+	Timeout     time.Duration // timeout ends the session after it
+	IdleTimeout time.Duration // idleTimeout ends the session when the terminal is idle for it, only with a TTY
+	Script      []byte        // script is linked at scriptPath in the target
+	Chroot      bool          // chroot is the flag to run the command in the root filesystem of the target
+	Env         []string      // env is the list of KEY=VAL set for the command
+	InheritEnv  bool          // inheritEnv is the flag to run the command with the environment of the process
+	InheritCwd  bool          // inheritCwd is the flag to start the command in the working directory of the process
+}
+
+// generateEntrypoint returns the entrypoint of the debugger, which runs the
+// command in the target. Without chroot the command runs in the root
+// filesystem of the debugger, and the target is left untouched. With a
+// process the command runs in its root and working directory instead of the
+// ones of the target PID, it is resolved by the debugger among the processes
+// sharing the root of the target PID. The env variables are set over the
+// environment of the debugger, or of the process with inheritEnv.
+func generateEntrypoint(o entrypointOptions) string {
 	entrypointTemplae := template.Must(template.New("entrypoint").Parse(entrypointTemplate))
-	var command string
-	if len(cmd) == 0 {
-		command = "sh"
-	} else {
-		command = "sh -c '" + strings.Join(shellescape(cmd), " ") + "'"
+	args := []string{}
+	for _, arg := range commandArgs(o.Command, false) {
+		args = append(args, shellquote(arg))
 	}
 	data := map[string]interface{}{
-		"ISROOT": isRoot,
-		"APPS":   apps,
-		"ID":     runID,
-		"PID":    fmt.Sprintf("%d", targetPID),
-		"CMD":    command,
+		"ISROOT":  o.IsRoot,
+		"APPS":    o.Packages,
+		"ID":      o.RunID,
+		"PID":     fmt.Sprintf("%d", o.TargetPID),
+		"ARGS":    strings.Join(args, " "),
+		"SCRIPT":  string(o.Script),
+		"CHROOT":  o.Chroot,
+		"PROCESS": "",
+		"TTY":     o.Tty,
+	}
+	timeout, idleTimeout := o.Timeout, o.IdleTimeout
+	if !o.Tty {
+		idleTimeout = 0
+	}
+	warning := limitWarning
+	for _, limit := range []time.Duration{timeout, idleTimeout} {
+		if limit > 0 && limit/2 < warning {
+			warning = limit / 2
+		}
+	}
+	data["LIMITED"] = timeout > 0 || idleTimeout > 0
+	data["TIMEOUT"] = int(timeout.Seconds())
+	data["TIMEOUT_TEXT"] = timeout.String()
+	data["IDLE"] = int(idleTimeout.Seconds())
+	data["IDLE_TEXT"] = idleTimeout.String()
+	data["WARN"] = int(warning.Seconds())
+	if o.Process != "" && o.Chroot {
+		data["PROCESS"] = shellquote(o.Process)
+	}
+	data["CWD"] = o.Chroot && (o.Process != "" || o.InheritCwd)
+	data["INHERIT_ENV"] = o.InheritEnv
+	// the tools of the debugger stay in the PATH of the command
+	data["MNTD"] = "/work"
+	data["TOOLS"] = ":/usr/bin:/bin"
+	if o.Chroot {
+		data["MNTD"] = "/tmp/.conxec-mount-" + o.RunID
+		data["TOOLS"] = ":/tmp/.conxec-bin-" + o.RunID + ":/tmp/.conxec-usrbin-" + o.RunID
+	}
+	// the last value of a key wins, the entrypoint sets each key once
+	envArgs := []string{}
+	seen := map[string]bool{}
+	for i := len(o.Env) - 1; i >= 0; i-- {
+		kv := o.Env[i]
+		key, _, _ := strings.Cut(kv, "=")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if key == "PATH" {
+			kv += data["TOOLS"].(string)
+		}
+		envArgs = append([]string{shellquote(kv)}, envArgs...)
+	}
+	data["ENV"] = strings.Join(envArgs, " ")
+	if o.Script != nil && !bytes.HasSuffix(o.Script, []byte("\n")) {
+		// the end of the heredoc holding the script must be on a line of its own
+		data["SCRIPT"] = string(o.Script) + "\n"
 	}
 	var entrypoint strings.Builder
 	if err := entrypointTemplae.Execute(&entrypoint, data); err != nil {
@@ -183,6 +572,8 @@ func generateEntrypoint(runID string, targetPID int, cmd []string, isRoot bool,
 
 type ContainerInspectInfo struct {
 	ID            string
+	Name          string // Name is the name of the target, when the runtime has one
+	Image         string // Image is the image the target was created from
 	Isrunning     bool
 	IsPrivileged  bool
 	IsPidModeHost bool
@@ -191,7 +582,42 @@ type ContainerInspectInfo struct {
 	Platform      string
 }
 
+// RunDebugger runs the debugger in the target until it exits. With
+// FollowRestarts the debugger is created again each time the target restarts.
 func RunDebugger(ctx context.Context, client DebuggerClient, opts *ExecOptions, cliStream *iocli.CliStream) error {
+	if opts.Detach && opts.FollowRestarts {
+		return fmt.Errorf("restarts of the target can't be followed by detached sessions")
+	}
+	if opts.Process != "" && !opts.Share.Mnt {
+		return fmt.Errorf("--process needs the pid and mnt namespaces of the target, the command runs in the root of the process")
+	}
+	if opts.InheritCwd && !opts.Share.Mnt {
+		return fmt.Errorf("--inherit-cwd needs the pid and mnt namespaces of the target, the working directory is in its root")
+	}
+	if opts.InheritEnv && !opts.Share.Pid {
+		return fmt.Errorf("--inherit-env needs the pid namespace of the target, the environment is read from its process")
+	}
+	name := opts.Name
+	for {
+		err := runDebugger(ctx, client, opts, cliStream)
+		var exitErr *TargetExitError
+		if !opts.FollowRestarts || !errors.As(err, &exitErr) {
+			return err
+		}
+		cliStream.PrintAux("\nTarget %q %s, waiting for it to restart...\n", opts.Target, exitErr.Exit)
+		if err := waitTargetRestart(ctx, client, opts.Target); err != nil {
+			return err
+		}
+		opts.Name = name
+	}
+}
+
+func runDebugger(ctx context.Context, client DebuggerClient, opts *ExecOptions, cliStream *iocli.CliStream) error {
+	sessionClient, ok := client.(SessionClient)
+	if opts.Detach && !ok {
+		return fmt.Errorf("detached sessions are not supported for %s targets", opts.Schema)
+	}
+
 	targetContainerInfo, err := client.GetContainerInfo(ctx, opts.Target)
 	if err != nil {
 		return err
@@ -223,7 +649,7 @@ func RunDebugger(ctx context.Context, client DebuggerClient, opts *ExecOptions,
 
 	cliStream.PrintAux("Pulling debugger image: %q\n", opts.DbgImg)
 
-	if err := client.PullImage(ctx, opts.DbgImg, opts.Runtime); err != nil {
+	if err := client.PullImage(ctx, opts.DbgImg, targetContainerInfo.Platform); err != nil {
 		return fmt.Errorf("failed to pull debugger image: %w", err)
 	}
 
@@ -240,7 +666,26 @@ func RunDebugger(ctx context.Context, client DebuggerClient, opts *ExecOptions,
 		targetPID = targetContainerInfo.Pid
 	}
 
-	entrypointStr := generateEntrypoint(debID, targetPID, opts.Command, isRoot, opts.AditionalPackages)
+	command := commandArgs(opts.Command, opts.Shell)
+	if opts.Script != nil {
+		command = append([]string{"sh", scriptPath(debID, opts.Share.Mnt)}, opts.Command...)
+	}
+	entrypointStr := generateEntrypoint(entrypointOptions{
+		RunID:       debID,
+		TargetPID:   targetPID,
+		Process:     opts.Process,
+		Command:     command,
+		IsRoot:      isRoot,
+		Tty:         opts.Tty,
+		Packages:    opts.AditionalPackages,
+		Timeout:     opts.Timeout,
+		IdleTimeout: opts.IdleTimeout,
+		Script:      opts.Script,
+		Chroot:      opts.Share.Mnt,
+		Env:         opts.Env,
+		InheritEnv:  opts.InheritEnv,
+		InheritCwd:  opts.InheritCwd,
+	})
 
 	// TODO: There is a issue can't add user addgroup: number 65532 is not in 0..60000 range; adduser: number 65532 is not in 0..60000 range
 	_ = changeuserScript
@@ -248,15 +693,152 @@ func RunDebugger(ctx context.Context, client DebuggerClient, opts *ExecOptions,
 	// 	entrypointStr = changeuserScript + entrypointStr
 	// }
 
+	labels := SessionLabels(debID, targetContainerInfo.ID, command, user, time.Now())
+
 	// create debugger container
-	debugerID, err := client.CreateContainer(ctx, targetContainerInfo, opts.DbgImg, entrypointStr, user, opts.Name, opts.Tty, opts.Stdin, opts.mountDir)
+	debugerID, err := client.CreateContainer(ctx, targetContainerInfo, opts.DbgImg, entrypointStr, user, opts.Name, labels, opts.Tty, opts.Stdin, opts.mountDir)
 	if err != nil {
 		return fmt.Errorf("failed to create debugger container: %w", err)
 	}
+	if opts.Timeout > 0 {
+		cliStream.PrintAux("The session ends after %s\n", opts.Timeout)
+	}
+	if opts.IdleTimeout > 0 && opts.Tty {
+		cliStream.PrintAux("The session ends when idle for %s\n", opts.IdleTimeout)
+	} else if opts.IdleTimeout > 0 {
+		cliStream.PrintAux("The idle limit of %s is not enforced without a TTY\n", opts.IdleTimeout)
+	}
+	event := auditEvent(ctx, client, opts, debID, targetContainerInfo, command)
+	if opts.Detach {
+		if err := sessionClient.StartContainer(ctx, debugerID); err != nil {
+			return fmt.Errorf("failed to start debugger container: %w", err)
+		}
+		event.Event = audit.EventStart
+		opts.Audit.Log(event)
+		cliStream.PrintAux("Debugger session started, reattach with:\n")
+		printReattach(opts.Schema, opts.Name, cliStream)
+		return nil
+	}
 	cliStream.PrintAux("Debugger container created: %v\n>>\n", debugerID)
-	client.AttachContainer(ctx, debugerID, opts.Tty, opts.Stdin, cliStream)
+	if signalClient, ok := client.(SignalClient); ok && !opts.Tty {
+		defer forwardSignals(ctx, signalClient, debugerID, cliStream)()
+	}
+	if opts.Timeout > 0 {
+		defer enforceTimeout(ctx, client, debugerID, opts.Timeout+timeoutGrace, cliStream)()
+	}
+	event.Event = audit.EventStart
+	opts.Audit.Log(event)
+	err = attachWatchingTarget(ctx, client, debugerID, targetContainerInfo.ID, opts, cliStream)
+	auditEnd(opts.Audit, event, err)
+	if errors.Is(err, iocli.ErrDetached) {
+		cliStream.PrintAux("\nDetached from the debugger session, reattach with:\n")
+		printReattach(opts.Schema, opts.Name, cliStream)
+		return nil
+	}
+	return err
+}
+
+// printReattach prints the command reattaching to the session, on stdout so
+// scripts can use it.
+func printReattach(schema, session string, cliStream *iocli.CliStream) {
+	cliStream.PrintOut("conxec attach %s%s\n", schema, session)
+}
+
+// forwardSignals passes SIGINT, SIGTERM and SIGHUP to the debugger until stop
+// is called. With a TTY they are not needed, the terminal of the debugger
+// gets the keys.
+func forwardSignals(ctx context.Context, client SignalClient, containerID string, cliStream *iocli.CliStream) (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				if err := client.SignalContainer(ctx, containerID, sig.(syscall.Signal)); err != nil {
+					cliStream.PrintAux("failed to pass %s to the debugger: %s\n", sig, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+// enforceTimeout stops the debugger through the runtime when it still runs
+// after the deadline, its watchdog can be killed from the session.
+func enforceTimeout(ctx context.Context, client DebuggerClient, containerID string, deadline time.Duration, cliStream *iocli.CliStream) (stop func()) {
+	timer := time.AfterFunc(deadline, func() {
+		cliStream.PrintAux("\nThe debugger is still running after the time limit of the session, stopping it\n")
+		ctx := context.WithoutCancel(ctx)
+		var err error
+		if manager, ok := client.(SessionManager); ok {
+			err = manager.StopSession(ctx, containerID)
+		} else if signalClient, ok := client.(SignalClient); ok {
+			err = signalClient.SignalContainer(ctx, containerID, syscall.SIGKILL)
+		} else {
+			err = fmt.Errorf("the runtime can't stop it")
+		}
+		if err != nil {
+			cliStream.PrintAux("failed to stop the debugger: %s\n", err)
+		}
+	})
+	return func() { timer.Stop() }
+}
 
-	return nil
+// AttachSession reattaches to a detached debugger session, the target of the
+// options is the name or the ID of the debugger container.
+func AttachSession(ctx context.Context, client DebuggerClient, opts *ExecOptions, cliStream *iocli.CliStream) error {
+	sessionClient, ok := client.(SessionClient)
+	if !ok {
+		return fmt.Errorf("detached sessions are not supported by this runtime")
+	}
+	readOnly := opts.ReadOnly
+	if readOnly {
+		printReadOnly(ctx, client, opts, cliStream)
+	}
+	manager, _ := client.(SessionManager)
+	event := sessionAuditEvent(ctx, manager, opts)
+	for {
+		err := sessionClient.AttachSession(ctx, opts.Target, readOnly, cliStream)
+		if readOnly && errors.Is(err, iocli.ErrTakeControl) {
+			cliStream.PrintAux("\nTaking control of the debugger session\n")
+			readOnly = false
+			continue
+		}
+		// the watchers see the end of the session too, it is logged once by
+		// the attach in control
+		if !readOnly {
+			auditEnd(opts.Audit, event, err)
+		}
+		if errors.Is(err, iocli.ErrDetached) {
+			cliStream.PrintAux("\nDetached from the debugger session, reattach with:\n")
+			printReattach(opts.Schema, opts.Target, cliStream)
+			return nil
+		}
+		return err
+	}
+}
+
+// printReadOnly tells the user of a read-only session the size of its TTY,
+// the output is drawn for it, and how to take control.
+func printReadOnly(ctx context.Context, client DebuggerClient, opts *ExecOptions, cliStream *iocli.CliStream) {
+	cliStream.PrintAux("Attaching read-only, the input is not sent to the debugger\n")
+	if sizer, ok := client.(TerminalSizer); ok {
+		height, width, err := sizer.SessionTerminalSize(ctx, opts.Target)
+		if err != nil {
+			cliStream.PrintAux("failed to get the terminal size of the session: %s\n", err)
+		} else if localHeight, localWidth := cliStream.OutputStream().GetTtySize(); localHeight != height || localWidth != width {
+			cliStream.PrintAux("The terminal of the session is %dx%d, resize yours to match it\n", width, height)
+		}
+	}
+	if opts.controlKeys != "" {
+		cliStream.PrintAux("Type %s to take control of the session\n", opts.controlKeys)
+	}
 }
 
 // Util functions
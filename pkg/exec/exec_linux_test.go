@@ -0,0 +1,180 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPty returns the master and the slave of a new pseudo-terminal.
+func openPty(t *testing.T) (ptmx, tty *os.File) {
+	t.Helper()
+	ptmx, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminal: %v", err)
+	}
+	t.Cleanup(func() { ptmx.Close() })
+	if err := unix.IoctlSetPointerInt(int(ptmx.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatal(err)
+	}
+	n, err := unix.IoctlGetInt(int(ptmx.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tty, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tty.Close() })
+	return ptmx, tty
+}
+
+func TestTerminalSizeCommand(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the entrypoint chroots, it needs root")
+	}
+	if _, err := os.Stat("/proc/self/root"); err != nil {
+		t.Skip("/proc is not available")
+	}
+	// the debugger shares the PID namespace of the target, PID 1 is not the
+	// entrypoint and has another stdin, or none
+	ptmx, tty := openPty(t)
+	if err := unix.IoctlSetWinsize(int(ptmx.Fd()), unix.TIOCSWINSZ, &unix.Winsize{Row: 33, Col: 101}); err != nil {
+		t.Fatal(err)
+	}
+	runID := getShortRandomID()
+	entrypoint := generateEntrypoint(entrypointOptions{RunID: runID, TargetPID: os.Getpid(), Command: []string{"sleep", "30"}, IsRoot: true, Tty: true, Chroot: true})
+	debugger := osexec.Command("sh", "-c", entrypoint)
+	debugger.Stdin, debugger.Stdout, debugger.Stderr = tty, tty, tty
+	if err := debugger.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		syscall.Kill(debugger.Process.Pid, syscall.SIGKILL)
+		debugger.Wait()
+		os.Remove(entrypointPIDFile)
+		os.Remove("/tmp/.conxec-entrypoint.sh")
+		os.Remove("/tmp/.conxec-command.pid")
+	})
+
+	var out []byte
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		out, err = osexec.Command(TerminalSizeCommand[0], TerminalSizeCommand[1:]...).CombinedOutput()
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("TerminalSizeCommand error = %v: %s", err, out)
+	}
+	height, width, err := ParseTerminalSize(string(out))
+	if err != nil || height != 33 || width != 101 {
+		t.Errorf("terminal size = %d, %d, %v, want 33, 101 (%q)", height, width, err, strings.TrimSpace(string(out)))
+	}
+}
+
+// TestEntrypointTerminalSignals runs the -t entrypoint on the controlling
+// terminal of a new session, as a runtime does, the signals must reach the
+// command and the artefacts be removed.
+func TestEntrypointTerminalSignals(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the entrypoint chroots, it needs root")
+	}
+	if _, err := os.Stat("/proc/self/root"); err != nil {
+		t.Skip("/proc is not available")
+	}
+	tests := []struct {
+		name   string
+		signal func(t *testing.T, debugger *osexec.Cmd, ptmx *os.File)
+		want   string
+		status int
+	}{{
+		// docker stop, shells ignore SIGTERM, the command gets SIGHUP
+		name: "stop",
+		signal: func(t *testing.T, debugger *osexec.Cmd, _ *os.File) {
+			if err := debugger.Process.Signal(syscall.SIGTERM); err != nil {
+				t.Fatal(err)
+			}
+		},
+		want:   "HUP",
+		status: 5,
+	}, {
+		name: "interrupt",
+		signal: func(t *testing.T, _ *osexec.Cmd, ptmx *os.File) {
+			if _, err := ptmx.Write([]byte{3}); err != nil {
+				t.Fatal(err)
+			}
+		},
+		want:   "INT",
+		status: 6,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			script := filepath.Join(dir, "cmd.sh")
+			if err := os.WriteFile(script, []byte(`trap 'echo HUP > `+dir+`/signal; exit 5' HUP
+trap 'echo INT > `+dir+`/signal; exit 6' INT
+touch `+dir+`/ready
+while :; do sleep 0.1; done
+`), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			ptmx, tty := openPty(t)
+			// the terminal output is drained for the writes not to block
+			go io.Copy(io.Discard, ptmx)
+			runID := getShortRandomID()
+			entrypoint := generateEntrypoint(entrypointOptions{RunID: runID, TargetPID: os.Getpid(), Command: []string{"sh", script}, IsRoot: true, Tty: true, Chroot: true})
+			debugger := osexec.Command("sh", "-c", entrypoint)
+			debugger.Stdin, debugger.Stdout, debugger.Stderr = tty, tty, tty
+			debugger.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+			if err := debugger.Start(); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				syscall.Kill(debugger.Process.Pid, syscall.SIGKILL)
+				os.Remove(entrypointPIDFile)
+				os.Remove("/tmp/.conxec-entrypoint.sh")
+				os.Remove("/tmp/.conxec-command.pid")
+			})
+			for i := 0; i < 100; i++ {
+				if _, err := os.Stat(filepath.Join(dir, "ready")); err == nil {
+					break
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+			artefacts, _ := filepath.Glob("/tmp/.conxec-*-" + runID)
+			if len(artefacts) != 3 {
+				t.Fatalf("artefacts = %v, want the 3 symlinks", artefacts)
+			}
+
+			tt.signal(t, debugger, ptmx)
+			done := make(chan error, 1)
+			go func() { done <- debugger.Wait() }()
+			select {
+			case err := <-done:
+				var exitErr *osexec.ExitError
+				if !errors.As(err, &exitErr) || exitErr.ExitCode() != tt.status {
+					t.Errorf("entrypoint error = %v, want the exit status %d of the command", err, tt.status)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("entrypoint did not exit")
+			}
+			if got, _ := os.ReadFile(filepath.Join(dir, "signal")); strings.TrimSpace(string(got)) != tt.want {
+				t.Errorf("command got %q, want SIG%s", got, tt.want)
+			}
+			if artefacts, _ := filepath.Glob("/tmp/.conxec-*-" + runID); len(artefacts) != 0 {
+				t.Errorf("artefacts %v were not removed", artefacts)
+			}
+		})
+	}
+}
@@ -1,9 +1,23 @@
 package exec
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/debasishbsws/conxec/pkg/audit"
+	"github.com/debasishbsws/conxec/pkg/iocli"
 )
 
 // Test entrypoint string creation for exec command
@@ -44,7 +58,7 @@ func TestGenerateEntrypoint(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Call function
-			got := generateEntrypoint(tt.runID, tt.targetPID, tt.cmd, true, []string{})
+			got := generateEntrypoint(entrypointOptions{RunID: tt.runID, TargetPID: tt.targetPID, Command: tt.cmd, IsRoot: true, Chroot: true})
 			fmt.Printf("got: %s\n", got)
 
 			// Check for panic
@@ -60,47 +74,1082 @@ func TestGenerateEntrypoint(t *testing.T) {
 	}
 }
 
-func TestShellescape(t *testing.T) {
-	// Define test cases
-	tests := []struct {
+// hostileArgs are arguments sh would split, expand or run when they are not
+// quoted, marker is created when one of them runs.
+func hostileArgs(marker string) []struct {
+	name string
+	arg  string
+} {
+	return []struct {
 		name string
-		args []string
-		want []string
+		arg  string
 	}{
-		{
-			name: "No arguments",
-			args: []string{},
-			want: []string{},
-		},
-		{
-			name: "Single argument without spaces",
-			args: []string{"arg"},
-			want: []string{"\"arg\""},
-		},
-		{
-			name: "Single argument with spaces",
-			args: []string{"arg with spaces"},
-			want: []string{"\"arg with spaces\""},
-		},
-		{
-			name: "Multiple arguments",
-			args: []string{"arg1", "arg2 \n space", "arg3"},
-			want: []string{"\"arg1\"", "\"arg2 space\"", "\"arg3\""},
-		},
-		{
-			name: "Arguments with special characters",
-			args: []string{"arg1$", "arg2&", "arg3*"},
-			want: []string{"\"arg1$\"", "\"arg2&\"", "\"arg3*\""},
-		},
+		{name: "empty", arg: ""},
+		{name: "spaces", arg: "arg with  spaces"},
+		{name: "tab and newline", arg: "tab\there\nnew line\n"},
+		{name: "single quote", arg: "it's"},
+		{name: "lone single quote", arg: "'"},
+		{name: "double quotes", arg: `say "hi"`},
+		{name: "backslashes", arg: `a\b\\c\`},
+		{name: "variable", arg: "$HOME ${PATH}"},
+		{name: "command substitution", arg: "$(touch " + marker + ")"},
+		{name: "backticks", arg: "`touch " + marker + "`"},
+		{name: "command separators", arg: "a; touch " + marker + " && b || c"},
+		{name: "pipe and redirections", arg: "a | b > " + marker + " < c"},
+		{name: "background", arg: "touch " + marker + " &"},
+		{name: "globs", arg: "* ? [a-z]"},
+		{name: "braces and tilde", arg: "~ {a,b}"},
+		{name: "comment", arg: "# not a comment"},
+		{name: "option", arg: "-n"},
+		{name: "heredoc delimiter", arg: "EOF"},
+		{name: "unicode", arg: "héllo wörld ✓"},
 	}
+}
 
-	// Run test cases
+// TestShellquote runs sh on the quoted arguments, it must get them as is.
+func TestShellquote(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "pwned")
+	for _, tt := range hostileArgs(marker) {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := osexec.Command("sh", "-c", `set -- `+shellquote(tt.arg)+`; printf '%s' "$1"`).Output()
+			if err != nil {
+				t.Fatalf("sh error = %v", err)
+			}
+			if string(out) != tt.arg {
+				t.Errorf("sh got %q, want %q", out, tt.arg)
+			}
+		})
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("an argument was run by sh")
+	}
+}
+
+func TestCommandArgs(t *testing.T) {
+	tests := []struct {
+		name  string
+		cmd   []string
+		shell bool
+		want  []string
+	}{
+		{name: "no command", want: []string{"sh"}},
+		{name: "no command with shell", shell: true, want: []string{"sh"}},
+		{name: "argv", cmd: []string{"ls", "-l", "a b"}, want: []string{"ls", "-l", "a b"}},
+		{name: "shell", cmd: []string{"ls", "-l", "|", "grep", "a b"}, shell: true, want: []string{"sh", "-c", "ls -l | grep a b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := commandArgs(tt.cmd, tt.shell); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("commandArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeClient records the calls of RunDebugger, sessions are supported when
+// it is wrapped in fakeSessionClient.
+type fakeClient struct {
+	platform  string // platform is the one of the target
+	pulled    string // pulled is the platform the debugger image was pulled for
+	created   string
+	labels    map[string]string
+	attached  bool
+	started   bool
+	attachErr error
+	// readOnly are the modes of the attaches of sessions
+	readOnly []bool
+}
+
+func (c *fakeClient) GetContainerInfo(ctx context.Context, containerName string) (*ContainerInspectInfo, error) {
+	return &ContainerInspectInfo{ID: containerName, Isrunning: true, User: "root", Platform: c.platform}, nil
+}
+
+func (c *fakeClient) PullImage(ctx context.Context, image string, platform string) error {
+	c.pulled = platform
+	return nil
+}
+
+func (c *fakeClient) CreateContainer(ctx context.Context, targetInspect *ContainerInspectInfo,
+	image, entrypoint, user, containerName string, labels map[string]string,
+	tty, stdin bool, mountDir string,
+) (string, error) {
+	c.created = containerName
+	c.labels = labels
+	return containerName, nil
+}
+
+func (c *fakeClient) AttachContainer(ctx context.Context, containerID string, tty, stdin bool, cliStream *iocli.CliStream) error {
+	c.attached = true
+	return c.attachErr
+}
+
+type fakeSessionClient struct {
+	*fakeClient
+}
+
+func (c fakeSessionClient) StartContainer(ctx context.Context, containerID string) error {
+	c.started = true
+	return nil
+}
+
+// AttachSession fails with attachErr when read-only.
+func (c fakeSessionClient) AttachSession(ctx context.Context, containerID string, readOnly bool, cliStream *iocli.CliStream) error {
+	c.attached = true
+	c.readOnly = append(c.readOnly, readOnly)
+	if readOnly {
+		return c.attachErr
+	}
+	return nil
+}
+
+func (c fakeSessionClient) SessionTerminalSize(ctx context.Context, containerID string) (uint, uint, error) {
+	return 40, 120, nil
+}
+
+// fakeSignalClient raises SIGHUP in conxec while attached, and waits for it
+// to be passed to the debugger.
+type fakeSignalClient struct {
+	*fakeClient
+	signals chan syscall.Signal
+}
+
+func (c fakeSignalClient) AttachContainer(ctx context.Context, containerID string, tty, stdin bool, cliStream *iocli.CliStream) error {
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		return err
+	}
+	select {
+	case sig := <-c.signals:
+		return fmt.Errorf("debugger got %v", sig)
+	case <-time.After(5 * time.Second):
+		return fmt.Errorf("debugger got no signal")
+	}
+}
+
+func (c fakeSignalClient) SignalContainer(ctx context.Context, containerID string, sig syscall.Signal) error {
+	c.signals <- sig
+	return nil
+}
+
+func TestRunDebuggerSignals(t *testing.T) {
+	opts, err := New([]Option{
+		WithTarget("app"),
+		WithName("conxec-debugger-test"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard)
+
+	client := fakeSignalClient{&fakeClient{}, make(chan syscall.Signal, 1)}
+	err = RunDebugger(context.Background(), client, opts, cliStream)
+	if err == nil || err.Error() != "debugger got hangup" {
+		t.Errorf("RunDebugger() error = %v, want the debugger to get SIGHUP", err)
+	}
+}
+
+func TestRunDebuggerDetach(t *testing.T) {
+	opts, err := New([]Option{
+		WithTarget("app"),
+		WithName("conxec-debugger-test"),
+		WithDetach(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Schema = "docker://"
+
+	var out bytes.Buffer
+	cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), &out, io.Discard)
+
+	if err := RunDebugger(context.Background(), &fakeClient{}, opts, cliStream); err == nil {
+		t.Errorf("RunDebugger() succeeded without session support")
+	}
+
+	client := fakeSessionClient{&fakeClient{}}
+	if err := RunDebugger(context.Background(), client, opts, cliStream); err != nil {
+		t.Fatalf("RunDebugger() error = %v", err)
+	}
+	if !client.started || client.attached {
+		t.Errorf("debugger started = %v, attached = %v, want started only", client.started, client.attached)
+	}
+	if got, want := out.String(), "conxec attach docker://conxec-debugger-test\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestAttachSessionReadOnly(t *testing.T) {
+	tests := []struct {
+		name         string
+		controlKeys  string
+		attachErr    error
+		wantReadOnly []bool
+	}{
+		{name: "watch", wantReadOnly: []bool{true}},
+		{name: "take control", controlKeys: "ctrl-p,ctrl-t", attachErr: iocli.ErrTakeControl, wantReadOnly: []bool{true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := New([]Option{
+				WithTarget("conxec-debugger-test"),
+				WithReadOnly(true),
+				WithControlKeys(tt.controlKeys),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var aux bytes.Buffer
+			cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, &aux)
+			client := fakeSessionClient{&fakeClient{attachErr: tt.attachErr}}
+			if err := AttachSession(context.Background(), client, opts, cliStream); err != nil {
+				t.Fatalf("AttachSession() error = %v", err)
+			}
+			if !reflect.DeepEqual(client.readOnly, tt.wantReadOnly) {
+				t.Errorf("read-only attaches = %v, want %v", client.readOnly, tt.wantReadOnly)
+			}
+			if !strings.Contains(aux.String(), "The terminal of the session is 120x40") {
+				t.Errorf("output = %q, want the terminal size of the session", aux.String())
+			}
+			if got := strings.Contains(aux.String(), "Type ctrl-p,ctrl-t to take control"); got != (tt.controlKeys != "") {
+				t.Errorf("output = %q, want the control keys only when set", aux.String())
+			}
+		})
+	}
+}
+
+func TestParseTerminalSize(t *testing.T) {
+	height, width, err := ParseTerminalSize("40 120\n")
+	if err != nil || height != 40 || width != 120 {
+		t.Errorf("ParseTerminalSize() = %d, %d, %v, want 40, 120", height, width, err)
+	}
+	if _, _, err := ParseTerminalSize("stty: standard input: Not a tty\n"); err == nil {
+		t.Errorf("ParseTerminalSize() succeeded on an error")
+	}
+}
+
+func TestRunDebuggerDetachKeys(t *testing.T) {
+	opts, err := New([]Option{
+		WithTarget("app"),
+		WithName("conxec-debugger-test"),
+		WithTty(true),
+		WithStdin(true),
+		WithDetachKeys(""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opts.DetachKeys, []byte{16, 17}) {
+		t.Errorf("DetachKeys = %v, want ctrl-p,ctrl-q", opts.DetachKeys)
+	}
+	if _, err := New([]Option{WithDetachKeys("ctrl-p,shift")}); err == nil {
+		t.Errorf("New() with invalid detach keys succeeded")
+	}
+	opts.Schema = "docker://"
+
+	var out bytes.Buffer
+	cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), &out, io.Discard)
+	client := &fakeClient{attachErr: iocli.ErrDetached}
+	if err := RunDebugger(context.Background(), client, opts, cliStream); err != nil {
+		t.Fatalf("RunDebugger() error = %v, want nil once detached", err)
+	}
+	if got, want := out.String(), "conxec attach docker://conxec-debugger-test\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestRunDebuggerAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	logger, err := audit.New(audit.Config{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := New([]Option{
+		WithTarget("app"),
+		WithCommand([]string{"ps"}),
+		WithDebuggerImage(""),
+		WithAudit(logger),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Schema = "docker://"
+	cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard)
+	client := &fakeClient{attachErr: ExitStatus(2)}
+	if err := RunDebugger(context.Background(), client, opts, cliStream); err == nil {
+		t.Fatalf("RunDebugger() succeeded, want the exit status of the debugger")
+	}
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("audit log = %q, want the start and the end of the session", data)
+	}
+	var start, end audit.Event
+	if err := json.Unmarshal([]byte(lines[0]), &start); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &end); err != nil {
+		t.Fatal(err)
+	}
+	if start.Event != audit.EventStart || start.Target != "docker://app" || start.TargetID != "app" ||
+		start.DebuggerImage != defaultDebuggerImage || !reflect.DeepEqual(start.Command, []string{"ps"}) {
+		t.Errorf("start event = %+v", start)
+	}
+	if end.Event != audit.EventEnd || end.Session != start.Session || end.ExitCode == nil || *end.ExitCode != 2 {
+		t.Errorf("end event = %+v, want the exit code of the session", end)
+	}
+}
+
+func TestRunDebuggerLabels(t *testing.T) {
+	opts, err := New([]Option{
+		WithTarget("app"),
+		WithCommand([]string{"ps", "aux"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard)
+
+	client := &fakeClient{}
+	if err := RunDebugger(context.Background(), client, opts, cliStream); err != nil {
+		t.Fatalf("RunDebugger() error = %v", err)
+	}
+	session := SessionFromLabels(client.created, "running", client.labels)
+	if session.Target != "app" || session.Command != "ps aux" || session.User != "root:root" {
+		t.Errorf("session = %+v, want target app, command \"ps aux\" and user root:root", session)
+	}
+	if "conxec-debugger-"+session.RunID != client.created {
+		t.Errorf("run ID = %q, want the one of the debugger name %q", session.RunID, client.created)
+	}
+	if time.Since(session.Started) > time.Minute {
+		t.Errorf("started = %v, want now", session.Started)
+	}
+}
+
+func TestRunDebuggerPullPlatform(t *testing.T) {
+	opts, err := New([]Option{
+		WithTarget("app"),
+		WithRuntime("unix:///run/docker.sock"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard)
+
+	// the debugger image is pulled for the platform of the target, not the
+	// address of the runtime
+	client := &fakeClient{platform: "linux/arm64"}
+	if err := RunDebugger(context.Background(), client, opts, cliStream); err != nil {
+		t.Fatalf("RunDebugger() error = %v", err)
+	}
+	if client.pulled != "linux/arm64" {
+		t.Errorf("pulled platform = %q, want %q", client.pulled, "linux/arm64")
+	}
+}
+
+func TestFindArtefacts(t *testing.T) {
+	proc := t.TempDir()
+	process := func(pid, mntNs string, files ...string) string {
+		t.Helper()
+		dir := filepath.Join(proc, pid)
+		for _, d := range []string{"ns", "root/tmp"} {
+			if err := os.MkdirAll(filepath.Join(dir, d), 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Symlink(mntNs, filepath.Join(dir, "ns", "mnt")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "comm"), []byte("app-"+pid+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "cmdline"), []byte("app-"+pid+"\x00"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range files {
+			if err := os.Symlink("/proc/42/root/bin/", filepath.Join(dir, "root", "tmp", f)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dir
+	}
+	// kernel thread, no command line
+	dir := process("99", "mnt:[1]")
+	if err := os.WriteFile(filepath.Join(dir, "cmdline"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	process("100", "mnt:[1]", ".conxec-bin-1a2b3c4d", ".conxec-mount-1a2b3c4d", ".conxec-other")
+	// same mount namespace, /tmp is only looked at once
+	process("101", "mnt:[1]", ".conxec-usrbin-1a2b3c4d")
+	// not a symlink
+	dir = process("200", "mnt:[2]")
+	if err := os.WriteFile(filepath.Join(dir, "root", "tmp", ".conxec-bin-5e6f7a8b"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// symlinked /tmp
+	dir = process("300", "mnt:[3]")
+	os.RemoveAll(filepath.Join(dir, "root", "tmp"))
+	if err := os.Symlink("/tmp", filepath.Join(dir, "root", "tmp")); err != nil {
+		t.Fatal(err)
+	}
+
+	artefacts, err := FindArtefacts(proc)
+	if err != nil {
+		t.Fatalf("FindArtefacts() error = %v", err)
+	}
+	want := []Artefact{
+		{Path: filepath.Join(proc, "100", "root", "tmp", ".conxec-bin-1a2b3c4d"), RunID: "1a2b3c4d", Pid: 100, Command: "app-100"},
+		{Path: filepath.Join(proc, "100", "root", "tmp", ".conxec-mount-1a2b3c4d"), RunID: "1a2b3c4d", Pid: 100, Command: "app-100"},
+	}
+	if !reflect.DeepEqual(artefacts, want) {
+		t.Errorf("FindArtefacts() = %+v, want %+v", artefacts, want)
+	}
+}
+
+func TestEntrypointSignals(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the entrypoint chroots, it needs root")
+	}
+	if _, err := os.Stat("/proc/self/root"); err != nil {
+		t.Skip("/proc is not available")
+	}
+	// the target is this process, its root is the one of the host
+	dir := t.TempDir()
+	script := filepath.Join(dir, "cmd.sh")
+	if err := os.WriteFile(script, []byte(`trap 'echo TERM > `+dir+`/signal; exit 3' TERM
+touch `+dir+`/ready
+while :; do sleep 0.1; done
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runID := getShortRandomID()
+	entrypoint := generateEntrypoint(entrypointOptions{RunID: runID, TargetPID: os.Getpid(), Command: []string{"sh", script}, IsRoot: true, Chroot: true})
+	t.Cleanup(func() {
+		os.Remove("/tmp/.conxec-entrypoint.sh")
+		os.Remove("/tmp/.conxec-command.pid")
+	})
+
+	cmd := osexec.Command("sh", "-c", entrypoint)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(filepath.Join(dir, "ready")); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	artefacts, _ := filepath.Glob("/tmp/.conxec-*-" + runID)
+	if len(artefacts) != 3 {
+		t.Fatalf("artefacts = %v, want the 3 symlinks", artefacts)
+	}
+
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		// the sh -c running the command is killed when it does not exec it
+		var exitErr *osexec.ExitError
+		if !errors.As(err, &exitErr) || (exitErr.ExitCode() != 3 && exitErr.ExitCode() != 128+int(syscall.SIGTERM)) {
+			t.Errorf("entrypoint error = %v, want the exit status of the command", err)
+		}
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("entrypoint did not exit")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "signal")); err != nil {
+		t.Errorf("command did not get SIGTERM: %v", err)
+	}
+	if artefacts, _ := filepath.Glob("/tmp/.conxec-*-" + runID); len(artefacts) != 0 {
+		t.Errorf("artefacts %v were not removed", artefacts)
+	}
+}
+
+// TestEntrypointArgs runs the hostile arguments through the entrypoint and
+// the chroot, the command must get them as is.
+func TestEntrypointArgs(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the entrypoint chroots, it needs root")
+	}
+	if _, err := os.Stat("/proc/self/root"); err != nil {
+		t.Skip("/proc is not available")
+	}
+	marker := filepath.Join(t.TempDir(), "pwned")
+	var want []string
+	for _, tt := range hostileArgs(marker) {
+		want = append(want, tt.arg)
+	}
+	runID := getShortRandomID()
+	entrypoint := generateEntrypoint(entrypointOptions{RunID: runID, TargetPID: os.Getpid(), Command: append([]string{"printf", `%s\0`}, want...), IsRoot: true, Chroot: true})
+	t.Cleanup(func() {
+		os.Remove("/tmp/.conxec-entrypoint.sh")
+		os.Remove("/tmp/.conxec-command.pid")
+	})
+
+	out, err := osexec.Command("sh", "-c", entrypoint).Output()
+	if err != nil {
+		t.Fatalf("entrypoint error = %v", err)
+	}
+	got := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("command args = %q, want %q", got, want)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("an argument was run by sh")
+	}
+}
+
+func TestEntrypointScript(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the entrypoint chroots, it needs root")
+	}
+	if _, err := os.Stat("/proc/self/root"); err != nil {
+		t.Skip("/proc is not available")
+	}
+	// the script is not expanded by the entrypoint and needs no final newline
+	script := []byte(`echo "$0: $# args, 1=$1 2=$2" '$HOME'
+case $PATH in *:/tmp/.conxec-bin-*) echo path ;; esac
+exit 7`)
+	runID := getShortRandomID()
+	cmd := []string{"sh", scriptPath(runID, true), "a b", "it's"}
+	entrypoint := generateEntrypoint(entrypointOptions{RunID: runID, TargetPID: os.Getpid(), Command: cmd, IsRoot: true, Script: script, Chroot: true})
+	t.Cleanup(func() {
+		os.Remove("/tmp/.conxec-entrypoint.sh")
+		os.Remove("/tmp/.conxec-command.pid")
+		os.Remove("/tmp/.conxec-script.sh")
+	})
+
+	out, err := osexec.Command("sh", "-c", entrypoint).Output()
+	var exitErr *osexec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 7 {
+		t.Errorf("entrypoint error = %v, want the exit status of the script", err)
+	}
+	want := scriptPath(runID, true) + ": 2 args, 1=a b 2=it's $HOME\npath\n"
+	if string(out) != want {
+		t.Errorf("script output = %q, want %q", out, want)
+	}
+	if artefacts, _ := filepath.Glob("/tmp/.conxec-*-" + runID); len(artefacts) != 0 {
+		t.Errorf("artefacts %v were not removed", artefacts)
+	}
+}
+
+func TestWithScript(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  []byte
+		wantErr bool
+	}{
+		{name: "no script"},
+		{name: "script", script: []byte("echo ok\n")},
+		{name: "binary", script: []byte("\x7fELF\x00"), wantErr: true},
+		{name: "too large", script: bytes.Repeat([]byte("#\n"), maxScriptSize), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := New([]Option{WithScript(tt.script)})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WithScript() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(opts.Script, tt.script) {
+				t.Errorf("script = %q, want %q", opts.Script, tt.script)
+			}
+		})
+	}
+}
+
+func TestParseShare(t *testing.T) {
+	tests := []struct {
+		namespaces string
+		want       Share
+		wantErr    bool
+	}{
+		{namespaces: "net,pid,mnt", want: DefaultShare},
+		{namespaces: "net", want: Share{Net: true}},
+		{namespaces: "net, ipc", want: Share{Net: true, IPC: true}},
+		{namespaces: "net,pid,ipc,uts,mnt", want: Share{Net: true, Pid: true, IPC: true, UTS: true, Mnt: true}},
+		{namespaces: "pid,pid", want: Share{Pid: true}},
+		{namespaces: "net,mnt", wantErr: true},
+		{namespaces: "net,user", wantErr: true},
+		{namespaces: ",", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.namespaces, func(t *testing.T) {
+			got, err := ParseShare(tt.namespaces)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseShare() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseShare() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEntrypointWithoutChroot(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the entrypoint writes to /tmp, it needs root")
+	}
+	script := []byte(`echo "$MNTD $1"` + "\n")
+	runID := getShortRandomID()
+	cmd := []string{"sh", scriptPath(runID, false), "arg"}
+	entrypoint := generateEntrypoint(entrypointOptions{RunID: runID, TargetPID: os.Getpid(), Command: cmd, IsRoot: true, Script: script})
+	t.Cleanup(func() {
+		os.Remove("/tmp/.conxec-entrypoint.sh")
+		os.Remove("/tmp/.conxec-command.pid")
+		os.Remove("/tmp/.conxec-script.sh")
+	})
+
+	out, err := osexec.Command("sh", "-c", entrypoint).Output()
+	if err != nil {
+		t.Fatalf("entrypoint error = %v", err)
+	}
+	if got, want := string(out), "/work arg\n"; got != want {
+		t.Errorf("script output = %q, want %q", got, want)
+	}
+	if artefacts, _ := filepath.Glob("/tmp/.conxec-*-" + runID); len(artefacts) != 0 {
+		t.Errorf("artefacts %v were created without chroot", artefacts)
+	}
+}
+
+func TestEntrypointProcess(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the entrypoint chroots, it needs root")
+	}
+	if _, err := os.Stat("/proc/self/root"); err != nil {
+		t.Skip("/proc is not available")
+	}
+	sleep, err := osexec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep is not available")
+	}
+	// the processes of the target are copies of sleep with a name of their own
+	dir := t.TempDir()
+	name := "conxec" + getShortRandomID()[:6]
+	bin := filepath.Join(dir, name)
+	data, err := os.ReadFile(sleep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bin, data, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var pids []string
+	for _, arg := range []string{"3601", "3602"} {
+		process := osexec.Command(bin, arg)
+		process.Dir = dir
+		if err := process.Start(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			process.Process.Kill()
+			process.Wait()
+		})
+		pids = append(pids, fmt.Sprint(process.Process.Pid))
+	}
+	t.Cleanup(func() {
+		os.Remove("/tmp/.conxec-entrypoint.sh")
+		os.Remove("/tmp/.conxec-command.pid")
+	})
+
+	tests := []struct {
+		name    string
+		process string
+		wantErr string
+	}{
+		{name: "pid", process: pids[0]},
+		{name: "regex", process: name + " 360[1]$"},
+		{name: "ambiguous", process: name, wantErr: "2 processes of the target match"},
+		{name: "no match", process: name + "x", wantErr: "no process of the target matches"},
+		{name: "no pid", process: "999999999", wantErr: "no process 999999999"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runID := getShortRandomID()
+			entrypoint := generateEntrypoint(entrypointOptions{RunID: runID, TargetPID: os.Getpid(), Process: tt.process, Command: []string{"pwd"}, IsRoot: true, Chroot: true})
+			var stderr bytes.Buffer
+			command := osexec.Command("sh", "-c", entrypoint)
+			command.Stderr = &stderr
+			out, err := command.Output()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(stderr.String(), tt.wantErr) {
+					t.Errorf("entrypoint error = %v, stderr %q, want %q", err, stderr.String(), tt.wantErr)
+				}
+				if tt.name == "ambiguous" && (!strings.Contains(stderr.String(), pids[0]) || !strings.Contains(stderr.String(), pids[1])) {
+					t.Errorf("stderr %q does not list the processes %v", stderr.String(), pids)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("entrypoint error = %v, stderr %q", err, stderr.String())
+			}
+			if got := strings.TrimSpace(string(out)); got != dir {
+				t.Errorf("working directory = %q, want %q", got, dir)
+			}
+			if artefacts, _ := filepath.Glob("/tmp/.conxec-*-" + runID); len(artefacts) != 0 {
+				t.Errorf("artefacts %v were not removed", artefacts)
+			}
+		})
+	}
+}
+
+func TestWithProcess(t *testing.T) {
+	for _, tt := range []struct {
+		process string
+		wantErr bool
+	}{
+		{process: ""},
+		{process: "1"},
+		{process: "nginx: worker"},
+		{process: "java .*App"},
+		{process: "worker [[:digit:]]+$"},
+		{process: "(unclosed", wantErr: true},
+		// grep -E does not know the RE2 syntax
+		{process: `worker \d+`, wantErr: true},
+		{process: "(?i)java", wantErr: true},
+	} {
+		opts, err := New([]Option{WithProcess(tt.process)})
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("WithProcess(%q) error = %v, wantErr %v", tt.process, err, tt.wantErr)
+		}
+		if err == nil && opts.Process != tt.process {
+			t.Errorf("process = %q, want %q", opts.Process, tt.process)
+		}
+	}
+}
+
+func TestEntrypointEnv(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the entrypoint chroots, it needs root")
+	}
+	if _, err := os.Stat("/proc/self/root"); err != nil {
+		t.Skip("/proc is not available")
+	}
+	// the process of the target has an environment and a working directory of
+	// its own
+	dir := t.TempDir()
+	target := osexec.Command("sleep", "3600")
+	target.Dir = dir
+	target.Env = []string{"PATH=/app/bin:/bin:/usr/bin", "A=inherited", "C=old", "MULTI=x\ny"}
+	if err := target.Start(); err != nil {
+		t.Skip("sleep is not available")
+	}
+	t.Cleanup(func() {
+		target.Process.Kill()
+		target.Wait()
+	})
+	t.Cleanup(func() {
+		os.Remove("/tmp/.conxec-entrypoint.sh")
+		os.Remove("/tmp/.conxec-command.pid")
+		os.Remove("/tmp/.conxec-environ")
+		os.Remove("/tmp/.conxec-env")
+	})
+	cmd := []string{"sh", "-c", `printf '%s|' "$A" "$B" "$C" "$MULTI" "${PATH%%:/tmp/*}" "$D"; pwd`}
+	// the values are taken literally, a line EOF doesn't end the heredoc of the
+	// entrypoint
+	multiline := "line1\nEOF\necho INJECTED $(echo sub) 'q' \"dq\"\nEOF"
+	env := []string{"B=$HOME `x` 'q'", "C=old", "D=" + multiline, "C=new"}
+
+	tests := []struct {
+		name       string
+		inheritEnv bool
+		inheritCwd bool
+		want       string
+	}{
+		{name: "inherit", inheritEnv: true, inheritCwd: true, want: "inherited|$HOME `x` 'q'|new|x\ny|/app/bin:/bin:/usr/bin|" + multiline + "|" + dir + "\n"},
+		// chroot starts the command in /
+		{name: "overrides", want: "|$HOME `x` 'q'|new||" + os.Getenv("PATH") + "|" + multiline + "|/\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runID := getShortRandomID()
+			entrypoint := generateEntrypoint(entrypointOptions{RunID: runID, TargetPID: target.Process.Pid, Command: cmd, IsRoot: true, Chroot: true, Env: env, InheritEnv: tt.inheritEnv, InheritCwd: tt.inheritCwd})
+			command := osexec.Command("sh", "-c", entrypoint)
+			command.Env = []string{"PATH=" + os.Getenv("PATH")}
+			out, err := command.Output()
+			if err != nil {
+				t.Fatalf("entrypoint error = %v", err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("command output = %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     []string
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "variables", env: []string{"A=1", "EMPTY=", "dotted.name=x=y"}},
+		{name: "no value", env: []string{"A"}, wantErr: true},
+		{name: "no key", env: []string{"=1"}, wantErr: true},
+		{name: "nul", env: []string{"A=1\x002"}, wantErr: true},
+	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := shellescape(tt.args)
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("shellescape() = %v, want %v", got, tt.want)
+			opts, err := New([]Option{WithEnv(tt.env)})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WithEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(opts.Env, tt.env) {
+				t.Errorf("env = %q, want %q", opts.Env, tt.env)
 			}
 		})
 	}
 }
+
+func TestEntrypointTimeout(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the entrypoint chroots, it needs root")
+	}
+	if _, err := os.Stat("/proc/self/root"); err != nil {
+		t.Skip("/proc is not available")
+	}
+	dir := t.TempDir()
+	script := filepath.Join(dir, "cmd.sh")
+	if err := os.WriteFile(script, []byte(`trap 'echo TERM > `+dir+`/signal; exit 3' TERM
+while :; do sleep 0.1; done
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runID := getShortRandomID()
+	entrypoint := generateEntrypoint(entrypointOptions{RunID: runID, TargetPID: os.Getpid(), Command: []string{"sh", script}, IsRoot: true, Timeout: 2 * time.Second, IdleTimeout: time.Second, Chroot: true})
+	t.Cleanup(func() {
+		os.Remove("/tmp/.conxec-entrypoint.sh")
+		os.Remove("/tmp/.conxec-command.pid")
+	})
+
+	cmd := osexec.Command("sh", "-c", entrypoint)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("entrypoint did not end the session")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "signal")); err != nil {
+		t.Errorf("command did not get SIGTERM: %v", err)
+	}
+	// the idle timeout only applies with a TTY
+	for _, want := range []string{"the session ends in 1s", "the session reached its time limit of 2s"} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("stderr = %q, want %q", stderr.String(), want)
+		}
+	}
+	if artefacts, _ := filepath.Glob("/tmp/.conxec-*-" + runID); len(artefacts) != 0 {
+		t.Errorf("artefacts %v were not removed", artefacts)
+	}
+}
+
+// fakeWatchClient reports the exit of the target during the first attach,
+// the debugger is attached again once the target restarted.
+type fakeWatchClient struct {
+	*fakeClient
+	attaches int
+	exits    chan TargetExit
+	killed   chan syscall.Signal
+}
+
+func (c *fakeWatchClient) WatchTarget(ctx context.Context, targetID string) (<-chan TargetExit, error) {
+	return c.exits, nil
+}
+
+func (c *fakeWatchClient) AttachContainer(ctx context.Context, containerID string, tty, stdin bool, cliStream *iocli.CliStream) error {
+	c.attaches++
+	if c.attaches > 1 {
+		return nil
+	}
+	c.exits <- TargetExit{ExitCode: 137, OOMKilled: true}
+	select {
+	case <-c.killed:
+		return ExitStatus(137)
+	case <-time.After(5 * time.Second):
+		return fmt.Errorf("debugger was not killed")
+	}
+}
+
+func (c *fakeWatchClient) SignalContainer(ctx context.Context, containerID string, sig syscall.Signal) error {
+	c.killed <- sig
+	return nil
+}
+
+func TestRunDebuggerTargetExit(t *testing.T) {
+	opts, err := New([]Option{
+		WithTarget("app"),
+		WithTty(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard)
+
+	client := &fakeWatchClient{fakeClient: &fakeClient{}, exits: make(chan TargetExit, 1), killed: make(chan syscall.Signal, 1)}
+	err = RunDebugger(context.Background(), client, opts, cliStream)
+	var exitErr *TargetExitError
+	if !errors.As(err, &exitErr) || exitErr.Exit != (TargetExit{ExitCode: 137, OOMKilled: true}) {
+		t.Fatalf("RunDebugger() error = %v, want the exit of the target", err)
+	}
+	if got, want := err.Error(), `target "app" was OOMKilled (exit code 137), the debugger session ended`; got != want {
+		t.Errorf("error = %q, want %q", got, want)
+	}
+
+	opts.FollowRestarts = true
+	opts.Name = ""
+	client = &fakeWatchClient{fakeClient: &fakeClient{}, exits: make(chan TargetExit, 1), killed: make(chan syscall.Signal, 1)}
+	if err := RunDebugger(context.Background(), client, opts, cliStream); err != nil {
+		t.Fatalf("RunDebugger() error = %v, want the debugger of the restarted target to succeed", err)
+	}
+	if client.attaches != 2 {
+		t.Errorf("debugger attached %d times, want 2", client.attaches)
+	}
+}
+
+func TestShareRequire(t *testing.T) {
+	pod := Share{Net: true, IPC: true, UTS: true}
+	if err := (Share{Net: true, Pid: true, IPC: true, UTS: true, Mnt: true}).Require(pod, "in the pod"); err != nil {
+		t.Errorf("Require() error = %v", err)
+	}
+	err := DefaultShare.Require(pod, "in the pod")
+	if err == nil || err.Error() != "in the pod, add ipc,uts to --share" {
+		t.Errorf("Require() error = %v, want the missing ipc and uts", err)
+	}
+	if opts, _ := New(nil); opts.ShareSet {
+		t.Errorf("ShareSet without WithShare")
+	}
+	if opts, _ := New([]Option{WithShare("net")}); !opts.ShareSet {
+		t.Errorf("ShareSet not set by WithShare")
+	}
+}
+
+// fakeStopClient is a debugger whose watchdog was killed from the session, it
+// only ends when the session is stopped.
+type fakeStopClient struct {
+	*fakeClient
+	stopped chan string
+}
+
+func (c *fakeStopClient) ListSessions(ctx context.Context) ([]Session, error) {
+	return []Session{{ID: "dbg-1", RunID: "1a2b3c4d", Target: "app-id", Command: "sh -c top"}}, nil
+}
+
+func (c *fakeStopClient) StopSession(ctx context.Context, id string) error {
+	c.stopped <- id
+	return nil
+}
+
+func (c *fakeStopClient) SessionLogs(ctx context.Context, id string, follow bool, stdout, stderr io.Writer) error {
+	return nil
+}
+
+func TestEnforceTimeout(t *testing.T) {
+	cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard)
+	client := &fakeStopClient{fakeClient: &fakeClient{}, stopped: make(chan string, 1)}
+	stop := enforceTimeout(context.Background(), client, "dbg-1", 10*time.Millisecond, cliStream)
+	defer stop()
+	select {
+	case id := <-client.stopped:
+		if id != "dbg-1" {
+			t.Errorf("stopped %q, want dbg-1", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the debugger was not stopped after the deadline")
+	}
+
+	// the debugger ended in time
+	enforceTimeout(context.Background(), client, "dbg-2", 50*time.Millisecond, cliStream)()
+	select {
+	case id := <-client.stopped:
+		t.Errorf("stopped %q, which ended before the deadline", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// readAudit closes the logger and returns the events of its file.
+func readAudit(t *testing.T, logger *audit.Logger, path string) []audit.Event {
+	t.Helper()
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []audit.Event
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var event audit.Event
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestStopSessionAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	logger, err := audit.New(audit.Config{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := New([]Option{WithTarget("dbg-1"), WithAudit(logger)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Schema = "docker://"
+	client := &fakeStopClient{fakeClient: &fakeClient{}, stopped: make(chan string, 1)}
+	if err := StopSession(context.Background(), client, opts); err != nil {
+		t.Fatalf("StopSession() error = %v", err)
+	}
+	if id := <-client.stopped; id != "dbg-1" {
+		t.Errorf("stopped %q, want dbg-1", id)
+	}
+
+	events := readAudit(t, logger, path)
+	want := audit.Event{Event: audit.EventStop, Session: "1a2b3c4d", Debugger: "dbg-1", Target: "docker://app-id", TargetID: "app-id", Detached: true}
+	if len(events) != 1 || events[0].Event != want.Event || events[0].Session != want.Session || events[0].Debugger != want.Debugger ||
+		events[0].Target != want.Target || events[0].TargetID != want.TargetID || !events[0].Detached ||
+		!reflect.DeepEqual(events[0].Command, []string{"sh", "-c", "top"}) {
+		t.Errorf("audit events = %+v, want %+v", events, want)
+	}
+}
+
+func TestAttachSessionAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	logger, err := audit.New(audit.Config{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := New([]Option{WithTarget("conxec-debugger-test"), WithReadOnly(true), WithAudit(logger)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard)
+	// the watcher takes control, the session then ends
+	client := fakeSessionClient{&fakeClient{attachErr: iocli.ErrTakeControl}}
+	if err := AttachSession(context.Background(), client, opts, cliStream); err != nil {
+		t.Fatalf("AttachSession() error = %v", err)
+	}
+
+	events := readAudit(t, logger, path)
+	if len(events) != 1 || events[0].Event != audit.EventEnd || events[0].Debugger != "conxec-debugger-test" ||
+		events[0].ExitCode == nil || *events[0].ExitCode != 0 {
+		t.Errorf("audit events = %+v, want the end of the session once", events)
+	}
+}
@@ -0,0 +1,400 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+	"github.com/docker/cli/cli/streams"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	// nonrootUID is the uid of the nonroot user in distroless and wolfi images.
+	nonrootUID = 65532
+
+	containerStartTimeout = 2 * time.Minute
+	containerExitTimeout  = 30 * time.Second
+)
+
+// attachFunc streams the IO of a container through the pods/attach subresource.
+type attachFunc func(ctx context.Context, namespace, pod string,
+	attachOpts *corev1.PodAttachOptions, streamOpts remotecommand.StreamOptions) error
+
+type KubernetesClient struct {
+	clientset kubernetes.Interface
+	out       *streams.Out
+	attach    attachFunc
+
+	namespace    string
+	pod          *corev1.Pod
+	container    string
+	targetUID    *int64
+	targetSecCtx *corev1.SecurityContext
+	share        exec.Share
+}
+
+// NewClient creates a client from the kubeconfig, honouring the selected
+// context and namespace. The runtime address overrides the API server.
+func NewClient(ctx context.Context, opts *exec.ExecOptions, clistream *iocli.CliStream) (*KubernetesClient, error) {
+	if opts.ShareSet {
+		if err := opts.Share.Require(exec.Share{Net: true, IPC: true, UTS: true},
+			"k8s:// debuggers are ephemeral containers, always in the network, IPC and UTS namespaces of the pod of the target"); err != nil {
+			return nil, err
+		}
+	}
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = opts.Kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: opts.KubeContext}
+	if opts.Runtime != "" {
+		overrides.ClusterInfo.Server = opts.Runtime
+	}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get namespace from kubeconfig: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+
+	c := newClient(clientset, namespace, clistream)
+	c.share = opts.Share
+	c.attach = func(ctx context.Context, namespace, pod string,
+		attachOpts *corev1.PodAttachOptions, streamOpts remotecommand.StreamOptions,
+	) error {
+		req := clientset.CoreV1().RESTClient().Post().
+			Resource("pods").
+			Namespace(namespace).
+			Name(pod).
+			SubResource("attach").
+			VersionedParams(attachOpts, scheme.ParameterCodec)
+		executor, err := newExecutor(config, req)
+		if err != nil {
+			return err
+		}
+		return executor.StreamWithContext(ctx, streamOpts)
+	}
+	return c, nil
+}
+
+func newClient(clientset kubernetes.Interface, namespace string, clistream *iocli.CliStream) *KubernetesClient {
+	return &KubernetesClient{
+		clientset: clientset,
+		namespace: namespace,
+		out:       clistream.AuxStream(),
+		share:     exec.DefaultShare,
+	}
+}
+
+// newExecutor prefers the websocket protocol and falls back to SPDY for API
+// servers that do not support it yet.
+func newExecutor(config *rest.Config, req *rest.Request) (remotecommand.Executor, error) {
+	spdyExec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SPDY executor: %w", err)
+	}
+	wsExec, err := remotecommand.NewWebSocketExecutor(config, "GET", req.URL().String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create websocket executor: %w", err)
+	}
+	return remotecommand.NewFallbackExecutor(wsExec, spdyExec, httpstream.IsUpgradeFailure)
+}
+
+// parseTarget splits a target of the form [namespace/]pod[/container].
+func parseTarget(target, defaultNamespace string) (namespace, pod, container string, err error) {
+	parts := strings.Split(target, "/")
+	switch len(parts) {
+	case 1:
+		return defaultNamespace, parts[0], "", nil
+	case 2:
+		return parts[0], parts[1], "", nil
+	case 3:
+		return parts[0], parts[1], parts[2], nil
+	default:
+		return "", "", "", fmt.Errorf("invalid kubernetes target %q. Use: <namespace>/<pod>/<container>", target)
+	}
+}
+
+func (c *KubernetesClient) GetContainerInfo(ctx context.Context, target string) (*exec.ContainerInspectInfo, error) {
+	namespace, podName, containerName, err := parseTarget(target, c.namespace)
+	if err != nil {
+		return nil, err
+	}
+	pod, err := c.clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get pod: %w", err)
+	}
+	if pod.Spec.HostPID || (pod.Spec.ShareProcessNamespace != nil && *pod.Spec.ShareProcessNamespace) {
+		return nil, fmt.Errorf("pod %q shares its PID namespace, which is not supported by the kubernetes backend", podName)
+	}
+
+	if containerName == "" {
+		if len(pod.Spec.Containers) == 0 {
+			return nil, fmt.Errorf("pod %q has no containers", podName)
+		}
+		containerName = pod.Spec.Containers[0].Name
+	}
+	var container *corev1.Container
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == containerName {
+			container = &pod.Spec.Containers[i]
+		}
+	}
+	if container == nil {
+		return nil, fmt.Errorf("container %q not found in pod %q", containerName, podName)
+	}
+
+	info := &exec.ContainerInspectInfo{
+		ID:    containerName,
+		Name:  namespace + "/" + podName + "/" + containerName,
+		Image: container.Image,
+		User:  "root",
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == containerName {
+			info.Isrunning = status.State.Running != nil
+		}
+	}
+	if sc := container.SecurityContext; sc != nil && sc.Privileged != nil {
+		info.IsPrivileged = *sc.Privileged
+	}
+	if uid := runAsUser(pod, container); uid != nil && *uid != 0 {
+		// the debugger joins the pod as the same non root user
+		info.User = "nonroot"
+	}
+
+	c.namespace = namespace
+	c.pod = pod
+	c.container = containerName
+	c.targetUID = runAsUser(pod, container)
+	c.targetSecCtx = container.SecurityContext
+	return info, nil
+}
+
+func runAsUser(pod *corev1.Pod, container *corev1.Container) *int64 {
+	if sc := container.SecurityContext; sc != nil && sc.RunAsUser != nil {
+		return sc.RunAsUser
+	}
+	if psc := pod.Spec.SecurityContext; psc != nil && psc.RunAsUser != nil {
+		return psc.RunAsUser
+	}
+	return nil
+}
+
+// PullImage is a no-op, the kubelet pulls the image of the ephemeral container.
+func (c *KubernetesClient) PullImage(ctx context.Context, image string, platform string) error {
+	return nil
+}
+
+func (c *KubernetesClient) CreateContainer(ctx context.Context, targetInspect *exec.ContainerInspectInfo,
+	image, entrypoint, user, containerName string, labels map[string]string,
+	tty, stdin bool, mountDir string,
+) (string, error) {
+	if mountDir != "" {
+		return "", errors.New("mounting a local directory is not supported by the kubernetes backend")
+	}
+
+	securityContext := &corev1.SecurityContext{
+		Privileged: &targetInspect.IsPrivileged,
+		RunAsUser:  c.debuggerUID(user),
+	}
+	if c.targetSecCtx != nil {
+		securityContext.Capabilities = c.targetSecCtx.Capabilities
+	}
+
+	// the network, IPC and UTS namespaces of an ephemeral container are the
+	// ones of the pod, only the PID one can be chosen
+	var targetContainer string
+	if c.share.Pid {
+		targetContainer = c.container
+	}
+	pod := c.pod.DeepCopy()
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:            containerName,
+			Image:           image,
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Command:         []string{"sh", "-c", entrypoint},
+			Stdin:           stdin,
+			StdinOnce:       stdin,
+			TTY:             tty,
+			SecurityContext: securityContext,
+			Env:             labelsEnv(labels),
+		},
+		TargetContainerName: targetContainer,
+	})
+
+	if _, err := c.clientset.CoreV1().Pods(c.namespace).UpdateEphemeralContainers(ctx, pod.Name, pod, metav1.UpdateOptions{}); err != nil {
+		return "", fmt.Errorf("failed to create ephemeral container: %w", err)
+	}
+	return containerName, nil
+}
+
+// debuggerUID maps the user chosen by RunDebugger to a uid, keeping the
+// uid of the target when it runs as non root.
+func (c *KubernetesClient) debuggerUID(user string) *int64 {
+	var uid int64
+	if strings.HasPrefix(user, "nonroot") {
+		uid = nonrootUID
+		if c.targetUID != nil {
+			uid = *c.targetUID
+		}
+	} else if n, err := strconv.ParseInt(strings.Split(user, ":")[0], 10, 64); err == nil {
+		uid = n
+	}
+	return &uid
+}
+
+func (c *KubernetesClient) AttachContainer(ctx context.Context, containerID string, tty, stdin bool, cliStream *iocli.CliStream) error {
+	terminated, err := c.waitForContainer(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	if terminated != nil {
+		// too short lived to be attached, its output is in the logs
+		if err := c.printLogs(ctx, containerID, cliStream.Recorder().TeeOutput(cliStream.OutputStream())); err != nil {
+			return err
+		}
+		return exec.ExitStatus(int(terminated.ExitCode))
+	}
+
+	recorder := cliStream.Recorder()
+	streamOpts := remotecommand.StreamOptions{
+		Stdout: recorder.TeeOutput(cliStream.OutputStream()),
+		Tty:    tty,
+	}
+	if stdin {
+		streamOpts.Stdin = recorder.TeeInput(cliStream.InputStream())
+	}
+	if !tty {
+		streamOpts.Stderr = recorder.TeeOutput(cliStream.ErrorStream())
+	}
+
+	if tty {
+		if err := cliStream.InputStream().SetRawTerminal(); err != nil {
+			return fmt.Errorf("failed to set raw terminal: %w", err)
+		}
+		defer cliStream.InputStream().RestoreTerminal()
+
+		if cliStream.OutputStream().IsTerminal() {
+			// the resizing ends with the stream
+			resizeCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			sizeQueue := iocli.NewTerminalSizeQueue()
+			defer sizeQueue.Close()
+			streamOpts.TerminalSizeQueue = sizeQueue
+			iocli.StartResizing(resizeCtx, cliStream, sizeQueue.Resize)
+		}
+	}
+
+	attachOpts := &corev1.PodAttachOptions{
+		Container: containerID,
+		Stdin:     stdin,
+		Stdout:    true,
+		Stderr:    !tty,
+		TTY:       tty,
+	}
+	if err := c.attach(ctx, c.namespace, c.pod.Name, attachOpts, streamOpts); err != nil {
+		return fmt.Errorf("failed to attach container: %w", err)
+	}
+
+	terminated, err = c.waitForExit(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	return exec.ExitStatus(int(terminated.ExitCode))
+}
+
+// waitForContainer waits until the kubelet started the ephemeral container,
+// the state is returned when it already terminated.
+func (c *KubernetesClient) waitForContainer(ctx context.Context, containerName string) (*corev1.ContainerStateTerminated, error) {
+	var terminated *corev1.ContainerStateTerminated
+	err := wait.PollUntilContextTimeout(ctx, 500*time.Millisecond, containerStartTimeout, true,
+		func(ctx context.Context) (bool, error) {
+			pod, err := c.clientset.CoreV1().Pods(c.namespace).Get(ctx, c.pod.Name, metav1.GetOptions{})
+			if err != nil {
+				return false, fmt.Errorf("failed to get pod: %w", err)
+			}
+			for _, status := range pod.Status.EphemeralContainerStatuses {
+				if status.Name != containerName {
+					continue
+				}
+				switch {
+				case status.State.Running != nil:
+					return true, nil
+				case status.State.Terminated != nil:
+					terminated = status.State.Terminated
+					return true, nil
+				case status.State.Waiting != nil && isImagePullError(status.State.Waiting.Reason):
+					return false, fmt.Errorf("failed to pull debugger image: %s", status.State.Waiting.Message)
+				}
+			}
+			return false, nil
+		})
+	return terminated, err
+}
+
+// waitForExit waits until the kubelet reports the ephemeral container as
+// terminated, which can lag behind the end of the attach stream.
+func (c *KubernetesClient) waitForExit(ctx context.Context, containerName string) (*corev1.ContainerStateTerminated, error) {
+	var terminated *corev1.ContainerStateTerminated
+	err := wait.PollUntilContextTimeout(ctx, 500*time.Millisecond, containerExitTimeout, true,
+		func(ctx context.Context) (bool, error) {
+			pod, err := c.clientset.CoreV1().Pods(c.namespace).Get(ctx, c.pod.Name, metav1.GetOptions{})
+			if err != nil {
+				return false, fmt.Errorf("failed to get pod: %w", err)
+			}
+			for _, status := range pod.Status.EphemeralContainerStatuses {
+				if status.Name == containerName && status.State.Terminated != nil {
+					terminated = status.State.Terminated
+					return true, nil
+				}
+			}
+			return false, nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the exit code of the debugger: %w", err)
+	}
+	return terminated, nil
+}
+
+func (c *KubernetesClient) printLogs(ctx context.Context, containerName string, out io.Writer) error {
+	logs, err := c.clientset.CoreV1().Pods(c.namespace).GetLogs(c.pod.Name, &corev1.PodLogOptions{
+		Container: containerName,
+	}).Stream(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get debugger logs: %w", err)
+	}
+	defer logs.Close()
+	_, err = io.Copy(out, logs)
+	return err
+}
+
+func isImagePullError(reason string) bool {
+	switch reason {
+	case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "ErrImageNeverPull":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,325 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+func testPod(uid *int64) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop"},
+		Spec: corev1.PodSpec{
+			SecurityContext: &corev1.PodSecurityContext{RunAsUser: uid},
+			Containers: []corev1.Container{
+				{Name: "sidecar"},
+				{Name: "app", SecurityContext: &corev1.SecurityContext{
+					Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"NET_ADMIN"}},
+				}},
+			},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "sidecar", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+				{Name: "app", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+			},
+		},
+	}
+}
+
+func newTestStream() *iocli.CliStream {
+	return iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard)
+}
+
+// startEphemeralContainers marks every ephemeral container added to a pod
+// as running, like the kubelet would.
+func startEphemeralContainers(clientset *fake.Clientset) {
+	clientset.PrependReactor("update", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		update, ok := action.(k8stesting.UpdateAction)
+		if !ok || update.GetSubresource() != "ephemeralcontainers" {
+			return false, nil, nil
+		}
+		pod := update.GetObject().(*corev1.Pod).DeepCopy()
+		for _, ec := range pod.Spec.EphemeralContainers {
+			pod.Status.EphemeralContainerStatuses = append(pod.Status.EphemeralContainerStatuses, corev1.ContainerStatus{
+				Name:  ec.Name,
+				State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+			})
+		}
+		if err := clientset.Tracker().Update(corev1.SchemeGroupVersion.WithResource("pods"), pod, pod.Namespace); err != nil {
+			return true, nil, err
+		}
+		return true, pod, nil
+	})
+}
+
+// terminateEphemeralContainer marks the ephemeral container as terminated
+// with the exit code, like the kubelet would once the debugger exits.
+func terminateEphemeralContainer(t *testing.T, clientset *fake.Clientset, name string, exitCode int32) {
+	t.Helper()
+	gvr := corev1.SchemeGroupVersion.WithResource("pods")
+	obj, err := clientset.Tracker().Get(gvr, "shop", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := obj.(*corev1.Pod).DeepCopy()
+	for i, status := range pod.Status.EphemeralContainerStatuses {
+		if status.Name == name {
+			pod.Status.EphemeralContainerStatuses[i].State = corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode},
+			}
+		}
+	}
+	if err := clientset.Tracker().Update(gvr, pod, "shop"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		target    string
+		namespace string
+		pod       string
+		container string
+		wantErr   bool
+	}{
+		{target: "web", namespace: "default", pod: "web"},
+		{target: "shop/web", namespace: "shop", pod: "web"},
+		{target: "shop/web/app", namespace: "shop", pod: "web", container: "app"},
+		{target: "a/b/c/d", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			namespace, pod, container, err := parseTarget(tt.target, "default")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if namespace != tt.namespace || pod != tt.pod || container != tt.container {
+				t.Errorf("parseTarget() = %q, %q, %q, want %q, %q, %q",
+					namespace, pod, container, tt.namespace, tt.pod, tt.container)
+			}
+		})
+	}
+}
+
+func TestGetContainerInfo(t *testing.T) {
+	uid := int64(1001)
+	c := newClient(fake.NewSimpleClientset(testPod(&uid)), "default", newTestStream())
+
+	info, err := c.GetContainerInfo(context.Background(), "shop/web/app")
+	if err != nil {
+		t.Fatalf("GetContainerInfo() error = %v", err)
+	}
+	want := exec.ContainerInspectInfo{ID: "app", Name: "shop/web/app", Isrunning: true, User: "nonroot"}
+	if *info != want {
+		t.Errorf("GetContainerInfo() = %+v, want %+v", *info, want)
+	}
+
+	info, err = c.GetContainerInfo(context.Background(), "shop/web")
+	if err != nil {
+		t.Fatalf("GetContainerInfo() error = %v", err)
+	}
+	if info.ID != "sidecar" {
+		t.Errorf("GetContainerInfo() picked container %q, want the first one", info.ID)
+	}
+
+	if _, err := c.GetContainerInfo(context.Background(), "shop/web/missing"); err == nil {
+		t.Errorf("GetContainerInfo() of a missing container succeeded")
+	}
+}
+
+func TestRunDebugger(t *testing.T) {
+	uid := int64(1001)
+	clientset := fake.NewSimpleClientset(testPod(&uid))
+	startEphemeralContainers(clientset)
+
+	cliStream := newTestStream()
+	c := newClient(clientset, "default", cliStream)
+	var attached *corev1.PodAttachOptions
+	c.attach = func(ctx context.Context, namespace, pod string,
+		attachOpts *corev1.PodAttachOptions, streamOpts remotecommand.StreamOptions,
+	) error {
+		attached = attachOpts
+		terminateEphemeralContainer(t, clientset, attachOpts.Container, 0)
+		return nil
+	}
+
+	opts, err := exec.New([]exec.Option{
+		exec.WithTarget("shop/web/app"),
+		exec.WithCommand([]string{"ls"}),
+		exec.WithDebuggerImage(""),
+		exec.WithUser("root:0::root:0"),
+		exec.WithName("conxec-debugger-test"),
+		exec.WithStdin(true),
+		exec.WithTty(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.RunDebugger(context.Background(), c, opts, cliStream); err != nil {
+		t.Fatalf("RunDebugger() error = %v", err)
+	}
+
+	pod, err := clientset.CoreV1().Pods("shop").Get(context.Background(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pod.Spec.EphemeralContainers) != 1 {
+		t.Fatalf("ephemeral containers = %d, want 1", len(pod.Spec.EphemeralContainers))
+	}
+	ec := pod.Spec.EphemeralContainers[0]
+	if ec.TargetContainerName != "app" || ec.Name != "conxec-debugger-test" || ec.Image != opts.DbgImg {
+		t.Errorf("ephemeral container = %+v", ec)
+	}
+	if !ec.TTY || !ec.Stdin || len(ec.Command) != 3 {
+		t.Errorf("ephemeral container tty = %v, stdin = %v, command = %q", ec.TTY, ec.Stdin, ec.Command)
+	}
+	if got := *ec.SecurityContext.RunAsUser; got != uid {
+		t.Errorf("debugger runs as %d, want the target uid %d", got, uid)
+	}
+	if ec.SecurityContext.Capabilities == nil || len(ec.SecurityContext.Capabilities.Add) != 1 {
+		t.Errorf("debugger capabilities = %+v, want the target ones", ec.SecurityContext.Capabilities)
+	}
+
+	if attached == nil || attached.Container != "conxec-debugger-test" || !attached.TTY || attached.Stderr {
+		t.Errorf("attach options = %+v", attached)
+	}
+}
+
+func TestRunDebuggerExitStatus(t *testing.T) {
+	clientset := fake.NewSimpleClientset(testPod(nil))
+	startEphemeralContainers(clientset)
+
+	cliStream := newTestStream()
+	c := newClient(clientset, "default", cliStream)
+	c.attach = func(ctx context.Context, namespace, pod string,
+		attachOpts *corev1.PodAttachOptions, streamOpts remotecommand.StreamOptions,
+	) error {
+		terminateEphemeralContainer(t, clientset, attachOpts.Container, 2)
+		return nil
+	}
+
+	opts, err := exec.New([]exec.Option{
+		exec.WithTarget("shop/web/app"),
+		exec.WithCommand([]string{"false"}),
+		exec.WithDebuggerImage(""),
+		exec.WithUser("root:0::root:0"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = exec.RunDebugger(context.Background(), c, opts, cliStream)
+	var statusErr iocli.StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code() != 2 {
+		t.Errorf("RunDebugger() error = %v, want exit status 2", err)
+	}
+}
+
+func TestCreateContainerRejectsMount(t *testing.T) {
+	c := newClient(fake.NewSimpleClientset(testPod(nil)), "default", newTestStream())
+	info, err := c.GetContainerInfo(context.Background(), "shop/web/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateContainer(context.Background(), info, "busybox", "sh", "root:root", "dbg", nil, false, false, "/tmp"); err == nil {
+		t.Errorf("CreateContainer() with a mount directory succeeded")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	pod := testPod(nil)
+	labels := exec.SessionLabels("1a2b3c4d", "app", []string{"sh"}, "root:root", time.Now())
+	pod.Spec.EphemeralContainers = []corev1.EphemeralContainer{
+		{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "conxec-debugger-1a2b3c4d", Env: labelsEnv(labels)}},
+		{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger-0"}},
+	}
+	pod.Status.EphemeralContainerStatuses = []corev1.ContainerStatus{
+		{Name: "conxec-debugger-1a2b3c4d", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+	}
+	c := newClient(fake.NewSimpleClientset(pod), "shop", newTestStream())
+
+	sessions, err := c.ListSessions(context.Background())
+	if err != nil {
+		t.Fatalf("ListSessions() error = %v", err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("ListSessions() = %+v, want one session", sessions)
+	}
+	s := sessions[0]
+	if s.ID != "shop/web/conxec-debugger-1a2b3c4d" || s.RunID != "1a2b3c4d" || s.Target != "app" || s.Status != "running" {
+		t.Errorf("session = %+v", s)
+	}
+
+	if err := c.StopSession(context.Background(), "shop/web/debugger-0"); err == nil || !strings.Contains(err.Error(), "not a conxec debugger") {
+		t.Errorf("StopSession() error = %v, want not a debugger", err)
+	}
+}
+
+func TestWatchTarget(t *testing.T) {
+	clientset := fake.NewSimpleClientset(testPod(nil))
+	c := newClient(clientset, "default", newTestStream())
+	info, err := c.GetContainerInfo(context.Background(), "shop/web/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exits, err := c.WatchTarget(context.Background(), info.ID)
+	if err != nil {
+		t.Fatalf("WatchTarget() error = %v", err)
+	}
+
+	// the kubelet restarted the container after it was OOM killed
+	pod := testPod(nil)
+	pod.Status.ContainerStatuses[1].RestartCount = 1
+	pod.Status.ContainerStatuses[1].LastTerminationState = corev1.ContainerState{
+		Terminated: &corev1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"},
+	}
+	if err := clientset.Tracker().Update(corev1.SchemeGroupVersion.WithResource("pods"), pod, "shop"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case exit := <-exits:
+		if want := (exec.TargetExit{ExitCode: 137, OOMKilled: true}); exit != want {
+			t.Errorf("exit = %+v, want %+v", exit, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("exit of the target was not reported")
+	}
+}
+
+func TestNewClientShare(t *testing.T) {
+	tests := []struct {
+		share   string
+		wantErr bool
+	}{
+		{share: ""},
+		{share: "net,ipc,uts,pid,mnt"},
+		{share: "pid,mnt", wantErr: true},
+		{share: "net,pid,ipc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.share, func(t *testing.T) {
+			opts, err := exec.New([]exec.Option{exec.WithShare(tt.share), exec.WithKubeconfig("/nonexistent/kubeconfig")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the kubeconfig can't be loaded, the namespaces are checked before
+			_, err = NewClient(context.Background(), opts, newTestStream())
+			if gotErr := err != nil && strings.Contains(err.Error(), "--share"); gotErr != tt.wantErr {
+				t.Errorf("NewClient() error = %v, want a --share error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// WatchTarget reports the termination of the target container from a watch of
+// its pod, including a termination the kubelet already restarted it after.
+func (c *KubernetesClient) WatchTarget(ctx context.Context, targetID string) (<-chan exec.TargetExit, error) {
+	if c.pod == nil {
+		return nil, fmt.Errorf("target %q is not inspected", targetID)
+	}
+	w, err := c.clientset.CoreV1().Pods(c.namespace).Watch(ctx, metav1.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", c.pod.Name).String(),
+		ResourceVersion: c.pod.ResourceVersion,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch pod %q: %w", c.pod.Name, err)
+	}
+	restarts := int32(0)
+	if status := containerStatus(c.pod, targetID); status != nil {
+		restarts = status.RestartCount
+	}
+
+	exits := make(chan exec.TargetExit, 1)
+	go func() {
+		defer w.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-w.ResultChan():
+				if !ok {
+					return
+				}
+				pod, ok := ev.Object.(*corev1.Pod)
+				if !ok || pod.Name != c.pod.Name {
+					continue
+				}
+				status := containerStatus(pod, targetID)
+				if status == nil {
+					continue
+				}
+				terminated := status.State.Terminated
+				if terminated == nil && status.RestartCount > restarts {
+					terminated = status.LastTerminationState.Terminated
+				}
+				if terminated != nil {
+					exits <- exec.TargetExit{ExitCode: int(terminated.ExitCode), OOMKilled: terminated.Reason == "OOMKilled"}
+					return
+				}
+			}
+		}
+	}()
+	return exits, nil
+}
+
+func containerStatus(pod *corev1.Pod, name string) *corev1.ContainerStatus {
+	for i := range pod.Status.ContainerStatuses {
+		if pod.Status.ContainerStatuses[i].Name == name {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// labelEnvPrefix prefixes the environment variables holding the session
+// labels, as ephemeral containers can't be labeled.
+const labelEnvPrefix = "CONXEC_"
+
+// labelsEnv converts the labels to environment variables, e.g:
+// io.conxec.session to CONXEC_SESSION.
+func labelsEnv(labels map[string]string) []corev1.EnvVar {
+	env := make([]corev1.EnvVar, 0, len(labels))
+	for k, v := range labels {
+		name := strings.ToUpper(strings.ReplaceAll(strings.TrimPrefix(k, "io.conxec."), ".", "_"))
+		env = append(env, corev1.EnvVar{Name: labelEnvPrefix + name, Value: v})
+	}
+	sort.Slice(env, func(i, j int) bool { return env[i].Name < env[j].Name })
+	return env
+}
+
+// envLabels converts back the environment variables set by labelsEnv.
+func envLabels(env []corev1.EnvVar) map[string]string {
+	labels := map[string]string{}
+	for _, e := range env {
+		if name, ok := strings.CutPrefix(e.Name, labelEnvPrefix); ok {
+			labels["io.conxec."+strings.ReplaceAll(strings.ToLower(name), "_", ".")] = e.Value
+		}
+	}
+	return labels
+}
+
+// ListSessions returns the debugger ephemeral containers of the pods of the
+// namespace, their ids are <namespace>/<pod>/<container>.
+func (c *KubernetesClient) ListSessions(ctx context.Context) ([]exec.Session, error) {
+	pods, err := c.clientset.CoreV1().Pods(c.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	sessions := []exec.Session{}
+	for _, pod := range pods.Items {
+		for _, cont := range pod.Spec.EphemeralContainers {
+			labels := envLabels(cont.Env)
+			if _, ok := labels[exec.LabelSession]; !ok {
+				continue
+			}
+			id := pod.Namespace + "/" + pod.Name + "/" + cont.Name
+			sessions = append(sessions, exec.SessionFromLabels(id, ephemeralState(&pod, cont.Name), labels))
+		}
+	}
+	return sessions, nil
+}
+
+func ephemeralState(pod *corev1.Pod, containerName string) string {
+	for _, status := range pod.Status.EphemeralContainerStatuses {
+		if status.Name != containerName {
+			continue
+		}
+		switch {
+		case status.State.Running != nil:
+			return "running"
+		case status.State.Terminated != nil:
+			return "exited"
+		}
+	}
+	return "waiting"
+}
+
+// StopSession is not supported, ephemeral containers can't be stopped nor
+// removed from their pod.
+func (c *KubernetesClient) StopSession(ctx context.Context, id string) error {
+	if _, _, err := c.sessionContainer(ctx, id); err != nil {
+		return err
+	}
+	return fmt.Errorf("ephemeral containers can't be stopped, exit the debugger from its session or delete the pod")
+}
+
+// SessionLogs writes the output of the debugger ephemeral container.
+func (c *KubernetesClient) SessionLogs(ctx context.Context, id string, follow bool, stdout, stderr io.Writer) error {
+	pod, containerName, err := c.sessionContainer(ctx, id)
+	if err != nil {
+		return err
+	}
+	logs, err := c.clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: containerName,
+		Follow:    follow,
+	}).Stream(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get debugger logs: %w", err)
+	}
+	defer logs.Close()
+	_, err = io.Copy(stdout, logs)
+	return err
+}
+
+// sessionContainer returns the pod of the session and checks the container
+// is a debugger, so the sessions commands can't be used on other containers.
+func (c *KubernetesClient) sessionContainer(ctx context.Context, id string) (*corev1.Pod, string, error) {
+	namespace, podName, containerName, err := parseTarget(id, c.namespace)
+	if err != nil {
+		return nil, "", err
+	}
+	pod, err := c.clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to get pod: %w", err)
+	}
+	for _, cont := range pod.Spec.EphemeralContainers {
+		if _, ok := envLabels(cont.Env)[exec.LabelSession]; ok && cont.Name == containerName {
+			return pod, containerName, nil
+		}
+	}
+	return nil, "", fmt.Errorf("container %q of pod %q is not a conxec debugger session", containerName, podName)
+}
@@ -0,0 +1,578 @@
+package podman
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+	"github.com/docker/cli/cli/streams"
+	"github.com/docker/docker/api/types"
+	"github.com/moby/moby/pkg/stdcopy"
+)
+
+const (
+	rootfulAddress = "/run/podman/podman.sock"
+	apiVersion     = "v4.0.0"
+)
+
+type PodmanClient struct {
+	client *http.Client
+	dial   func(ctx context.Context) (net.Conn, error)
+	out    *streams.Out
+
+	target      *containerInspect
+	detachKeys  []byte
+	controlKeys []byte
+	share       exec.Share
+	shareSet    bool
+}
+
+// NewClient connects to the libpod REST API. Without a runtime address the
+// rootless socket of the user is preferred over the rootful one.
+func NewClient(ctx context.Context, opts *exec.ExecOptions, clistream *iocli.CliStream) (*PodmanClient, error) {
+	address := strings.TrimPrefix(opts.Runtime, "unix://")
+	if address == "" {
+		address = defaultAddress()
+	}
+	dial := func(ctx context.Context) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", address)
+	}
+
+	c := &PodmanClient{
+		client: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dial(ctx)
+			},
+		}},
+		dial:        dial,
+		out:         clistream.AuxStream(),
+		detachKeys:  opts.DetachKeys,
+		controlKeys: opts.ControlKeys,
+		share:       opts.Share,
+		shareSet:    opts.ShareSet,
+	}
+
+	if err := c.do(ctx, http.MethodGet, "/_ping", nil, nil, nil); err != nil {
+		return nil, fmt.Errorf("failed to connect to podman at %q: %w", address, err)
+	}
+	return c, nil
+}
+
+func defaultAddress() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" && os.Geteuid() != 0 {
+		sock := filepath.Join(dir, "podman", "podman.sock")
+		if _, err := os.Stat(sock); err == nil {
+			return sock
+		}
+	}
+	return rootfulAddress
+}
+
+// do sends a request to the libpod API and decodes the JSON response in out.
+func (c *PodmanClient) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	resp, err := c.request(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *PodmanClient) request(ctx context.Context, method, path string, query url.Values, body any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+	u := url.URL{Scheme: "http", Host: "d", Path: "/" + apiVersion + "/libpod" + path, RawQuery: query.Encode()}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return nil, &apiError{StatusCode: resp.StatusCode, Message: apiErr.Message}
+	}
+	return resp, nil
+}
+
+type apiError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("podman API error (%d): %s", e.StatusCode, e.Message)
+}
+
+// hijack upgrades the request to a raw stream, as the attach endpoint does.
+func (c *PodmanClient) hijack(ctx context.Context, path string, query url.Values, body any) (types.HijackedResponse, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return types.HijackedResponse{}, err
+	}
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			conn.Close()
+			return types.HijackedResponse{}, err
+		}
+		reader = bytes.NewReader(b)
+	}
+	u := url.URL{Path: "/" + apiVersion + "/libpod" + path, RawQuery: query.Encode()}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), reader)
+	if err != nil {
+		conn.Close()
+		return types.HijackedResponse{}, err
+	}
+	req.Host = "d"
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return types.HijackedResponse{}, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return types.HijackedResponse{}, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols && resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return types.HijackedResponse{}, fmt.Errorf("unexpected status %q", resp.Status)
+	}
+	return types.HijackedResponse{Conn: conn, Reader: br}, nil
+}
+
+// containerInspect is the part of the libpod container inspect used by conxec.
+type containerInspect struct {
+	ID        string `json:"Id"`
+	Name      string `json:"Name"`
+	ImageName string `json:"ImageName"`
+	Pod       string `json:"Pod"`
+	State     struct {
+		Running   bool `json:"Running"`
+		Pid       int  `json:"Pid"`
+		OOMKilled bool `json:"OOMKilled"`
+	} `json:"State"`
+	Config struct {
+		User      string            `json:"User"`
+		Tty       bool              `json:"Tty"`
+		OpenStdin bool              `json:"OpenStdin"`
+		Labels    map[string]string `json:"Labels"`
+	} `json:"Config"`
+	HostConfig struct {
+		Privileged bool     `json:"Privileged"`
+		PidMode    string   `json:"PidMode"`
+		UsernsMode string   `json:"UsernsMode"`
+		CapAdd     []string `json:"CapAdd"`
+		CapDrop    []string `json:"CapDrop"`
+	} `json:"HostConfig"`
+}
+
+func (c *PodmanClient) GetContainerInfo(ctx context.Context, container string) (*exec.ContainerInspectInfo, error) {
+	var inspect containerInspect
+	if err := c.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(container)+"/json", nil, nil, &inspect); err != nil {
+		return nil, fmt.Errorf("Failed to inspect container: %w", err)
+	}
+	c.target = &inspect
+	info := &exec.ContainerInspectInfo{
+		ID:            inspect.ID,
+		Name:          inspect.Name,
+		Image:         inspect.ImageName,
+		Isrunning:     inspect.State.Running,
+		IsPrivileged:  inspect.HostConfig.Privileged,
+		IsPidModeHost: inspect.HostConfig.PidMode == "host",
+		Pid:           inspect.State.Pid,
+		User:          inspect.Config.User,
+		Platform:      "linux",
+	}
+	if keepID(&inspect) && !isRootUser(inspect.Config.User) {
+		// the debugger joins the user namespace as the same non root user
+		info.User = "nonroot"
+	}
+	return info, nil
+}
+
+// keepID tells whether the target runs with --userns=keep-id, as the user
+// of the host mapped to the same uid.
+func keepID(target *containerInspect) bool {
+	return strings.HasPrefix(target.HostConfig.UsernsMode, "keep-id")
+}
+
+func isRootUser(user string) bool {
+	name, _, _ := strings.Cut(user, ":")
+	return name == "" || name == "root" || name == "0"
+}
+
+func (c *PodmanClient) PullImage(ctx context.Context, image string, platform string) error {
+	resp, err := c.request(ctx, http.MethodPost, "/images/pull", url.Values{
+		"reference": {image},
+		"policy":    {"missing"},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to pull image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var report struct {
+			Stream string `json:"stream"`
+			Error  string `json:"error"`
+		}
+		if err := dec.Decode(&report); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read pull progress: %w", err)
+		}
+		if report.Error != "" {
+			return fmt.Errorf("failed to pull image: %s", report.Error)
+		}
+		fmt.Fprint(c.out, report.Stream)
+	}
+}
+
+// ImageDigest returns the repo digest of the image, or its ID when it was not
+// pulled from a registry.
+func (c *PodmanClient) ImageDigest(ctx context.Context, image string) (string, error) {
+	var inspect struct {
+		ID          string   `json:"Id"`
+		RepoDigests []string `json:"RepoDigests"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/images/"+url.PathEscape(image)+"/json", nil, nil, &inspect); err != nil {
+		return "", fmt.Errorf("failed to inspect image: %w", err)
+	}
+	if len(inspect.RepoDigests) > 0 {
+		return inspect.RepoDigests[0], nil
+	}
+	return inspect.ID, nil
+}
+
+// namespace is a libpod namespace specification.
+type namespace struct {
+	NSMode string `json:"nsmode"`
+	Value  string `json:"value,omitempty"`
+}
+
+type mount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type"`
+	Source      string   `json:"source"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// specGenerator is the part of the libpod SpecGenerator used by conxec.
+type specGenerator struct {
+	Name       string            `json:"name"`
+	Image      string            `json:"image"`
+	Entrypoint []string          `json:"entrypoint"`
+	Command    []string          `json:"command"`
+	User       string            `json:"user,omitempty"`
+	Terminal   bool              `json:"terminal"`
+	Stdin      bool              `json:"stdin"`
+	Privileged bool              `json:"privileged"`
+	CapAdd     []string          `json:"cap_add,omitempty"`
+	CapDrop    []string          `json:"cap_drop,omitempty"`
+	Pod        string            `json:"pod,omitempty"`
+	PidNS      *namespace        `json:"pidns,omitempty"`
+	NetNS      *namespace        `json:"netns,omitempty"`
+	IpcNS      *namespace        `json:"ipcns,omitempty"`
+	UtsNS      *namespace        `json:"utsns,omitempty"`
+	UserNS     *namespace        `json:"userns,omitempty"`
+	Mounts     []mount           `json:"mounts,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+func (c *PodmanClient) CreateContainer(ctx context.Context, targetInspect *exec.ContainerInspectInfo,
+	image, entrypoint, user, containerName string, labels map[string]string,
+	tty, stdin bool, mountDir string,
+) (string, error) {
+	if c.shareSet && c.share.Net && c.target.Pod != "" {
+		if err := c.checkPodShare(ctx, c.target.Pod); err != nil {
+			return "", err
+		}
+	}
+	spec := debuggerSpec(c.target, c.share, image, entrypoint, user, containerName, tty, stdin)
+	spec.Labels = labels
+	if mountDir != "" {
+		absMountDir, err := filepath.Abs(mountDir)
+		if err != nil {
+			return "", fmt.Errorf("failed to convert %s to absolute path: %w", mountDir, err)
+		}
+		spec.Mounts = []mount{{Destination: "/work", Type: "bind", Source: absMountDir, Options: []string{"rbind"}}}
+	}
+
+	var resp struct {
+		ID string `json:"Id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/containers/create", nil, spec, &resp); err != nil {
+		return "", fmt.Errorf("failed to create container: %w", err)
+	}
+	return resp.ID, nil
+}
+
+// checkPodShare rejects the namespaces the pod of the target shares but the
+// debugger should not join, the debugger gets them all with the pod.
+func (c *PodmanClient) checkPodShare(ctx context.Context, pod string) error {
+	var inspect struct {
+		SharedNamespaces []string `json:"SharedNamespaces"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/pods/"+pod+"/json", nil, nil, &inspect); err != nil {
+		return fmt.Errorf("failed to inspect pod %s: %w", pod, err)
+	}
+	var podShare exec.Share
+	for _, ns := range inspect.SharedNamespaces {
+		switch ns {
+		case "net":
+			podShare.Net = true
+		case "pid":
+			podShare.Pid = true
+		case "ipc":
+			podShare.IPC = true
+		case "uts":
+			podShare.UTS = true
+		}
+	}
+	return c.share.Require(podShare, fmt.Sprintf("podman:// debuggers join the pod %s of the target for its network, with all the namespaces it shares", pod))
+}
+
+// debuggerSpec joins the PID namespace of the target. A target in a pod gets
+// the debugger in the same pod, sharing the namespaces of the infra container,
+// otherwise the network namespace is joined directly. Targets with their own
+// user namespace (e.g: --userns=keep-id or auto) have it joined too, as
+// /proc/<pid>/root is only reachable from inside it. A rootless target in the
+// default user namespace is in the one of podman, the debugger is too.
+// The non root debugger of a keep-id target runs as the user of the target,
+// the only one /proc/<pid>/root of its processes is readable by.
+func debuggerSpec(target *containerInspect, share exec.Share,
+	image, entrypoint, user, containerName string,
+	tty, stdin bool,
+) *specGenerator {
+	spec := &specGenerator{
+		Name:       containerName,
+		Image:      image,
+		Entrypoint: []string{"sh"},
+		Command:    []string{"-c", entrypoint},
+		User:       user,
+		Terminal:   tty,
+		Stdin:      stdin,
+		Privileged: target.HostConfig.Privileged,
+		CapAdd:     target.HostConfig.CapAdd,
+		CapDrop:    target.HostConfig.CapDrop,
+	}
+	targetNS := &namespace{NSMode: "container", Value: target.ID}
+	if share.Pid {
+		spec.PidNS = targetNS
+	}
+	// the network of a container in a pod is the one of the pod
+	if share.Net && target.Pod != "" {
+		spec.Pod = target.Pod
+	} else if share.Net {
+		spec.NetNS = targetNS
+	}
+	if share.IPC {
+		spec.IpcNS = targetNS
+	}
+	if share.UTS {
+		spec.UtsNS = targetNS
+	}
+	if mode := target.HostConfig.UsernsMode; mode != "" && mode != "host" && spec.Pod == "" {
+		spec.UserNS = targetNS
+	}
+	if keepID(target) && !isRootUser(user) && !isRootUser(target.Config.User) {
+		spec.User = target.Config.User
+	}
+	return spec
+}
+
+func (c *PodmanClient) AttachContainer(ctx context.Context, containerID string, tty, stdin bool, cliStream *iocli.CliStream) error {
+	return c.attach(ctx, containerID, tty, stdin, false, true, cliStream)
+}
+
+// StartContainer starts a detached debugger container.
+func (c *PodmanClient) StartContainer(ctx context.Context, containerID string) error {
+	return c.do(ctx, http.MethodPost, "/containers/"+containerID+"/start", nil, nil, nil)
+}
+
+// SignalContainer sends the signal to the entrypoint of the debugger.
+func (c *PodmanClient) SignalContainer(ctx context.Context, containerID string, sig syscall.Signal) error {
+	return c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(containerID)+"/kill", url.Values{
+		"signal": {strconv.Itoa(int(sig))},
+	}, nil, nil)
+}
+
+// AttachSession reattaches to a running debugger container.
+func (c *PodmanClient) AttachSession(ctx context.Context, containerID string, readOnly bool, cliStream *iocli.CliStream) error {
+	var inspect containerInspect
+	if err := c.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(containerID)+"/json", nil, nil, &inspect); err != nil {
+		return fmt.Errorf("Failed to inspect debugger container: %w", err)
+	}
+	if !inspect.State.Running {
+		return fmt.Errorf("debugger session %q is not running", containerID)
+	}
+	return c.attach(ctx, inspect.ID, inspect.Config.Tty, inspect.Config.OpenStdin && !readOnly, readOnly, false, cliStream)
+}
+
+// SessionTerminalSize reads the size of the TTY of a running debugger
+// container, with an exec in it.
+func (c *PodmanClient) SessionTerminalSize(ctx context.Context, containerID string) (uint, uint, error) {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(containerID)+"/exec", nil, map[string]any{
+		"Cmd":          exec.TerminalSizeCommand,
+		"AttachStdout": true,
+		"AttachStderr": true,
+	}, &created); err != nil {
+		return 0, 0, fmt.Errorf("failed to exec in debugger container: %w", err)
+	}
+	resp, err := c.hijack(ctx, "/exec/"+created.ID+"/start", nil, map[string]any{"Detach": false})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to exec in debugger container: %w", err)
+	}
+	defer resp.Close()
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, resp.Reader); err != nil {
+		return 0, 0, fmt.Errorf("failed to exec in debugger container: %w", err)
+	}
+	if stderr.Len() > 0 {
+		return 0, 0, errors.New(strings.TrimSpace(stderr.String()))
+	}
+	return exec.ParseTerminalSize(stdout.String())
+}
+
+// attach streams the IO of the debugger container until it exits or the user
+// detaches, the container is started once attached unless it is already
+// running. A read-only attach only reads the local TTY for the detach and the
+// control keys, and leaves its size to the other users.
+func (c *PodmanClient) attach(ctx context.Context, containerID string, tty, stdin, readOnly, start bool, cliStream *iocli.CliStream) error {
+	resp, err := c.hijack(ctx, "/containers/"+containerID+"/attach", url.Values{
+		"stream": {"true"},
+		"stdin":  {fmt.Sprint(stdin)},
+		"stdout": {"true"},
+		"stderr": {"true"},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to attach container: %w", err)
+	}
+	defer resp.Close()
+
+	var cin io.ReadCloser
+	if stdin || readOnly {
+		cin = cliStream.InputStream()
+	}
+	var cout io.Writer = cliStream.OutputStream()
+	var cerr io.Writer = cliStream.ErrorStream()
+	if tty {
+		cerr = cliStream.OutputStream()
+	}
+
+	streamDone := make(chan struct{})
+	detached := make(chan error, 1)
+	go func() {
+		defer close(streamDone)
+		s := iocli.IOStreamer{
+			Streams:      cliStream,
+			InputStream:  cin,
+			OutputStream: cout,
+			ErrorStream:  cerr,
+			Resp:         resp,
+			Tty:          tty,
+			Stdin:        stdin,
+			ReadOnly:     readOnly,
+			DetachKeys:   c.detachKeys,
+			ControlKeys:  c.controlKeys,
+		}
+		if err := s.Stream(ctx); errors.Is(err, iocli.ErrDetached) || errors.Is(err, iocli.ErrTakeControl) {
+			detached <- err
+		} else if err != nil {
+			log.Printf("IOStreamer.Stream() failed: %s", err)
+		}
+	}()
+
+	if start {
+		if err := c.StartContainer(ctx, containerID); err != nil {
+			return fmt.Errorf("cannot start debugger container: %w", err)
+		}
+	}
+
+	if tty && !readOnly && cliStream.OutputStream().IsTerminal() {
+		// the resizing ends with the attach
+		resizeCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		iocli.StartResizing(resizeCtx, cliStream, func(ctx context.Context, height, width uint) error {
+			return c.do(ctx, http.MethodPost, "/containers/"+containerID+"/resize", url.Values{
+				"h": {fmt.Sprint(height)},
+				"w": {fmt.Sprint(width)},
+			}, nil, nil)
+		})
+	}
+
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var exitCode int
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- c.do(waitCtx, http.MethodPost, "/containers/"+containerID+"/wait", url.Values{
+			"condition": {"stopped", "exited"},
+		}, nil, &exitCode)
+	}()
+	select {
+	case err := <-detached:
+		return err
+	case err := <-waitErr:
+		if err != nil {
+			return fmt.Errorf("waiting debugger container failed: %w", err)
+		}
+	}
+	// flush the output of the debugger before exiting
+	<-streamDone
+
+	// the debugger is removed once its exit code is known rather than auto
+	// removed by podman, a lost connection keeps the session alive
+	if err := c.do(context.WithoutCancel(ctx), http.MethodDelete, "/containers/"+containerID, url.Values{
+		"force": {"true"},
+	}, nil, nil); err != nil {
+		fmt.Fprintf(c.out, "failed to remove debugger container: %s\n", err)
+	}
+	return exec.ExitStatus(exitCode)
+}
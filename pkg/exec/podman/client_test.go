@@ -0,0 +1,310 @@
+package podman
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+	"github.com/docker/cli/cli/streams"
+	"github.com/moby/moby/pkg/stdcopy"
+)
+
+// fakeLibpod returns a client of a libpod API served by handler, the paths it
+// gets are the ones after /<version>/libpod.
+func fakeLibpod(t *testing.T, handler http.HandlerFunc) *PodmanClient {
+	t.Helper()
+	prefix := "/" + apiVersion + "/libpod"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	dial := func(ctx context.Context) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "tcp", server.Listener.Addr().String())
+	}
+	return &PodmanClient{
+		client: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dial(ctx)
+			},
+		}},
+		dial: dial,
+		out:  streams.NewOut(io.Discard),
+	}
+}
+
+func TestDebuggerSpec(t *testing.T) {
+	tests := []struct {
+		name       string
+		pod        string
+		usernsMode string
+		targetUser string
+		user       string
+		wantPod    string
+		wantNetNS  *namespace
+		wantUserNS *namespace
+		wantUser   string
+	}{
+		{
+			name:      "container in the user namespace of podman",
+			wantNetNS: &namespace{NSMode: "container", Value: "target"},
+		},
+		{
+			name:       "container with --userns=auto",
+			usernsMode: "auto",
+			wantNetNS:  &namespace{NSMode: "container", Value: "target"},
+			wantUserNS: &namespace{NSMode: "container", Value: "target"},
+		},
+		{
+			name:       "container with --userns=keep-id",
+			usernsMode: "keep-id",
+			wantNetNS:  &namespace{NSMode: "container", Value: "target"},
+			wantUserNS: &namespace{NSMode: "container", Value: "target"},
+		},
+		{
+			name:       "non root container with --userns=keep-id",
+			usernsMode: "keep-id:uid=1000,gid=1000",
+			targetUser: "1000:1000",
+			user:       "nonroot:nonroot",
+			wantNetNS:  &namespace{NSMode: "container", Value: "target"},
+			wantUserNS: &namespace{NSMode: "container", Value: "target"},
+			wantUser:   "1000:1000",
+		},
+		{
+			name:       "non root container in the user namespace of podman",
+			targetUser: "1000",
+			user:       "nonroot:nonroot",
+			wantNetNS:  &namespace{NSMode: "container", Value: "target"},
+			wantUser:   "nonroot:nonroot",
+		},
+		{
+			name:       "container with --userns=host",
+			usernsMode: "host",
+			wantNetNS:  &namespace{NSMode: "container", Value: "target"},
+		},
+		{
+			name:       "container in a pod",
+			pod:        "pod-id",
+			usernsMode: "keep-id",
+			wantPod:    "pod-id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &containerInspect{ID: "target", Pod: tt.pod}
+			target.HostConfig.UsernsMode = tt.usernsMode
+			target.HostConfig.CapAdd = []string{"NET_ADMIN"}
+			target.Config.User = tt.targetUser
+			user, wantUser := "root:root", "root:root"
+			if tt.user != "" {
+				user, wantUser = tt.user, tt.wantUser
+			}
+
+			spec := debuggerSpec(target, exec.DefaultShare, "busybox", "echo hi", user, "dbg", true, true)
+			if spec.User != wantUser {
+				t.Errorf("user = %q, want %q", spec.User, wantUser)
+			}
+			if !reflect.DeepEqual(spec.PidNS, &namespace{NSMode: "container", Value: "target"}) {
+				t.Errorf("pidns = %+v, want the target one", spec.PidNS)
+			}
+			if spec.Pod != tt.wantPod {
+				t.Errorf("pod = %q, want %q", spec.Pod, tt.wantPod)
+			}
+			if !reflect.DeepEqual(spec.NetNS, tt.wantNetNS) {
+				t.Errorf("netns = %+v, want %+v", spec.NetNS, tt.wantNetNS)
+			}
+			if !reflect.DeepEqual(spec.UserNS, tt.wantUserNS) {
+				t.Errorf("userns = %+v, want %+v", spec.UserNS, tt.wantUserNS)
+			}
+			if !reflect.DeepEqual(spec.CapAdd, []string{"NET_ADMIN"}) {
+				t.Errorf("spec = %+v", spec)
+			}
+		})
+	}
+}
+
+func TestDebuggerSpecShare(t *testing.T) {
+	target := &containerInspect{ID: "target", Pod: "pod-id"}
+	target.HostConfig.UsernsMode = "keep-id"
+
+	// out of the network of the pod, the debugger is out of the pod
+	spec := debuggerSpec(target, exec.Share{Pid: true, IPC: true, UTS: true}, "busybox", "echo hi", "root:root", "dbg", false, false)
+	want := &namespace{NSMode: "container", Value: "target"}
+	if spec.Pod != "" || spec.NetNS != nil {
+		t.Errorf("pod = %q, netns = %+v, want the network of the debugger", spec.Pod, spec.NetNS)
+	}
+	if !reflect.DeepEqual(spec.PidNS, want) || !reflect.DeepEqual(spec.IpcNS, want) || !reflect.DeepEqual(spec.UtsNS, want) {
+		t.Errorf("pidns = %+v, ipcns = %+v, utsns = %+v, want the target ones", spec.PidNS, spec.IpcNS, spec.UtsNS)
+	}
+	if !reflect.DeepEqual(spec.UserNS, want) {
+		t.Errorf("userns = %+v, want the target one", spec.UserNS)
+	}
+
+	spec = debuggerSpec(target, exec.Share{Net: true}, "busybox", "echo hi", "root:root", "dbg", false, false)
+	if spec.Pod != "pod-id" || spec.PidNS != nil || spec.IpcNS != nil || spec.UtsNS != nil {
+		t.Errorf("spec = %+v, want the pod only", spec)
+	}
+}
+
+func TestCreateContainerPodShare(t *testing.T) {
+	tests := []struct {
+		name     string
+		share    exec.Share
+		shareSet bool
+		wantErr  string
+	}{
+		{name: "default namespaces", share: exec.DefaultShare},
+		{name: "namespaces of the pod", share: exec.Share{Net: true, Pid: true, IPC: true, UTS: true, Mnt: true}, shareSet: true},
+		{name: "private uts", share: exec.Share{Net: true, Pid: true, IPC: true, Mnt: true}, shareSet: true, wantErr: "add uts to --share"},
+		// out of the network of the pod, the debugger is out of the pod
+		{name: "out of the pod", share: exec.Share{Pid: true, Mnt: true}, shareSet: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created *specGenerator
+			c := fakeLibpod(t, func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/pods/pod-id/json":
+					w.Write([]byte(`{"Id":"pod-id","SharedNamespaces":["ipc","net","uts"]}`))
+				case "/containers/create":
+					created = &specGenerator{}
+					json.NewDecoder(r.Body).Decode(created)
+					w.Write([]byte(`{"Id":"debugger-id"}`))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			})
+			c.target = &containerInspect{ID: "target", Pod: "pod-id"}
+			c.share, c.shareSet = tt.share, tt.shareSet
+
+			id, err := c.CreateContainer(context.Background(), &exec.ContainerInspectInfo{ID: "target"},
+				"busybox", "echo hi", "root:root", "dbg", nil, false, false, "")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || created != nil {
+					t.Errorf("CreateContainer() error = %v, want %q and no container", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || id != "debugger-id" {
+				t.Fatalf("CreateContainer() = %q, %v", id, err)
+			}
+		})
+	}
+}
+
+func TestRunDebugger(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		options    []exec.Option
+		wantUser   string
+		wantUserNS *namespace
+	}{
+		{
+			name:     "root container",
+			target:   `{"Id":"target-id","State":{"Running":true,"Pid":4242},"Config":{"User":""}}`,
+			wantUser: "root:root",
+		},
+		{
+			name:       "non root container with --userns=keep-id",
+			target:     `{"Id":"target-id","State":{"Running":true,"Pid":4242},"Config":{"User":"1000:1000"},"HostConfig":{"UsernsMode":"keep-id"}}`,
+			options:    []exec.Option{exec.WithUser("app:1000::app:1000")},
+			wantUser:   "1000:1000",
+			wantUserNS: &namespace{NSMode: "container", Value: "target-id"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var calls []string
+			var created specGenerator
+			c := fakeLibpod(t, func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasPrefix(r.URL.Path, "/containers/debugger-id") || r.URL.Path == "/containers/create" {
+					mu.Lock()
+					calls = append(calls, r.Method+" "+r.URL.Path)
+					mu.Unlock()
+				}
+				switch r.URL.Path {
+				case "/containers/target/json":
+					w.Write([]byte(tt.target))
+				case "/images/pull":
+					w.Write([]byte(`{"stream":"pulled\n"}`))
+				case "/containers/create":
+					json.NewDecoder(r.Body).Decode(&created)
+					w.Write([]byte(`{"Id":"debugger-id"}`))
+				case "/containers/debugger-id/attach":
+					conn, buf, err := w.(http.Hijacker).Hijack()
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					defer conn.Close()
+					buf.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+					stdcopy.NewStdWriter(buf, stdcopy.Stdout).Write([]byte("hello\n"))
+					buf.Flush()
+				case "/containers/debugger-id/start":
+					w.WriteHeader(http.StatusNoContent)
+				case "/containers/debugger-id/wait":
+					w.Write([]byte("3"))
+				case "/containers/debugger-id":
+					w.Write([]byte(`[{"Id":"debugger-id"}]`))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			})
+			c.share = exec.DefaultShare
+
+			var stdout bytes.Buffer
+			cliStream := iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), &stdout, io.Discard)
+			opts, err := exec.New(append([]exec.Option{
+				exec.WithTarget("target"),
+				exec.WithCommand([]string{"echo", "hello"}),
+				exec.WithDebuggerImage(""),
+			}, tt.options...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = exec.RunDebugger(context.Background(), c, opts, cliStream)
+			var statusErr iocli.StatusError
+			if !errors.As(err, &statusErr) || statusErr.Code() != 3 {
+				t.Errorf("RunDebugger() error = %v, want exit status 3", err)
+			}
+			if stdout.String() != "hello\n" {
+				t.Errorf("RunDebugger() stdout = %q, want %q", stdout.String(), "hello\n")
+			}
+
+			// the debugger is attached before it starts, and removed once it exited
+			want := []string{
+				"POST /containers/create",
+				"POST /containers/debugger-id/attach",
+				"POST /containers/debugger-id/start",
+				"POST /containers/debugger-id/wait",
+				"DELETE /containers/debugger-id",
+			}
+			if !reflect.DeepEqual(calls, want) {
+				t.Errorf("calls = %q, want %q", calls, want)
+			}
+			if created.User != tt.wantUser {
+				t.Errorf("user = %q, want %q", created.User, tt.wantUser)
+			}
+			if !reflect.DeepEqual(created.UserNS, tt.wantUserNS) {
+				t.Errorf("userns = %+v, want %+v", created.UserNS, tt.wantUserNS)
+			}
+			if !reflect.DeepEqual(created.PidNS, &namespace{NSMode: "container", Value: "target-id"}) {
+				t.Errorf("pidns = %+v, want the target one", created.PidNS)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package podman
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+)
+
+// event is the part of a libpod event used by conxec.
+type event struct {
+	Action string `json:"Action"`
+	Actor  struct {
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+}
+
+// WatchTarget reports the death of the target from the libpod events, the
+// inspect of the dead container tells whether it was OOM killed.
+func (c *PodmanClient) WatchTarget(ctx context.Context, targetID string) (<-chan exec.TargetExit, error) {
+	filters, err := json.Marshal(map[string][]string{
+		"type":      {"container"},
+		"container": {targetID},
+		"event":     {"died"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.request(ctx, http.MethodGet, "/events", url.Values{
+		"stream":  {"true"},
+		"filters": {string(filters)},
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch the target: %w", err)
+	}
+
+	exits := make(chan exec.TargetExit, 1)
+	go func() {
+		defer resp.Body.Close()
+		var ev event
+		if err := json.NewDecoder(resp.Body).Decode(&ev); err != nil {
+			if ctx.Err() == nil {
+				fmt.Fprintf(c.out, "stopped watching the target: %s\n", err)
+			}
+			return
+		}
+		exit := exec.TargetExit{}
+		exit.ExitCode, _ = strconv.Atoi(ev.Actor.Attributes["containerExitCode"])
+		var inspect containerInspect
+		if err := c.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(targetID)+"/json", nil, nil, &inspect); err == nil {
+			exit.OOMKilled = inspect.State.OOMKilled
+		}
+		exits <- exit
+	}()
+	return exits, nil
+}
@@ -0,0 +1,52 @@
+package podman
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/docker/cli/cli/streams"
+)
+
+func TestWatchTarget(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/" + apiVersion + "/libpod/events":
+			if r.URL.Query().Get("filters") != `{"container":["0123456789abcdef"],"event":["died"],"type":["container"]}` {
+				t.Errorf("filters = %s, want the deaths of the target", r.URL.Query().Get("filters"))
+			}
+			w.Write([]byte(`{"Type":"container","Action":"died","Actor":{"ID":"0123456789abcdef","Attributes":{"containerExitCode":"137"}}}` + "\n"))
+		case "/" + apiVersion + "/libpod/containers/0123456789abcdef/json":
+			w.Write([]byte(`{"Id":"0123456789abcdef","State":{"Running":false,"OOMKilled":true}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c := &PodmanClient{
+		client: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "tcp", server.Listener.Addr().String())
+			},
+		}},
+		out: streams.NewOut(io.Discard),
+	}
+	exits, err := c.WatchTarget(context.Background(), "0123456789abcdef")
+	if err != nil {
+		t.Fatalf("WatchTarget() error = %v", err)
+	}
+	select {
+	case exit := <-exits:
+		if want := (exec.TargetExit{ExitCode: 137, OOMKilled: true}); exit != want {
+			t.Errorf("exit = %+v, want %+v", exit, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("exit of the target was not reported")
+	}
+}
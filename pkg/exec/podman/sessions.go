@@ -0,0 +1,109 @@
+package podman
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"syscall"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// listContainer is the part of the libpod container list used by conxec.
+type listContainer struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	State  string            `json:"State"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// ListSessions returns the debugger containers, including the exited ones
+// which are not removed yet.
+func (c *PodmanClient) ListSessions(ctx context.Context) ([]exec.Session, error) {
+	filters, err := json.Marshal(map[string][]string{"label": {exec.LabelSession}})
+	if err != nil {
+		return nil, err
+	}
+	var containers []listContainer
+	if err := c.do(ctx, http.MethodGet, "/containers/json", url.Values{
+		"all":     {"true"},
+		"filters": {string(filters)},
+	}, nil, &containers); err != nil {
+		return nil, fmt.Errorf("failed to list debugger containers: %w", err)
+	}
+	sessions := make([]exec.Session, 0, len(containers))
+	for _, cont := range containers {
+		name := cont.ID[:min(12, len(cont.ID))]
+		if len(cont.Names) > 0 {
+			name = cont.Names[0]
+		}
+		sessions = append(sessions, exec.SessionFromLabels(name, cont.State, cont.Labels))
+	}
+	return sessions, nil
+}
+
+// StopSession stops the debugger container and removes it. The entrypoint
+// gets SIGTERM to end the command and clean up the target, the container is
+// only killed when it is still running after exec.SessionStopTimeout.
+func (c *PodmanClient) StopSession(ctx context.Context, id string) error {
+	inspect, err := c.sessionInspect(ctx, id)
+	if err != nil {
+		return err
+	}
+	if inspect.State.Running {
+		if err := c.SignalContainer(ctx, id, syscall.SIGTERM); err != nil {
+			return fmt.Errorf("failed to stop debugger container: %w", err)
+		}
+		waitCtx, cancel := context.WithTimeout(ctx, exec.SessionStopTimeout)
+		// the wait ends once the debugger is stopped or exited
+		_ = c.do(waitCtx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/wait", nil, nil, nil)
+		cancel()
+	}
+	if err := c.do(ctx, http.MethodDelete, "/containers/"+url.PathEscape(id), url.Values{
+		"force": {"true"},
+	}, nil, nil); err != nil {
+		return fmt.Errorf("failed to remove debugger container: %w", err)
+	}
+	return nil
+}
+
+// SessionLogs writes the output of the debugger container.
+func (c *PodmanClient) SessionLogs(ctx context.Context, id string, follow bool, stdout, stderr io.Writer) error {
+	inspect, err := c.sessionInspect(ctx, id)
+	if err != nil {
+		return err
+	}
+	resp, err := c.request(ctx, http.MethodGet, "/containers/"+url.PathEscape(id)+"/logs", url.Values{
+		"stdout": {"true"},
+		"stderr": {"true"},
+		"follow": {fmt.Sprint(follow)},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get debugger logs: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if inspect.Config.Tty {
+		_, err = io.Copy(stdout, resp.Body)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, resp.Body)
+	}
+	return err
+}
+
+// sessionInspect inspects the container and checks it is a debugger, so the
+// sessions commands can't be used on other containers.
+func (c *PodmanClient) sessionInspect(ctx context.Context, id string) (*containerInspect, error) {
+	var inspect containerInspect
+	if err := c.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(id)+"/json", nil, nil, &inspect); err != nil {
+		return nil, fmt.Errorf("Failed to inspect debugger container: %w", err)
+	}
+	if _, ok := inspect.Config.Labels[exec.LabelSession]; !ok {
+		return nil, fmt.Errorf("container %q is not a conxec debugger session", id)
+	}
+	return &inspect, nil
+}
@@ -0,0 +1,103 @@
+package podman
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+)
+
+func TestListSessions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+apiVersion+"/libpod/containers/json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("all") != "true" || r.URL.Query().Get("filters") != `{"label":["`+exec.LabelSession+`"]}` {
+			t.Errorf("query = %s, want all the debugger containers", r.URL.RawQuery)
+		}
+		w.Write([]byte(`[{"Id":"0123456789abcdef","Names":["conxec-debugger-1a2b3c4d"],"State":"exited",
+			"Labels":{"io.conxec.session":"1a2b3c4d","io.conxec.target":"web","io.conxec.command":"ps aux",
+			"io.conxec.user":"root:root","io.conxec.started":"2023-11-02T10:00:00Z"}}]`))
+	}))
+	defer server.Close()
+
+	c := &PodmanClient{client: &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "tcp", server.Listener.Addr().String())
+		},
+	}}}
+	sessions, err := c.ListSessions(context.Background())
+	if err != nil {
+		t.Fatalf("ListSessions() error = %v", err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("ListSessions() = %+v, want one session", sessions)
+	}
+	s := sessions[0]
+	if s.ID != "conxec-debugger-1a2b3c4d" || s.RunID != "1a2b3c4d" || s.Target != "web" || s.Command != "ps aux" || s.Status != "exited" || s.Started.IsZero() {
+		t.Errorf("session = %+v", s)
+	}
+}
+
+func TestStopSession(t *testing.T) {
+	tests := []struct {
+		name    string
+		running bool
+		want    []string
+	}{
+		{
+			// the entrypoint cleans up the target before the container is removed
+			name:    "running",
+			running: true,
+			want: []string{
+				"GET /containers/conxec-debugger-1a2b3c4d/json",
+				"POST /containers/conxec-debugger-1a2b3c4d/kill?signal=15",
+				"POST /containers/conxec-debugger-1a2b3c4d/wait",
+				"DELETE /containers/conxec-debugger-1a2b3c4d?force=true",
+			},
+		},
+		{
+			name: "exited",
+			want: []string{
+				"GET /containers/conxec-debugger-1a2b3c4d/json",
+				"DELETE /containers/conxec-debugger-1a2b3c4d?force=true",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			c := fakeLibpod(t, func(w http.ResponseWriter, r *http.Request) {
+				call := r.Method + " " + r.URL.Path
+				if r.URL.RawQuery != "" {
+					call += "?" + r.URL.RawQuery
+				}
+				calls = append(calls, call)
+				switch r.URL.Path {
+				case "/containers/conxec-debugger-1a2b3c4d/json":
+					fmt.Fprintf(w, `{"Id":"debugger-id","State":{"Running":%t},"Config":{"Labels":{"io.conxec.session":"1a2b3c4d"}}}`, tt.running)
+				case "/containers/conxec-debugger-1a2b3c4d/wait":
+					w.Write([]byte("143"))
+				case "/containers/conxec-debugger-1a2b3c4d/kill":
+					w.WriteHeader(http.StatusNoContent)
+				case "/containers/conxec-debugger-1a2b3c4d":
+					w.Write([]byte(`[{"Id":"debugger-id"}]`))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			})
+			if err := c.StopSession(context.Background(), "conxec-debugger-1a2b3c4d"); err != nil {
+				t.Fatalf("StopSession() error = %v", err)
+			}
+			if !reflect.DeepEqual(calls, tt.want) {
+				t.Errorf("calls = %q, want %q", calls, tt.want)
+			}
+		})
+	}
+}
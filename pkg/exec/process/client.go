@@ -0,0 +1,203 @@
+package process
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+	"github.com/docker/cli/cli/streams"
+)
+
+// HelperCommand is the hidden conxec command running the debugger in the
+// namespaces of the target, see RunHelper.
+const HelperCommand = "__pid-helper"
+
+const (
+	helperStageInit = "init"
+	helperStageExec = "exec"
+)
+
+// ProcessClient debugs a process of the host directly, without any container
+// runtime. The debugger image is unpacked from a local OCI layout and the
+// debugger joins the namespaces of the target process through a helper
+// re-executed from the conxec binary.
+type ProcessClient struct {
+	layout   *ociLayout
+	cacheDir string
+	out      *streams.Out
+
+	rootfs      string
+	sessions    map[string]*session
+	sessionsDir string
+	share       exec.Share
+}
+
+type session struct {
+	pid        int
+	entrypoint string
+	user       string
+	mountDir   string
+	labels     map[string]string
+}
+
+// NewClient uses the OCI layout given as runtime address, or the one in the
+// user cache directory (~/.cache/conxec/oci).
+func NewClient(ctx context.Context, opts *exec.ExecOptions, clistream *iocli.CliStream) (*ProcessClient, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("pid:// targets are not supported on %s", runtime.GOOS)
+	}
+	if os.Geteuid() != 0 {
+		return nil, errors.New("pid:// targets require root to enter the namespaces of the process")
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the cache directory: %w", err)
+	}
+	cacheDir = filepath.Join(cacheDir, "conxec")
+
+	layoutDir := opts.Runtime
+	if layoutDir == "" {
+		layoutDir = filepath.Join(cacheDir, "oci")
+	}
+	c := newClient(layoutDir, cacheDir, clistream)
+	c.share = opts.Share
+	return c, nil
+}
+
+func newClient(layoutDir, cacheDir string, clistream *iocli.CliStream) *ProcessClient {
+	return &ProcessClient{
+		layout:      &ociLayout{dir: layoutDir},
+		cacheDir:    cacheDir,
+		out:         clistream.AuxStream(),
+		sessions:    map[string]*session{},
+		sessionsDir: defaultSessionsDir,
+		share:       exec.DefaultShare,
+	}
+}
+
+// GetContainerInfo inspects the process from /proc. The pid is the one the
+// process has in its own PID namespace, which is where the debugger runs.
+func (c *ProcessClient) GetContainerInfo(ctx context.Context, target string) (*exec.ContainerInspectInfo, error) {
+	pid, err := strconv.Atoi(target)
+	if err != nil || pid <= 0 {
+		return nil, fmt.Errorf("invalid pid %q", target)
+	}
+	status, err := readStatus(pid)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to inspect process %d: %w", pid, err)
+	}
+
+	info := &exec.ContainerInspectInfo{
+		ID:            target,
+		Name:          status["Name"],
+		Isrunning:     !strings.HasPrefix(status["State"], "Z") && !strings.HasPrefix(status["State"], "X"),
+		IsPidModeHost: true,
+		Pid:           pid,
+		Platform:      runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if uids := strings.Fields(status["Uid"]); len(uids) > 1 {
+		info.User = uids[1]
+	}
+	if nspids := strings.Fields(status["NSpid"]); len(nspids) > 0 {
+		info.Pid, err = strconv.Atoi(nspids[len(nspids)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid NSpid of process %d: %w", pid, err)
+		}
+	}
+	return info, nil
+}
+
+func readStatus(pid int) (map[string]string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	status := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok {
+			status[key] = strings.TrimSpace(value)
+		}
+	}
+	return status, scanner.Err()
+}
+
+// PullImage unpacks the image from the OCI layout, images are never pulled
+// from a registry.
+func (c *ProcessClient) PullImage(ctx context.Context, image string, platform string) error {
+	rootfs, err := c.layout.unpack(image, c.cacheDir)
+	if err != nil {
+		return err
+	}
+	c.rootfs = rootfs
+	fmt.Fprintf(c.out, "Using debugger root filesystem %s\n", rootfs)
+	return nil
+}
+
+// CreateContainer only prepares the session, the debugger is started by
+// AttachContainer.
+func (c *ProcessClient) CreateContainer(ctx context.Context, targetInspect *exec.ContainerInspectInfo,
+	image, entrypoint, user, containerName string, labels map[string]string,
+	tty, stdin bool, mountDir string,
+) (string, error) {
+	if c.rootfs == "" {
+		return "", fmt.Errorf("debugger image %q is not unpacked", image)
+	}
+	pid, err := strconv.Atoi(targetInspect.ID)
+	if err != nil {
+		return "", fmt.Errorf("invalid pid %q", targetInspect.ID)
+	}
+	if mountDir != "" {
+		if mountDir, err = filepath.Abs(mountDir); err != nil {
+			return "", err
+		}
+	}
+	c.sessions[containerName] = &session{
+		pid:        pid,
+		entrypoint: entrypoint,
+		user:       user,
+		mountDir:   mountDir,
+		labels:     labels,
+	}
+	return containerName, nil
+}
+
+// AttachContainer runs the debugger in the namespaces of the target and waits
+// for it. The debugger uses the terminal of conxec directly, it is registered
+// as a session while it runs.
+func (c *ProcessClient) AttachContainer(ctx context.Context, containerID string, tty, stdin bool, cliStream *iocli.CliStream) error {
+	s, ok := c.sessions[containerID]
+	if !ok {
+		return fmt.Errorf("unknown debugger %q", containerID)
+	}
+	defer delete(c.sessions, containerID)
+	if tty && cliStream.Recorder() != nil {
+		return fmt.Errorf("sessions of pid:// targets with a TTY can't be recorded, the debugger uses the terminal of conxec")
+	}
+
+	scratch, err := os.MkdirTemp("", "conxec-pid-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratch)
+
+	defer c.unregister(containerID)
+	return runHelper(ctx, []string{
+		helperStageInit, strconv.Itoa(s.pid), c.rootfs, scratch, s.mountDir, c.share.String(), s.user, s.entrypoint,
+	}, stdin, cliStream, func(pid int) {
+		if err := c.register(containerID, pid, s.labels); err != nil {
+			fmt.Fprintf(c.out, "failed to register debugger session: %s\n", err)
+		}
+	})
+}
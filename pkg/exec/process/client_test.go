@@ -0,0 +1,107 @@
+package process
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+)
+
+func TestGetContainerInfo(t *testing.T) {
+	if _, err := os.Stat("/proc/self/status"); err != nil {
+		t.Skip("/proc is not available")
+	}
+	c := newClient(t.TempDir(), t.TempDir(), iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard))
+
+	pid := strconv.Itoa(os.Getpid())
+	info, err := c.GetContainerInfo(context.Background(), pid)
+	if err != nil {
+		t.Fatalf("GetContainerInfo() error = %v", err)
+	}
+	if info.ID != pid || !info.Isrunning || !info.IsPidModeHost || info.Pid <= 0 {
+		t.Errorf("GetContainerInfo() = %+v", *info)
+	}
+	if info.User != strconv.Itoa(os.Geteuid()) {
+		t.Errorf("User = %q, want %d", info.User, os.Geteuid())
+	}
+
+	for _, target := range []string{"nginx", "0", "-1"} {
+		if _, err := c.GetContainerInfo(context.Background(), target); err == nil {
+			t.Errorf("GetContainerInfo(%q) succeeded, want an error", target)
+		}
+	}
+}
+
+func TestSessions(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("/proc is not available")
+	}
+	c := newClient(t.TempDir(), t.TempDir(), iocli.NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard))
+	c.sessionsDir = t.TempDir()
+
+	// a shell with a child stands for the helper and the debugger
+	helper := osexec.Command("sh", "-c", "sleep 60 & wait")
+	if err := helper.Start(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- helper.Wait() }()
+
+	labels := exec.SessionLabels("1a2b3c4d", "4242", []string{"sh"}, "root:root", time.Now())
+	if err := c.register("conxec-debugger-1a2b3c4d", helper.Process.Pid, labels); err != nil {
+		t.Fatal(err)
+	}
+	// a session whose helper is gone is pruned
+	if err := os.WriteFile(filepath.Join(c.sessionsDir, "conxec-debugger-gone.json"), []byte(`{"pid":1,"started":1}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := c.ListSessions(context.Background())
+	if err != nil {
+		t.Fatalf("ListSessions() error = %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != "conxec-debugger-1a2b3c4d" || sessions[0].Target != "4242" {
+		t.Fatalf("ListSessions() = %+v, want the running session", sessions)
+	}
+	if _, err := os.Stat(filepath.Join(c.sessionsDir, "conxec-debugger-gone.json")); err == nil {
+		t.Errorf("registration of the gone session was not removed")
+	}
+
+	// wait for the child of the shell before stopping
+	for i := 0; i < 50 && len(descendants(helper.Process.Pid)) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	children := descendants(helper.Process.Pid)
+	if err := c.StopSession(context.Background(), "conxec-debugger-1a2b3c4d"); err != nil {
+		t.Fatalf("StopSession() error = %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("helper was not killed")
+	}
+	for _, pid := range children {
+		// killed children are gone, or zombies until reaped by init
+		alive := func() bool {
+			fields, err := stat(pid)
+			return err == nil && fields[0] != "Z"
+		}
+		for i := 0; i < 50 && alive(); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if alive() {
+			t.Errorf("debugger process %d was not killed", pid)
+		}
+	}
+	if err := c.StopSession(context.Background(), "conxec-debugger-1a2b3c4d"); err == nil {
+		t.Errorf("StopSession() of a stopped session succeeded")
+	}
+}
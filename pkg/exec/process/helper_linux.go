@@ -0,0 +1,304 @@
+//go:build linux
+
+package process
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	osexec "os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+	"github.com/debasishbsws/conxec/pkg/iocli"
+	"golang.org/x/sys/unix"
+)
+
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// runHelper re-executes conxec as the helper in a new mount namespace, so the
+// mounts of the debugger never show up on the host. started is called with
+// the pid of the helper once it runs.
+func runHelper(ctx context.Context, args []string, stdin bool, cliStream *iocli.CliStream, started func(pid int)) error {
+	cmd := osexec.CommandContext(ctx, "/proc/self/exe", append([]string{HelperCommand}, args...)...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Unshareflags: syscall.CLONE_NEWNS}
+	// the terminal is given to the debugger, unless the streams are recorded
+	recorder := cliStream.Recorder()
+	if stdin {
+		cmd.Stdin = recorder.TeeInput(cliStream.InputStream())
+		if f := terminalFile(cliStream.InputStream()); f != nil && recorder == nil {
+			cmd.Stdin = f
+		}
+	}
+	cmd.Stdout, cmd.Stderr = recorder.TeeOutput(cliStream.OutputStream()), recorder.TeeOutput(cliStream.ErrorStream())
+	if f := terminalFile(cliStream.OutputStream()); f != nil && recorder == nil {
+		cmd.Stdout, cmd.Stderr = f, f
+	}
+
+	defer ignoreInterrupts()()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	defer forwardTerminations(cmd.Process)()
+	started(cmd.Process.Pid)
+	err := cmd.Wait()
+	var exitErr *osexec.ExitError
+	if errors.As(err, &exitErr) {
+		return exec.ExitStatus(exitCode(exitErr))
+	}
+	return err
+}
+
+// exitCode returns the exit code the way shells do, 128+n for a debugger
+// killed by the signal n.
+func exitCode(exitErr *osexec.ExitError) int {
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
+
+type terminalStream interface {
+	FD() uintptr
+	IsTerminal() bool
+}
+
+// terminalFile returns the terminal of the stream, so the debugger gets a
+// real tty, or nil when the stream is not a terminal.
+func terminalFile(s terminalStream) *os.File {
+	if !s.IsTerminal() {
+		return nil
+	}
+	return os.NewFile(s.FD(), "tty")
+}
+
+// ignoreInterrupts keeps conxec alive on ^C and ^\ while the debugger shares
+// the terminal. The signals are caught rather than ignored, an ignored signal
+// would stay ignored in the debugger.
+func ignoreInterrupts() (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGQUIT)
+	return func() { signal.Stop(sigs) }
+}
+
+// forwardTerminations passes SIGTERM and SIGHUP to the process, so conxec and
+// the helper outlive them and the entrypoint cleans up before the debugger
+// exits. ^C and ^\ are not passed, the terminal sends them to every process.
+func forwardTerminations(p *os.Process) (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				p.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+// RunHelper is the entrypoint of the hidden helper command. The "init" stage
+// prepares the mounts and joins the net and PID namespaces of the target, the
+// "exec" stage is its child, the first process of the debugger in the PID
+// namespace of the target, which chroots into the debugger root filesystem.
+func RunHelper(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing helper stage")
+	}
+	var err error
+	switch args[0] {
+	case helperStageInit:
+		err = helperInit(args[1:])
+	case helperStageExec:
+		err = helperExec(args[1:])
+	default:
+		return fmt.Errorf("unknown helper stage %q", args[0])
+	}
+
+	var exitErr *osexec.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitCode(exitErr))
+	}
+	return err
+}
+
+// helperInit args: <pid> <rootfs> <scratch dir> <mount dir> <namespaces> <user> <entrypoint>
+func helperInit(args []string) error {
+	if len(args) != 7 {
+		return fmt.Errorf("%s: expected 7 arguments, got %d", helperStageInit, len(args))
+	}
+	pid, rootfs, scratch, mountDir, namespaces, user, entrypoint := args[0], args[1], args[2], args[3], args[4], args[5], args[6]
+
+	defer ignoreInterrupts()()
+
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make the mounts private: %w", err)
+	}
+	root, err := mountRootfs(rootfs, scratch)
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{"proc", "dev", "tmp", "work"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			return err
+		}
+	}
+	if err := unix.Mount("/dev", filepath.Join(root, "dev"), "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to mount /dev: %w", err)
+	}
+	if mountDir != "" {
+		if err := unix.Mount(mountDir, filepath.Join(root, "work"), "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return fmt.Errorf("failed to mount %s: %w", mountDir, err)
+		}
+	}
+
+	// setns(2) of the net namespace only applies to the calling thread and
+	// the PID namespace only to its children, so the debugger must be
+	// started from this very thread. The mount namespace is always the one
+	// of the helper, mnt is the chroot of the entrypoint.
+	runtime.LockOSThread()
+	flags := map[string]int{"net": unix.CLONE_NEWNET, "pid": unix.CLONE_NEWPID, "ipc": unix.CLONE_NEWIPC, "uts": unix.CLONE_NEWUTS}
+	for _, name := range strings.Split(namespaces, ",") {
+		flag, ok := flags[name]
+		if !ok {
+			continue
+		}
+		if err := setns(pid, name, flag); err != nil {
+			return err
+		}
+	}
+
+	cmd := osexec.Command("/proc/self/exe", HelperCommand, helperStageExec, root, user, entrypoint)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	defer forwardTerminations(cmd.Process)()
+	return cmd.Wait()
+}
+
+// mountRootfs mounts an overlay of the root filesystem on a tmpfs, so the
+// debugger can write to it without touching the unpacked image. It falls back
+// to a bind mount where overlayfs is not available.
+func mountRootfs(rootfs, scratch string) (string, error) {
+	if err := unix.Mount("tmpfs", scratch, "tmpfs", 0, "mode=0755"); err != nil {
+		return "", fmt.Errorf("failed to mount tmpfs: %w", err)
+	}
+	upper, work, merged := filepath.Join(scratch, "upper"), filepath.Join(scratch, "work"), filepath.Join(scratch, "merged")
+	for _, dir := range []string{upper, work, merged} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			return "", err
+		}
+	}
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", rootfs, upper, work)
+	if err := unix.Mount("overlay", merged, "overlay", 0, data); err == nil {
+		return merged, nil
+	}
+	if err := unix.Mount(rootfs, merged, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return "", fmt.Errorf("failed to mount the debugger root filesystem: %w", err)
+	}
+	return merged, nil
+}
+
+func setns(pid, name string, flag int) error {
+	f, err := os.Open(filepath.Join("/proc", pid, "ns", name))
+	if err != nil {
+		return fmt.Errorf("failed to open %s namespace of process %s: %w", name, pid, err)
+	}
+	defer f.Close()
+	if err := unix.Setns(int(f.Fd()), flag); err != nil {
+		return fmt.Errorf("failed to join %s namespace of process %s: %w", name, pid, err)
+	}
+	return nil
+}
+
+// helperExec args: <root> <user> <entrypoint>
+func helperExec(args []string) error {
+	if len(args) != 3 {
+		return fmt.Errorf("%s: expected 3 arguments, got %d", helperStageExec, len(args))
+	}
+	root, user, entrypoint := args[0], args[1], args[2]
+
+	// /proc of the debugger shows the PID namespace of the target
+	if err := unix.Mount("proc", filepath.Join(root, "proc"), "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("failed to mount /proc: %w", err)
+	}
+	if err := unix.Chroot(root); err != nil {
+		return fmt.Errorf("failed to chroot: %w", err)
+	}
+	if err := os.Chdir("/"); err != nil {
+		return err
+	}
+
+	uid, gid, home, err := lookupUser(user)
+	if err != nil {
+		return err
+	}
+	if err := unix.Setgroups([]int{gid}); err != nil {
+		return err
+	}
+	if err := unix.Setgid(gid); err != nil {
+		return err
+	}
+	if err := unix.Setuid(uid); err != nil {
+		return err
+	}
+
+	env := []string{"PATH=" + defaultPath, "HOME=" + home}
+	if term := os.Getenv("TERM"); term != "" {
+		env = append(env, "TERM="+term)
+	}
+	return unix.Exec("/bin/sh", []string{"sh", "-c", entrypoint}, env)
+}
+
+// lookupUser resolves "<user>:<group>" in /etc/passwd of the debugger root
+// filesystem, the group of the user is used.
+func lookupUser(user string) (uid, gid int, home string, err error) {
+	name, _, _ := strings.Cut(user, ":")
+	if name == "root" || name == "0" {
+		return 0, 0, "/root", nil
+	}
+	f, err := os.Open("/etc/passwd")
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("failed to look up user %q: %w", name, err)
+	}
+	defer f.Close()
+	return parsePasswd(f, name)
+}
+
+func parsePasswd(r io.Reader, name string) (uid, gid int, home string, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// name:password:uid:gid:gecos:home:shell
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 7 || fields[0] != name {
+			continue
+		}
+		if uid, err = strconv.Atoi(fields[2]); err != nil {
+			return 0, 0, "", fmt.Errorf("invalid uid of user %q: %w", name, err)
+		}
+		if gid, err = strconv.Atoi(fields[3]); err != nil {
+			return 0, 0, "", fmt.Errorf("invalid gid of user %q: %w", name, err)
+		}
+		return uid, gid, fields[5], nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, "", err
+	}
+	return 0, 0, "", fmt.Errorf("user %q not found in the debugger image", name)
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package process
+
+import (
+	"context"
+	"errors"
+
+	"github.com/debasishbsws/conxec/pkg/iocli"
+)
+
+var errNotSupported = errors.New("pid:// targets are only supported on linux")
+
+func runHelper(ctx context.Context, args []string, stdin bool, cliStream *iocli.CliStream, started func(pid int)) error {
+	return errNotSupported
+}
+
+// RunHelper is the entrypoint of the hidden helper command.
+func RunHelper(args []string) error {
+	return errNotSupported
+}
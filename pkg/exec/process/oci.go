@@ -0,0 +1,330 @@
+package process
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// containerdImageNameAnnotation is set by "ctr export" and "nerdctl save".
+	containerdImageNameAnnotation = "io.containerd.image.name"
+
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+
+	unpackedMarker = ".conxec-unpacked"
+)
+
+// ociLayout is an OCI image layout directory holding cached images, e.g:
+// populated with "skopeo copy docker://busybox:musl oci:<dir>:busybox:musl".
+type ociLayout struct {
+	dir string
+}
+
+func (l *ociLayout) readBlob(d digest.Digest, v any) error {
+	f, err := l.openBlob(d)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(v)
+}
+
+func (l *ociLayout) openBlob(d digest.Digest) (*os.File, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid digest %q: %w", d, err)
+	}
+	return os.Open(filepath.Join(l.dir, "blobs", d.Algorithm().String(), d.Encoded()))
+}
+
+// findManifest returns the manifest of the image for the platform.
+func (l *ociLayout) findManifest(image string) (ocispec.Descriptor, ocispec.Manifest, error) {
+	var index ocispec.Index
+	f, err := os.Open(filepath.Join(l.dir, "index.json"))
+	if err != nil {
+		return ocispec.Descriptor{}, ocispec.Manifest{}, fmt.Errorf("failed to open OCI layout: %w", err)
+	}
+	err = json.NewDecoder(f).Decode(&index)
+	f.Close()
+	if err != nil {
+		return ocispec.Descriptor{}, ocispec.Manifest{}, fmt.Errorf("failed to read OCI layout index: %w", err)
+	}
+
+	var desc *ocispec.Descriptor
+	for i, d := range index.Manifests {
+		if refMatches(image, d.Annotations[ocispec.AnnotationRefName]) ||
+			refMatches(image, d.Annotations[containerdImageNameAnnotation]) {
+			desc = &index.Manifests[i]
+			break
+		}
+	}
+	if desc == nil {
+		return ocispec.Descriptor{}, ocispec.Manifest{}, fmt.Errorf(
+			"image %q not found in OCI layout %s, add it with: skopeo copy docker://%s oci:%s:%s",
+			image, l.dir, image, l.dir, image)
+	}
+
+	// multi platform images
+	for desc.MediaType == ocispec.MediaTypeImageIndex || desc.MediaType == mediaTypeDockerManifestList {
+		var nested ocispec.Index
+		if err := l.readBlob(desc.Digest, &nested); err != nil {
+			return ocispec.Descriptor{}, ocispec.Manifest{}, fmt.Errorf("failed to read image index: %w", err)
+		}
+		desc = nil
+		for i, d := range nested.Manifests {
+			if d.Platform != nil && d.Platform.OS == runtime.GOOS && d.Platform.Architecture == runtime.GOARCH {
+				desc = &nested.Manifests[i]
+				break
+			}
+		}
+		if desc == nil {
+			return ocispec.Descriptor{}, ocispec.Manifest{}, fmt.Errorf("image %q has no %s/%s variant", image, runtime.GOOS, runtime.GOARCH)
+		}
+	}
+
+	var manifest ocispec.Manifest
+	if err := l.readBlob(desc.Digest, &manifest); err != nil {
+		return ocispec.Descriptor{}, ocispec.Manifest{}, fmt.Errorf("failed to read image manifest: %w", err)
+	}
+	return *desc, manifest, nil
+}
+
+// refMatches reports whether the ref name stored in the layout is the image.
+// Layouts populated by skopeo only store the tag, e.g: "latest".
+func refMatches(image, ref string) bool {
+	if ref == "" {
+		return false
+	}
+	if ref == image {
+		return true
+	}
+	name, tag := image, "latest"
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		name, tag = image[:i], image[i+1:]
+	}
+	return ref == tag || ref == name+":"+tag
+}
+
+// unpack extracts the layers of the image into <dir>/rootfs/<manifest digest>
+// and returns the path of the root filesystem. Already unpacked images are
+// reused.
+func (l *ociLayout) unpack(image, dir string) (string, error) {
+	desc, manifest, err := l.findManifest(image)
+	if err != nil {
+		return "", err
+	}
+	rootfs := filepath.Join(dir, "rootfs", desc.Digest.Encoded())
+	if _, err := os.Stat(filepath.Join(rootfs, unpackedMarker)); err == nil {
+		return rootfs, nil
+	}
+
+	if err := os.RemoveAll(rootfs); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(rootfs, 0o755); err != nil {
+		return "", err
+	}
+	for _, layer := range manifest.Layers {
+		if err := l.unpackLayer(rootfs, layer); err != nil {
+			return "", fmt.Errorf("failed to unpack layer %s: %w", layer.Digest, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(rootfs, unpackedMarker), nil, 0o644); err != nil {
+		return "", err
+	}
+	return rootfs, nil
+}
+
+func (l *ociLayout) unpackLayer(rootfs string, layer ocispec.Descriptor) error {
+	f, err := l.openBlob(layer.Digest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := decompress(f)
+	if err != nil {
+		return err
+	}
+	return applyLayer(rootfs, r)
+}
+
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return nil, errors.New("zstd compressed layers are not supported")
+	default:
+		return br, nil
+	}
+}
+
+// applyLayer extracts a layer tar on top of rootfs, handling the whiteout
+// files of the OCI image spec. Device nodes are skipped, /dev is bind mounted
+// from the host when the debugger runs.
+func applyLayer(rootfs string, r io.Reader) error {
+	asRoot := os.Geteuid() == 0
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		dir, base := filepath.Split(filepath.Clean("/" + hdr.Name))
+		dir, err = resolveInRoot(rootfs, dir)
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(dir, base)
+		path := filepath.Join(rootfs, name)
+
+		if base == whiteoutOpaque {
+			entries, err := os.ReadDir(filepath.Join(rootfs, dir))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			for _, e := range entries {
+				if err := os.RemoveAll(filepath.Join(rootfs, dir, e.Name())); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(base, whiteoutPrefix) {
+			if err := os.RemoveAll(filepath.Join(rootfs, dir, strings.TrimPrefix(base, whiteoutPrefix))); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "/" {
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Join(rootfs, dir), 0o755); err != nil {
+			return err
+		}
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if fi, err := os.Lstat(path); err == nil && !fi.IsDir() {
+				os.RemoveAll(path)
+			}
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			os.RemoveAll(path)
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			os.RemoveAll(path)
+			if err := os.Symlink(hdr.Linkname, path); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			linkDir, linkBase := filepath.Split(filepath.Clean("/" + hdr.Linkname))
+			linkDir, err := resolveInRoot(rootfs, linkDir)
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(rootfs, linkDir, linkBase)
+			os.RemoveAll(path)
+			if err := os.Link(target, path); err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+
+		if asRoot {
+			if err := os.Lchown(path, hdr.Uid, hdr.Gid); err != nil {
+				return err
+			}
+		}
+		if hdr.Typeflag != tar.TypeSymlink {
+			if err := os.Chmod(path, mode.Perm()|mode&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// maxSymlinks is the number of symlinks resolveInRoot follows, as the
+// MAXSYMLINKS of linux.
+const maxSymlinks = 40
+
+// resolveInRoot resolves the symlinks of the parent directory of an entry as
+// if rootfs was the root, the layers write through the links of the image
+// (e.g. /bin -> usr/bin of the merged /usr images) but never outside of the
+// root filesystem. The returned directory is relative to rootfs.
+func resolveInRoot(rootfs, dir string) (string, error) {
+	resolved := "/"
+	parts := strings.Split(dir, "/")
+	links := 0
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			// the parent of the root is the root
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, part)
+		fi, err := os.Lstat(filepath.Join(rootfs, next))
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symlinks in %q", dir)
+		}
+		target, err := os.Readlink(filepath.Join(rootfs, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,218 @@
+package process
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+func buildLayer(t *testing.T, compress bool, entries ...tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0o755, Size: int64(len(e.body))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !compress {
+		return buf.Bytes()
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(buf.Bytes())
+	zw.Close()
+	return gz.Bytes()
+}
+
+func writeBlob(t *testing.T, dir string, mediaType string, data []byte) ocispec.Descriptor {
+	t.Helper()
+	d := digest.FromBytes(data)
+	path := filepath.Join(dir, "blobs", d.Algorithm().String(), d.Encoded())
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return ocispec.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(data))}
+}
+
+func writeJSONBlob(t *testing.T, dir string, mediaType string, v any) ocispec.Descriptor {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeBlob(t, dir, mediaType, data)
+}
+
+// writeLayout writes a multi platform image in the layout the same way
+// "skopeo copy --all" does, referenced by its tag only.
+func writeLayout(t *testing.T, dir, ref string, layers ...[]byte) {
+	t.Helper()
+	manifest := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    writeJSONBlob(t, dir, ocispec.MediaTypeImageConfig, ocispec.Image{}),
+	}
+	manifest.SchemaVersion = 2
+	for _, layer := range layers {
+		manifest.Layers = append(manifest.Layers, writeBlob(t, dir, ocispec.MediaTypeImageLayerGzip, layer))
+	}
+	manifestDesc := writeJSONBlob(t, dir, ocispec.MediaTypeImageManifest, manifest)
+	manifestDesc.Platform = &ocispec.Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+
+	other := writeJSONBlob(t, dir, ocispec.MediaTypeImageManifest, ocispec.Manifest{})
+	other.Platform = &ocispec.Platform{OS: "plan9", Architecture: "mips"}
+
+	index := ocispec.Index{MediaType: ocispec.MediaTypeImageIndex, Manifests: []ocispec.Descriptor{other, manifestDesc}}
+	index.SchemaVersion = 2
+	indexDesc := writeJSONBlob(t, dir, ocispec.MediaTypeImageIndex, index)
+	indexDesc.Annotations = map[string]string{ocispec.AnnotationRefName: ref}
+
+	top := ocispec.Index{Manifests: []ocispec.Descriptor{indexDesc}}
+	top.SchemaVersion = 2
+	data, err := json.Marshal(top)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnpack(t *testing.T) {
+	layoutDir, cacheDir := t.TempDir(), t.TempDir()
+	writeLayout(t, layoutDir, "musl",
+		buildLayer(t, true,
+			tarEntry{name: "bin/", typeflag: tar.TypeDir},
+			tarEntry{name: "bin/busybox", typeflag: tar.TypeReg, body: "busybox"},
+			tarEntry{name: "bin/sh", typeflag: tar.TypeSymlink, linkname: "busybox"},
+			tarEntry{name: "bin/ls", typeflag: tar.TypeLink, linkname: "bin/busybox"},
+			tarEntry{name: "etc/motd", typeflag: tar.TypeReg, body: "hello"},
+			tarEntry{name: "var/cache/apk/index", typeflag: tar.TypeReg, body: "index"},
+		),
+		buildLayer(t, false,
+			tarEntry{name: "etc/.wh.motd", typeflag: tar.TypeReg},
+			tarEntry{name: "var/cache/apk/.wh..wh..opq", typeflag: tar.TypeReg},
+			tarEntry{name: "var/cache/apk/new", typeflag: tar.TypeReg, body: "new"},
+		),
+	)
+
+	layout := &ociLayout{dir: layoutDir}
+	rootfs, err := layout.unpack("busybox:musl", cacheDir)
+	if err != nil {
+		t.Fatalf("unpack() error = %v", err)
+	}
+
+	if b, err := os.ReadFile(filepath.Join(rootfs, "bin", "sh")); err != nil || string(b) != "busybox" {
+		t.Errorf("bin/sh = %q, %v, want the busybox symlink", b, err)
+	}
+	if b, err := os.ReadFile(filepath.Join(rootfs, "bin", "ls")); err != nil || string(b) != "busybox" {
+		t.Errorf("bin/ls = %q, %v, want the busybox hardlink", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(rootfs, "etc", "motd")); !os.IsNotExist(err) {
+		t.Errorf("etc/motd was not removed by its whiteout")
+	}
+	entries, err := os.ReadDir(filepath.Join(rootfs, "var", "cache", "apk"))
+	if err != nil || len(entries) != 1 || entries[0].Name() != "new" {
+		t.Errorf("var/cache/apk = %v, %v, want only the entries of the opaque layer", entries, err)
+	}
+
+	// unpacked images are reused
+	if err := os.WriteFile(filepath.Join(rootfs, "marker"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	again, err := layout.unpack("busybox:musl", cacheDir)
+	if err != nil || again != rootfs {
+		t.Fatalf("unpack() = %q, %v, want %q", again, err, rootfs)
+	}
+	if _, err := os.Stat(filepath.Join(again, "marker")); err != nil {
+		t.Errorf("the unpacked image was extracted again")
+	}
+
+	if _, err := layout.unpack("alpine", cacheDir); err == nil {
+		t.Errorf("unpack() of a missing image succeeded")
+	}
+}
+
+func TestUnpackThroughSymlink(t *testing.T) {
+	layoutDir, cacheDir, outside := t.TempDir(), t.TempDir(), t.TempDir()
+	writeLayout(t, layoutDir, "latest",
+		buildLayer(t, true,
+			tarEntry{name: "usr/bin/", typeflag: tar.TypeDir},
+			tarEntry{name: "bin", typeflag: tar.TypeSymlink, linkname: "usr/bin"},
+			tarEntry{name: "escape", typeflag: tar.TypeSymlink, linkname: outside},
+			tarEntry{name: "up", typeflag: tar.TypeSymlink, linkname: "../../.."},
+		),
+		// the links of the merged /usr images are written through, the ones
+		// leaving the image resolve in it
+		buildLayer(t, false,
+			tarEntry{name: "bin/busybox", typeflag: tar.TypeReg, body: "busybox"},
+			tarEntry{name: "bin/ls", typeflag: tar.TypeLink, linkname: "bin/busybox"},
+			tarEntry{name: "escape/pwned", typeflag: tar.TypeReg, body: "pwned"},
+			tarEntry{name: "up/tmp/pwned", typeflag: tar.TypeReg, body: "pwned"},
+		),
+	)
+
+	rootfs, err := (&ociLayout{dir: layoutDir}).unpack("latest", cacheDir)
+	if err != nil {
+		t.Fatalf("unpack() error = %v", err)
+	}
+	for _, path := range []string{"usr/bin/busybox", "usr/bin/ls"} {
+		if b, err := os.ReadFile(filepath.Join(rootfs, path)); err != nil || string(b) != "busybox" {
+			t.Errorf("%s = %q, %v, want busybox", path, b, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outside, "pwned")); !os.IsNotExist(err) {
+		t.Errorf("the layer was extracted outside of the root filesystem")
+	}
+	for _, path := range []string{filepath.Join(outside, "pwned"), "tmp/pwned"} {
+		if _, err := os.Lstat(filepath.Join(rootfs, path)); err != nil {
+			t.Errorf("%s was not extracted in the root filesystem: %v", path, err)
+		}
+	}
+}
+
+func TestRefMatches(t *testing.T) {
+	tests := []struct {
+		image, ref string
+		want       bool
+	}{
+		{"busybox:musl", "musl", true},
+		{"busybox:musl", "busybox:musl", true},
+		{"ghcr.io/debasishbsws/conxec-debugger:latest", "latest", true},
+		{"ghcr.io/debasishbsws/conxec-debugger", "latest", true},
+		{"ghcr.io/debasishbsws/conxec-debugger", "ghcr.io/debasishbsws/conxec-debugger:latest", true},
+		{"localhost:5000/debugger", "latest", true},
+		{"busybox:musl", "glibc", false},
+		{"busybox:musl", "", false},
+	}
+	for _, tt := range tests {
+		if got := refMatches(tt.image, tt.ref); got != tt.want {
+			t.Errorf("refMatches(%q, %q) = %v, want %v", tt.image, tt.ref, got, tt.want)
+		}
+	}
+}
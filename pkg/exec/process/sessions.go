@@ -0,0 +1,170 @@
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/debasishbsws/conxec/pkg/exec"
+)
+
+// defaultSessionsDir keeps a file per running debugger, as there is no
+// runtime to ask for them.
+const defaultSessionsDir = "/run/conxec/sessions"
+
+// registration is the file of a running debugger session.
+type registration struct {
+	// Pid is the pid of the helper, Started its start time so a reused pid
+	// is not taken for the helper
+	Pid     int               `json:"pid"`
+	Started uint64            `json:"started"`
+	Labels  map[string]string `json:"labels"`
+}
+
+func (c *ProcessClient) register(name string, pid int, labels map[string]string) error {
+	started, err := startTime(pid)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(registration{Pid: pid, Started: started, Labels: labels})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.sessionsDir, 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(c.sessionsDir, name+".json"), b, 0o600)
+}
+
+func (c *ProcessClient) unregister(name string) {
+	os.Remove(filepath.Join(c.sessionsDir, name+".json"))
+}
+
+// lookup returns the registration of a running session, the ones of the
+// sessions which are gone are removed.
+func (c *ProcessClient) lookup(name string) (*registration, error) {
+	b, err := os.ReadFile(filepath.Join(c.sessionsDir, name+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var r registration
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("invalid registration of session %q: %w", name, err)
+	}
+	if started, err := startTime(r.Pid); err != nil || started != r.Started {
+		c.unregister(name)
+		return nil, os.ErrNotExist
+	}
+	return &r, nil
+}
+
+// ListSessions returns the running pid:// debuggers.
+func (c *ProcessClient) ListSessions(ctx context.Context) ([]exec.Session, error) {
+	entries, err := os.ReadDir(c.sessionsDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to list debugger sessions: %w", err)
+	}
+	sessions := []exec.Session{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		r, err := c.lookup(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, exec.SessionFromLabels(name, "running", r.Labels))
+	}
+	return sessions, nil
+}
+
+// StopSession kills the helper of the debugger and all its descendants, the
+// debugger processes live in the PID namespace of the target.
+func (c *ProcessClient) StopSession(ctx context.Context, id string) error {
+	r, err := c.lookup(id)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no running debugger session %q", id)
+	} else if err != nil {
+		return err
+	}
+	pids := append(descendants(r.Pid), r.Pid)
+	for _, pid := range pids {
+		if p, err := os.FindProcess(pid); err == nil {
+			p.Signal(os.Kill)
+		}
+	}
+	c.unregister(id)
+	return nil
+}
+
+// SessionLogs is not supported, the debugger writes directly to the terminal
+// of conxec.
+func (c *ProcessClient) SessionLogs(ctx context.Context, id string, follow bool, stdout, stderr io.Writer) error {
+	return fmt.Errorf("the output of pid:// debuggers is not kept, it only goes to the terminal of conxec")
+}
+
+// stat returns the fields of /proc/<pid>/stat following the command name.
+func stat(pid int) ([]string, error) {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	// the command name is in parentheses and may contain spaces
+	i := strings.LastIndexByte(string(b), ')')
+	if i == -1 {
+		return nil, fmt.Errorf("invalid stat of process %d", pid)
+	}
+	return strings.Fields(string(b[i+1:])), nil
+}
+
+// startTime returns the start time of the process in clock ticks.
+func startTime(pid int) (uint64, error) {
+	fields, err := stat(pid)
+	if err != nil {
+		return 0, err
+	}
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("invalid stat of process %d", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// descendants returns the pids of the children of the process, recursively.
+func descendants(pid int) []int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	children := map[int][]int{}
+	for _, entry := range entries {
+		p, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		fields, err := stat(p)
+		if err != nil || len(fields) < 2 {
+			continue
+		}
+		if ppid, err := strconv.Atoi(fields[1]); err == nil {
+			children[ppid] = append(children[ppid], p)
+		}
+	}
+	var pids []int
+	queue := children[pid]
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		pids = append(pids, p)
+		queue = append(queue, children[p]...)
+	}
+	return pids
+}
@@ -0,0 +1,71 @@
+package exec
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+)
+
+// SessionStopTimeout is how long StopSession waits for the entrypoint of the
+// debugger to end the command and remove its symlinks from the target, before
+// killing it.
+const SessionStopTimeout = 10 * time.Second
+
+// Labels set on every debugger container, so the sessions can be found back
+// with "conxec sessions".
+const (
+	LabelSession = "io.conxec.session"
+	LabelTarget  = "io.conxec.target"
+	LabelCommand = "io.conxec.command"
+	LabelUser    = "io.conxec.user"
+	LabelStarted = "io.conxec.started"
+)
+
+// SessionLabels returns the labels of the debugger container of the run.
+func SessionLabels(runID, targetID string, cmd []string, user string, started time.Time) map[string]string {
+	return map[string]string{
+		LabelSession: runID,
+		LabelTarget:  targetID,
+		LabelCommand: strings.Join(cmd, " "),
+		LabelUser:    user,
+		LabelStarted: started.UTC().Format(time.RFC3339),
+	}
+}
+
+// Session is a debugger container created by conxec.
+type Session struct {
+	ID      string // ID is the reference of the session for the backend, e.g: the container name
+	RunID   string
+	Target  string
+	Command string
+	User    string
+	Started time.Time
+	Status  string // Status is the state of the debugger as reported by the runtime, e.g: running
+}
+
+// SessionFromLabels fills the session from the labels of its container.
+func SessionFromLabels(id, status string, labels map[string]string) Session {
+	started, _ := time.Parse(time.RFC3339, labels[LabelStarted])
+	return Session{
+		ID:      id,
+		RunID:   labels[LabelSession],
+		Target:  labels[LabelTarget],
+		Command: labels[LabelCommand],
+		User:    labels[LabelUser],
+		Started: started,
+		Status:  status,
+	}
+}
+
+// SessionManager is implemented by the backends which can list the debugger
+// sessions they run.
+type SessionManager interface {
+	// ListSessions returns the debugger containers, running or not
+	ListSessions(ctx context.Context) ([]Session, error)
+	// StopSession stops the debugger and removes its container
+	StopSession(ctx context.Context, id string) error
+	// SessionLogs writes the output of the debugger, until it exits when
+	// follow is set
+	SessionLogs(ctx context.Context, id string, follow bool, stdout, stderr io.Writer) error
+}
@@ -0,0 +1,81 @@
+package exec
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Share is the set of namespaces of the target the debugger joins, Mnt is
+// the chroot into the root filesystem of the target. The debugger keeps its
+// own namespaces for the others, where the runtime allows it.
+type Share struct {
+	Net bool
+	Pid bool
+	IPC bool
+	UTS bool
+	Mnt bool
+}
+
+// DefaultShare joins the network and PID namespaces of the target and runs
+// the command in its root filesystem.
+var DefaultShare = Share{Net: true, Pid: true, Mnt: true}
+
+// ParseShare parses a comma separated list of namespaces, e.g. "net,pid".
+func ParseShare(s string) (Share, error) {
+	var share Share
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSpace(name) {
+		case "net":
+			share.Net = true
+		case "pid":
+			share.Pid = true
+		case "ipc":
+			share.IPC = true
+		case "uts":
+			share.UTS = true
+		case "mnt":
+			share.Mnt = true
+		case "":
+		default:
+			return Share{}, fmt.Errorf("invalid namespace %q: want net, pid, ipc, uts or mnt", name)
+		}
+	}
+	if share == (Share{}) {
+		return Share{}, fmt.Errorf("no namespace to share: want some of net, pid, ipc, uts and mnt")
+	}
+	// the root filesystem of the target is reached through /proc/<pid>/root
+	if share.Mnt && !share.Pid {
+		return Share{}, fmt.Errorf("invalid namespaces %q: mnt needs pid, the root of the target is found from its processes", s)
+	}
+	return share, nil
+}
+
+// Require returns an error when s leaves out namespaces of always, the ones a
+// runtime shares with its debuggers whatever they ask for, why tells the
+// user which runtime and why.
+func (s Share) Require(always Share, why string) error {
+	missing := Share{
+		Net: always.Net && !s.Net,
+		Pid: always.Pid && !s.Pid,
+		IPC: always.IPC && !s.IPC,
+		UTS: always.UTS && !s.UTS,
+		Mnt: always.Mnt && !s.Mnt,
+	}
+	if missing == (Share{}) {
+		return nil
+	}
+	return fmt.Errorf("%s, add %s to --share", why, missing)
+}
+
+func (s Share) String() string {
+	var names []string
+	for _, ns := range []struct {
+		name   string
+		shared bool
+	}{{"net", s.Net}, {"pid", s.Pid}, {"ipc", s.IPC}, {"uts", s.UTS}, {"mnt", s.Mnt}} {
+		if ns.shared {
+			names = append(names, ns.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
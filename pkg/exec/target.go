@@ -0,0 +1,131 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/debasishbsws/conxec/pkg/iocli"
+)
+
+const (
+	// targetExitGrace is how long the exit of the target is waited for once
+	// the debugger was killed, a debugger in the PID namespace of the target
+	// is killed with it and usually exits first.
+	targetExitGrace = 2 * time.Second
+	// debuggerStopTimeout is how long the debugger of an exited target is
+	// waited for before giving up on it.
+	debuggerStopTimeout = 15 * time.Second
+
+	targetRestartTimeout = 5 * time.Minute
+	targetRestartPoll    = time.Second
+)
+
+// TargetExit is the end of the target, as reported by the runtime.
+type TargetExit struct {
+	ExitCode  int
+	OOMKilled bool
+}
+
+func (e TargetExit) String() string {
+	if e.OOMKilled {
+		return fmt.Sprintf("was OOMKilled (exit code %d)", e.ExitCode)
+	}
+	return fmt.Sprintf("exited with code %d", e.ExitCode)
+}
+
+// TargetWatcher is implemented by the backends which can report the end of
+// the target while the debugger runs.
+type TargetWatcher interface {
+	// WatchTarget sends the exit of the target, until ctx is done
+	WatchTarget(ctx context.Context, targetID string) (<-chan TargetExit, error)
+}
+
+// TargetExitError ends the debugger session of a target which exited.
+type TargetExitError struct {
+	Target string
+	Exit   TargetExit
+}
+
+func (e *TargetExitError) Error() string {
+	return fmt.Sprintf("target %q %s, the debugger session ended", e.Target, e.Exit)
+}
+
+// attachWatchingTarget attaches to the debugger and ends it when the target
+// exits, with a TargetExitError telling why.
+func attachWatchingTarget(ctx context.Context, client DebuggerClient, debuggerID, targetID string, opts *ExecOptions, cliStream *iocli.CliStream) error {
+	watcher, ok := client.(TargetWatcher)
+	if !ok {
+		return client.AttachContainer(ctx, debuggerID, opts.Tty, opts.Stdin, cliStream)
+	}
+	attachCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	exits, err := watcher.WatchTarget(attachCtx, targetID)
+	if err != nil {
+		cliStream.PrintAux("Not watching the target: %s\n", err)
+	}
+
+	attached := make(chan error, 1)
+	go func() {
+		attached <- client.AttachContainer(attachCtx, debuggerID, opts.Tty, opts.Stdin, cliStream)
+	}()
+
+	select {
+	case err := <-attached:
+		var statusErr iocli.StatusError
+		if exits == nil || !errors.As(err, &statusErr) || statusErr.Code() != 128+int(syscall.SIGKILL) {
+			return err
+		}
+		select {
+		case exit := <-exits:
+			return &TargetExitError{Target: opts.Target, Exit: exit}
+		case <-time.After(targetExitGrace):
+			return err
+		}
+	case exit := <-exits:
+		stopDebugger(ctx, client, debuggerID, cliStream)
+		select {
+		case <-attached:
+		case <-time.After(debuggerStopTimeout):
+			cancel()
+			<-attached
+		}
+		return &TargetExitError{Target: opts.Target, Exit: exit}
+	}
+}
+
+// stopDebugger kills the debugger of an exited target, its entrypoint can't
+// clean up in a target which is gone.
+func stopDebugger(ctx context.Context, client DebuggerClient, debuggerID string, cliStream *iocli.CliStream) {
+	ctx = context.WithoutCancel(ctx)
+	var err error
+	if signalClient, ok := client.(SignalClient); ok {
+		err = signalClient.SignalContainer(ctx, debuggerID, syscall.SIGKILL)
+	} else if manager, ok := client.(SessionManager); ok {
+		err = manager.StopSession(ctx, debuggerID)
+	}
+	if err != nil {
+		cliStream.PrintAux("failed to stop the debugger: %s\n", err)
+	}
+}
+
+// waitTargetRestart waits for the target to run again after it exited.
+func waitTargetRestart(ctx context.Context, client DebuggerClient, target string) error {
+	ctx, cancel := context.WithTimeout(ctx, targetRestartTimeout)
+	defer cancel()
+	ticker := time.NewTicker(targetRestartPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("target %q did not restart within %s", target, targetRestartTimeout)
+		case <-ticker.C:
+		}
+		info, err := client.GetContainerInfo(ctx, target)
+		if err == nil && info.Isrunning {
+			return nil
+		}
+	}
+}
@@ -13,6 +13,7 @@ type CliStream struct {
 	outputStream *streams.Out
 	auxStream    *streams.Out
 	errorStream  io.Writer
+	recorder     *Recorder
 }
 
 var CLI = &CliStream{}
@@ -42,6 +43,17 @@ func (c *CliStream) ErrorStream() io.Writer {
 	return c.errorStream
 }
 
+// SetRecorder records the sessions attached with the streams.
+func (c *CliStream) SetRecorder(r *Recorder) {
+	c.recorder = r
+}
+
+// Recorder returns the recorder of the sessions, nil when they are not
+// recorded.
+func (c *CliStream) Recorder() *Recorder {
+	return c.recorder
+}
+
 func (c *CliStream) SetQuiet(v bool) {
 	if v {
 		c.auxStream = streams.NewOut(io.Discard)
@@ -0,0 +1,170 @@
+package iocli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Recorder writes the output of a session, what is typed in it and the
+// resizes of its terminal to an asciicast v2 file, see
+// https://docs.asciinema.org/manual/asciicast/v2/. The methods of a nil
+// Recorder do nothing, so the backends use it without checking.
+type Recorder struct {
+	mu    sync.Mutex
+	out   io.WriteCloser
+	enc   *json.Encoder
+	start time.Time
+	// partial holds the end of the data of an event which is not a whole
+	// UTF-8 character yet, per event type
+	partial map[string][]byte
+	err     error
+}
+
+// asciicastHeader is the first line of an asciicast v2 file.
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     uint              `json:"width"`
+	Height    uint              `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// CreateRecording creates the recording file of a session, readable by its
+// owner only as the input is recorded too. The size is the one of the
+// terminal of the streams, 80x24 without terminal.
+func CreateRecording(path, command, title string, streams *CliStream) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+	height, width := streams.OutputStream().GetTtySize()
+	if height == 0 || width == 0 {
+		height, width = 24, 80
+	}
+	r, err := newRecorder(f, width, height, asciicastHeader{
+		Command: command,
+		Title:   title,
+		Env:     map[string]string{"TERM": os.Getenv("TERM"), "SHELL": os.Getenv("SHELL")},
+	}, time.Now())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// newRecorder writes the header of the recording to out, the events are timed
+// from start.
+func newRecorder(out io.WriteCloser, width, height uint, header asciicastHeader, start time.Time) (*Recorder, error) {
+	header.Version = 2
+	header.Width, header.Height = width, height
+	header.Timestamp = start.Unix()
+	r := &Recorder{
+		out:     out,
+		enc:     json.NewEncoder(out),
+		start:   start,
+		partial: map[string][]byte{},
+	}
+	// the data is replayed in a terminal, it is not escaped for HTML
+	r.enc.SetEscapeHTML(false)
+	if err := r.enc.Encode(header); err != nil {
+		return nil, fmt.Errorf("failed to write recording: %w", err)
+	}
+	return r, nil
+}
+
+// event writes an event, data is cut at the last whole UTF-8 character and
+// the rest is kept for the next event of the type. A failing recording does
+// not break the session, the error is returned by Close.
+func (r *Recorder) event(code string, data []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	data = append(r.partial[code], data...)
+	end := len(data)
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				end = len(data) - i
+			}
+			break
+		}
+	}
+	r.partial[code] = append([]byte(nil), data[end:]...)
+	if end == 0 {
+		return
+	}
+	elapsed := time.Since(r.start).Seconds()
+	r.err = r.enc.Encode([]any{elapsed, code, string(data[:end])})
+}
+
+// Resize records the new size of the terminal.
+func (r *Recorder) Resize(width, height uint) {
+	r.event("r", []byte(fmt.Sprintf("%dx%d", width, height)))
+}
+
+// Close ends the recording, it returns the first error writing it.
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.out.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	if r.err != nil {
+		return fmt.Errorf("failed to write recording: %w", r.err)
+	}
+	return nil
+}
+
+// TeeOutput returns a writer recording what is written to w.
+func (r *Recorder) TeeOutput(w io.Writer) io.Writer {
+	if r == nil {
+		return w
+	}
+	return &recordWriter{w: w, r: r}
+}
+
+// TeeInput returns a reader recording what is read from in.
+func (r *Recorder) TeeInput(in io.Reader) io.Reader {
+	if r == nil {
+		return in
+	}
+	return &recordReader{in: in, r: r}
+}
+
+type recordWriter struct {
+	w io.Writer
+	r *Recorder
+}
+
+func (w *recordWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.r.event("o", p[:n])
+	return n, err
+}
+
+type recordReader struct {
+	in io.Reader
+	r  *Recorder
+}
+
+func (r *recordReader) Read(p []byte) (int, error) {
+	n, err := r.in.Read(p)
+	r.r.event("i", p[:n])
+	return n, err
+}
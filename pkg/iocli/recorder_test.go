@@ -0,0 +1,88 @@
+package iocli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestRecordReplay(t *testing.T) {
+	var recording bytes.Buffer
+	r, err := newRecorder(nopWriteCloser{&recording}, 80, 24, asciicastHeader{Command: "sh"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var shown bytes.Buffer
+	out := r.TeeOutput(&shown)
+	in, _ := io.ReadAll(r.TeeInput(strings.NewReader("ls\n")))
+	out.Write([]byte("caf\xc3")) // "é" split across two writes
+	r.Resize(120, 40)
+	out.Write([]byte("\xa9\r\n"))
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if string(in) != "ls\n" || shown.String() != "café\r\n" {
+		t.Errorf("streams = %q and %q, want them unchanged", in, shown.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(recording.String()), "\n")
+	var header asciicastHeader
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil || header.Version != 2 || header.Width != 80 || header.Command != "sh" {
+		t.Errorf("header = %s, want an asciicast v2 header", lines[0])
+	}
+	var events [][]any
+	for _, line := range lines[1:] {
+		var event []any
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("invalid event %s: %v", line, err)
+		}
+		events = append(events, event[1:])
+	}
+	want := [][]any{{"i", "ls\n"}, {"o", "caf"}, {"r", "120x40"}, {"o", "é\r\n"}}
+	if len(events) != len(want) {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i][0] != want[i][0] || events[i][1] != want[i][1] {
+			t.Errorf("event %d = %v, want %v", i, events[i], want[i])
+		}
+	}
+
+	var replayed bytes.Buffer
+	if err := Replay(context.Background(), &recording, &replayed, 100); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if replayed.String() != "café\r\n" {
+		t.Errorf("replayed %q, want the output only", replayed.String())
+	}
+}
+
+func TestReplaySpeed(t *testing.T) {
+	recording := `{"version": 2, "width": 80, "height": 24}
+[0.1, "o", "a"]
+[0.4, "o", "b"]
+`
+	start := time.Now()
+	var replayed bytes.Buffer
+	if err := Replay(context.Background(), strings.NewReader(recording), &replayed, 4); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 300*time.Millisecond {
+		t.Errorf("replay took %s, want 100ms at 4x", elapsed)
+	}
+	if replayed.String() != "ab" {
+		t.Errorf("replayed %q, want %q", replayed.String(), "ab")
+	}
+
+	if err := Replay(context.Background(), strings.NewReader(`{"version": 1}`), io.Discard, 1); err == nil {
+		t.Errorf("Replay() of an asciicast v1 succeeded")
+	}
+}
@@ -0,0 +1,58 @@
+package iocli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Replay writes the output of an asciicast v2 recording to w, with the timing
+// of the recording divided by speed. The input and the resizes are skipped,
+// the terminal can't be resized.
+func Replay(ctx context.Context, r io.Reader, w io.Writer, speed float64) error {
+	if speed <= 0 {
+		return fmt.Errorf("invalid speed %v: it must be positive", speed)
+	}
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var header asciicastHeader
+	if err := dec.Decode(&header); err != nil {
+		return fmt.Errorf("invalid recording: %w", err)
+	}
+	if header.Version != 2 {
+		return fmt.Errorf("unsupported recording: asciicast version %d, only 2 is supported", header.Version)
+	}
+
+	start := time.Now()
+	for n := 1; ; n++ {
+		var event []json.RawMessage
+		if err := dec.Decode(&event); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("invalid event %d of the recording: %w", n, err)
+		}
+		var elapsed float64
+		var code, data string
+		if len(event) != 3 || json.Unmarshal(event[0], &elapsed) != nil ||
+			json.Unmarshal(event[1], &code) != nil || json.Unmarshal(event[2], &data) != nil {
+			return fmt.Errorf("invalid event %d of the recording: want [time, code, data]", n)
+		}
+		if code != "o" {
+			continue
+		}
+		// the events are timed from the start, so the delays don't add up
+		at := start.Add(time.Duration(elapsed / speed * float64(time.Second)))
+		if wait := time.Until(at); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+		if _, err := io.WriteString(w, data); err != nil {
+			return err
+		}
+	}
+}
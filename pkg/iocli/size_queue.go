@@ -0,0 +1,51 @@
+package iocli
+
+import (
+	"context"
+	"sync"
+
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// TerminalSizeQueue feeds the terminal size changes reported by StartResizing
+// to a remotecommand stream (pods/attach or the CRI streaming server). It is
+// closed once the stream ends, which ends the goroutine of the stream waiting
+// for the next size.
+type TerminalSizeQueue struct {
+	ch   chan remotecommand.TerminalSize
+	done chan struct{}
+	once sync.Once
+}
+
+var _ remotecommand.TerminalSizeQueue = &TerminalSizeQueue{}
+
+func NewTerminalSizeQueue() *TerminalSizeQueue {
+	return &TerminalSizeQueue{ch: make(chan remotecommand.TerminalSize, 1), done: make(chan struct{})}
+}
+
+// Resize is a ResizeFunc queueing the new size, the sizes are dropped once
+// the queue is closed.
+func (q *TerminalSizeQueue) Resize(ctx context.Context, height, width uint) error {
+	select {
+	case q.ch <- remotecommand.TerminalSize{Height: uint16(height), Width: uint16(width)}:
+	case <-q.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// Next returns the next size, nil once the queue is closed.
+func (q *TerminalSizeQueue) Next() *remotecommand.TerminalSize {
+	select {
+	case size := <-q.ch:
+		return &size
+	case <-q.done:
+		return nil
+	}
+}
+
+// Close ends the queue, it can be called more than once.
+func (q *TerminalSizeQueue) Close() {
+	q.once.Do(func() { close(q.done) })
+}
@@ -0,0 +1,37 @@
+package iocli
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+func TestTerminalSizeQueueClose(t *testing.T) {
+	q := NewTerminalSizeQueue()
+	if err := q.Resize(context.Background(), 40, 120); err != nil {
+		t.Fatalf("Resize() error = %v", err)
+	}
+	if size := q.Next(); size == nil || *size != (remotecommand.TerminalSize{Height: 40, Width: 120}) {
+		t.Errorf("Next() = %v, want 40x120", size)
+	}
+
+	next := make(chan *remotecommand.TerminalSize)
+	go func() { next <- q.Next() }()
+	q.Close()
+	q.Close()
+	select {
+	case size := <-next:
+		if size != nil {
+			t.Errorf("Next() = %v after Close, want nil", size)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Next() still waits after Close")
+	}
+	// the queue is full, a resize after Close doesn't block
+	q.Resize(context.Background(), 1, 1)
+	if err := q.Resize(context.Background(), 50, 150); err != nil {
+		t.Errorf("Resize() after Close error = %v", err)
+	}
+}
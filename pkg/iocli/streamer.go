@@ -0,0 +1,130 @@
+package iocli
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+
+	"github.com/docker/docker/api/types"
+	"github.com/moby/moby/pkg/stdcopy"
+	"github.com/moby/term"
+)
+
+// ErrDetached is returned when the user typed the detach keys, the debugger
+// keeps running.
+var ErrDetached = errors.New("detached from the debugger")
+
+// ErrTakeControl is returned when the user of a read-only session typed the
+// control keys, the session is attached again with its input.
+var ErrTakeControl = errors.New("taking control of the debugger")
+
+// IOStreamer copies the local streams to and from a hijacked attach
+// connection of the Docker or the Podman API.
+type IOStreamer struct {
+	Streams *CliStream
+
+	InputStream  io.ReadCloser
+	OutputStream io.Writer
+	ErrorStream  io.Writer
+
+	Resp types.HijackedResponse
+
+	Stdin bool
+	Tty   bool
+	// ReadOnly reads the input of the TTY for the detach and the control
+	// keys only, nothing is sent to the debugger
+	ReadOnly bool
+	// DetachKeys end the stream with ErrDetached when typed on the TTY
+	DetachKeys []byte
+	// ControlKeys end the stream of a read-only session with ErrTakeControl
+	// when typed on the TTY
+	ControlKeys []byte
+}
+
+func (s *IOStreamer) Stream(ctx context.Context) error {
+	if s.Tty {
+		s.Streams.InputStream().SetRawTerminal()
+		s.Streams.OutputStream().SetRawTerminal()
+		defer func() {
+			s.Streams.InputStream().RestoreTerminal()
+			s.Streams.OutputStream().RestoreTerminal()
+		}()
+	}
+
+	var in io.Reader = s.InputStream
+	if s.Tty && len(s.DetachKeys) > 0 {
+		in = escapeKeys(in, s.DetachKeys, ErrDetached)
+	}
+	if s.Tty && s.ReadOnly && len(s.ControlKeys) > 0 {
+		in = escapeKeys(in, s.ControlKeys, ErrTakeControl)
+	}
+	// the streams of the session are recorded, the input as the debugger
+	// gets it, without the detach keys
+	recorder := s.Streams.Recorder()
+	in = recorder.TeeInput(in)
+	stdout, stderr := recorder.TeeOutput(s.OutputStream), recorder.TeeOutput(s.ErrorStream)
+	inDone := make(chan error, 1)
+	go func() {
+		var conn io.Writer = s.Resp.Conn
+		if s.ReadOnly {
+			conn = io.Discard
+		}
+		if s.Stdin || (s.ReadOnly && s.Tty) {
+			if _, err := io.Copy(conn, in); err != nil {
+				if errors.Is(err, ErrDetached) || errors.Is(err, ErrTakeControl) {
+					inDone <- err
+					return
+				}
+				log.Printf("Error forwarding stdin: %s", err)
+			}
+		}
+		close(inDone)
+	}()
+
+	outDone := make(chan error)
+	go func() {
+		var err error
+		if s.Tty {
+			_, err = io.Copy(stdout, s.Resp.Reader)
+		} else {
+			_, err = stdcopy.StdCopy(stdout, stderr, s.Resp.Reader)
+		}
+		if err != nil {
+			log.Printf("Error forwarding stdout/stderr: %s", err)
+		}
+		close(outDone)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-inDone:
+		if err != nil {
+			return err
+		}
+		<-outDone
+		return nil
+	case <-outDone:
+		return nil
+	}
+}
+
+// keysReader ends the input with err once its keys are typed, the keys are
+// not read.
+type keysReader struct {
+	in  io.Reader
+	err error
+}
+
+func escapeKeys(in io.Reader, keys []byte, err error) io.Reader {
+	return &keysReader{in: term.NewEscapeProxy(in, keys), err: err}
+}
+
+func (r *keysReader) Read(p []byte) (int, error) {
+	n, err := r.in.Read(p)
+	if errors.As(err, &term.EscapeError{}) {
+		err = r.err
+	}
+	return n, err
+}
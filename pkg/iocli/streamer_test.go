@@ -0,0 +1,87 @@
+package iocli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestStreamDetach(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	received := make(chan string, 1)
+	go func() {
+		data, _ := io.ReadAll(bufio.NewReader(remote))
+		received <- string(data)
+	}()
+
+	streams := NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard)
+	s := IOStreamer{
+		Streams:      streams,
+		InputStream:  io.NopCloser(strings.NewReader("ls\n\x10\x11exit\n")),
+		OutputStream: io.Discard,
+		ErrorStream:  io.Discard,
+		Resp:         types.HijackedResponse{Conn: local, Reader: bufio.NewReader(local)},
+		Stdin:        true,
+		Tty:          true,
+		DetachKeys:   []byte{16, 17}, // ctrl-p,ctrl-q
+	}
+	if err := s.Stream(context.Background()); !errors.Is(err, ErrDetached) {
+		t.Fatalf("Stream() error = %v, want ErrDetached", err)
+	}
+	local.Close()
+	if got := <-received; got != "ls\n" {
+		t.Errorf("debugger input = %q, want the input before the detach keys", got)
+	}
+}
+
+func TestStreamReadOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr error
+	}{
+		{name: "control keys", input: "ls\n\x10\x14exit\n", wantErr: ErrTakeControl},
+		{name: "detach keys", input: "ls\n\x10\x11exit\n", wantErr: ErrDetached},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, remote := net.Pipe()
+			defer local.Close()
+			defer remote.Close()
+			received := make(chan string, 1)
+			go func() {
+				data, _ := io.ReadAll(bufio.NewReader(remote))
+				received <- string(data)
+			}()
+
+			streams := NewCliStream(io.NopCloser(&bytes.Buffer{}), io.Discard, io.Discard)
+			s := IOStreamer{
+				Streams:      streams,
+				InputStream:  io.NopCloser(strings.NewReader(tt.input)),
+				OutputStream: io.Discard,
+				ErrorStream:  io.Discard,
+				Resp:         types.HijackedResponse{Conn: local, Reader: bufio.NewReader(local)},
+				Tty:          true,
+				ReadOnly:     true,
+				DetachKeys:   []byte{16, 17}, // ctrl-p,ctrl-q
+				ControlKeys:  []byte{16, 20}, // ctrl-p,ctrl-t
+			}
+			if err := s.Stream(context.Background()); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Stream() error = %v, want %v", err, tt.wantErr)
+			}
+			local.Close()
+			if got := <-received; got != "" {
+				t.Errorf("debugger input = %q, want none in a read-only session", got)
+			}
+		})
+	}
+}
@@ -7,33 +7,52 @@ import (
 	"time"
 
 	"github.com/docker/cli/cli/streams"
-	"github.com/docker/docker/api/types"
-	dockerclient "github.com/docker/docker/client"
 	mobysignal "github.com/moby/sys/signal"
 	"github.com/sirupsen/logrus"
 )
 
+// ResizeFunc resizes the TTY of the debugger container to the given size.
+type ResizeFunc func(ctx context.Context, height, width uint) error
+
+// StartResizing resizes the TTY of the debugger to the one of the output
+// stream, now and each time the terminal is resized, until ctx is done. The
+// sizes are recorded with the session.
 func StartResizing(
 	ctx context.Context,
-	out *streams.Out,
-	client dockerclient.ContainerAPIClient,
-	contID string,
+	cliStream *CliStream,
+	resizeFn ResizeFunc,
 ) {
+	out := cliStream.OutputStream()
+	if recorder := cliStream.Recorder(); recorder != nil {
+		next := resizeFn
+		resizeFn = func(ctx context.Context, height, width uint) error {
+			recorder.Resize(width, height)
+			return next(ctx, height, width)
+		}
+	}
 	go func() {
-		for retry := 0; retry < 10; retry++ {
-			if err := resize(ctx, out, client, contID); err == nil {
+		for retry := 0; retry < 10 && ctx.Err() == nil; retry++ {
+			if err := resize(ctx, out, resizeFn); err == nil {
 				return
 			}
 			time.Sleep(time.Duration(retry+1) * 10 * time.Millisecond)
 		}
-		logrus.Warn("Cannot resize TTY")
+		if ctx.Err() == nil {
+			logrus.Warn("Cannot resize TTY")
+		}
 	}()
 
 	sigchan := make(chan os.Signal, 1)
 	signal.Notify(sigchan, mobysignal.SIGWINCH)
 	go func() {
-		for range sigchan {
-			resize(ctx, out, client, contID)
+		defer signal.Stop(sigchan)
+		for {
+			select {
+			case <-sigchan:
+				resize(ctx, out, resizeFn)
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 }
@@ -41,15 +60,14 @@ func StartResizing(
 func resize(
 	ctx context.Context,
 	out *streams.Out,
-	client dockerclient.ContainerAPIClient,
-	contID string,
+	resizeFn ResizeFunc,
 ) error {
 	height, width := out.GetTtySize()
 	if height == 0 && width == 0 {
 		return nil
 	}
 
-	if err := client.ContainerResize(ctx, contID, types.ResizeOptions{Height: height, Width: width}); err != nil {
+	if err := resizeFn(ctx, height, width); err != nil {
 		logrus.WithError(err).Debug("TTY resize error")
 		return err
 	}
@@ -0,0 +1,64 @@
+package iocli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestStartResizingStops(t *testing.T) {
+	ptmx, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminal: %v", err)
+	}
+	defer ptmx.Close()
+	if err := unix.IoctlSetPointerInt(int(ptmx.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatal(err)
+	}
+	n, err := unix.IoctlGetInt(int(ptmx.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tty, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tty.Close()
+	if err := unix.IoctlSetWinsize(int(ptmx.Fd()), unix.TIOCSWINSZ, &unix.Winsize{Row: 40, Col: 120}); err != nil {
+		t.Fatal(err)
+	}
+
+	resizes := make(chan [2]uint, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	cliStream := NewCliStream(io.NopCloser(tty), tty, io.Discard)
+	StartResizing(ctx, cliStream, func(ctx context.Context, height, width uint) error {
+		resizes <- [2]uint{height, width}
+		return nil
+	})
+	select {
+	case size := <-resizes:
+		if size != [2]uint{40, 120} {
+			t.Errorf("resized to %v, want 40x120", size)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the TTY was not resized")
+	}
+
+	// the terminal is resized once the attach ended
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGWINCH); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case size := <-resizes:
+		t.Errorf("resized to %v after the end of the attach", size)
+	case <-time.After(100 * time.Millisecond):
+	}
+}